@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	_ "nodosml-pc4/docs" // swagger docs
 
 	"nodosml-pc4/internal/cache"
+	"nodosml-pc4/internal/cluster"
 	"nodosml-pc4/internal/config"
 	"nodosml-pc4/internal/db"
 	"nodosml-pc4/internal/handler"
+	"nodosml-pc4/internal/ml"
 	"nodosml-pc4/internal/repository"
 	"nodosml-pc4/internal/service"
 
@@ -43,6 +47,15 @@ func main() {
 	ratingRepo := repository.NewRatingRepository()
 	recRepo := repository.NewRecommendationRepository()
 	simRepo := repository.NewSimilarityRepository()
+	mfRepo := repository.NewMFRepository()
+	eventRepo := repository.NewEventRepository()
+	experimentRepo := repository.NewExperimentRepository()
+	simStatsRepo := repository.NewSimilarityStatsRepository()
+
+	// a lo sumo un experimento en curso (lo garantiza Mongo)
+	if err := experimentRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("índice de experimentos: %v", err)
+	}
 
 	// ============================
 	// Leer direcciones de nodos ML
@@ -67,15 +80,39 @@ func main() {
 		}
 	}
 
+	// registro de nodos ML: ping periódico + alta/baja en runtime
+	nodeRegistry := cluster.NewRegistry(cluster.DefaultClient, mlNodes, cluster.RegistryOptions{
+		Interval: 5 * time.Second,
+		Timeout:  2 * time.Second,
+	})
+	nodeRegistry.Start(context.Background())
+
 	// services
 	authSvc := service.NewAuthService(userRepo, cfg.JWTSecret)
 	movieSvc := service.NewMovieService(movieRepo, cfg.TMDBAPIKey)
 	movieReqSvc := service.NewMovieRequestService(movieReqRepo, movieRepo, movieSvc)
-	ratingSvc := service.NewRatingService(ratingRepo, movieRepo)
+	// similitudes incrementales: los ratings encolan, un worker aplica
+	simUpdater := service.NewSimilarityUpdater(simStatsRepo, ratingRepo, movieRepo, simRepo, nodeRegistry)
+	simUpdater.Start(context.Background())
+	ratingSvc := service.NewRatingService(ratingRepo, movieRepo, simUpdater)
+	// modelos de factorización (se entrenan en los nodos ML)
+	mfSvc := service.NewMFService(mfRepo, nodeRegistry)
+	// peso de vistas / clics / watchlist como ratings implícitos
+	implicitWeights, err := ml.ParseImplicitWeights(cfg.ImplicitWeights)
+	if err != nil {
+		log.Fatalf("IMPLICIT_WEIGHTS: %v", err)
+	}
+	// experimentos A/B (reparto de usuarios entre variantes)
+	experimentSvc := service.NewExperimentService(experimentRepo, eventRepo)
 	// coordinador que habla con los nodos ML + guarda historial + explicaciones
-	recSvc := service.NewRecommendService(ratingRepo, recRepo, simRepo, mlNodes)
+	recSvc := service.NewRecommendService(ratingRepo, movieRepo, userRepo, recRepo, simRepo, nodeRegistry, mfSvc, eventRepo, implicitWeights, experimentSvc)
+	// eventos de interacción (feedback implícito)
+	eventSvc := service.NewEventService(eventRepo, movieRepo)
+	// precálculo nocturno de recomendaciones (usa el mismo coordinador)
+	precomputeSvc := service.NewPrecomputeService(recSvc, userRepo, ratingRepo)
 	// servicio de mantenimiento admin
-	adminMaintSvc := service.NewAdminMaintenanceService(cfg, mlNodes)
+	adminMaintSvc := service.NewAdminMaintenanceService(cfg, nodeRegistry, simUpdater, mfSvc)
+	clusterSvc := service.NewClusterService(nodeRegistry)
 
 	// handlers
 	authH := handler.NewAuthHandler(authSvc)
@@ -84,6 +121,11 @@ func main() {
 	ratingH := handler.NewRatingHandler(ratingSvc)
 	recH := handler.NewRecommendHandler(recSvc)
 	adminMaintH := handler.NewAdminMaintenanceHandler(adminMaintSvc)
+	clusterH := handler.NewClusterHandler(clusterSvc)
+	mfH := handler.NewMFHandler(mfSvc)
+	precomputeH := handler.NewPrecomputeHandler(precomputeSvc)
+	eventH := handler.NewEventHandler(eventSvc)
+	experimentH := handler.NewExperimentHandler(experimentSvc)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
@@ -107,8 +149,15 @@ func main() {
 			"Authorization",
 			"Content-Type",
 			"X-CSRF-Token",
+			"X-Cluster-Token",
+		},
+		ExposedHeaders: []string{
+			"Link",
+			"X-Recommendations-Partial",
+			"X-Recommendations-Failed-Shards",
+			"X-Experiment",
+			"X-Experiment-Variant",
 		},
-		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300, // 5 minutos
 	}))
@@ -129,6 +178,15 @@ func main() {
 	r.Get("/movies/{id}", movieH.GetMovie)
 	r.Get("/movies/search", movieH.Search)
 	r.Get("/movies/top", movieH.Top)
+	r.Get("/movies/{id}/similar", recH.GetSimilarMovies)
+
+	// auto-registro de nodos ML (token compartido, no JWT); sin token no se
+	// expone: los nodos se agregan por ML_NODE_ADDRS o /admin/cluster/nodes
+	if cfg.ClusterToken != "" {
+		handler.MountClusterRoutes(r, clusterH, cfg.ClusterToken)
+	} else {
+		log.Println("[cluster] CLUSTER_TOKEN vacío: auto-registro de nodos deshabilitado")
+	}
 
 	// ===========================
 	// Rutas protegidas con JWT
@@ -143,6 +201,10 @@ func main() {
 			r.Get("/ratings", ratingH.GetMyRatings)
 			r.Post("/ratings", ratingH.PostMyRating)
 			r.Get("/recommendations", recH.GetMyRecommendations)
+			r.Get("/recommendations/{movieId}/explain", recH.GetMyExplanation)
+			r.Get("/recommendations/history", recH.GetMyHistory)
+			r.Get("/recommendations/history/diff", recH.GetMyHistoryDiff)
+			r.Post("/events", eventH.PostMyEvent)
 
 			// movie requests (USER)
 			r.Get("/movie-requests", movieReqH.ListMine)
@@ -168,6 +230,9 @@ func main() {
 
 				// HTTP normal
 				r.Get("/recommendations", recH.GetRecommendations)
+				r.Get("/recommendations/{movieId}/explain", recH.GetExplanation)
+				r.Get("/recommendations/history", recH.GetHistory)
+				r.Get("/recommendations/history/diff", recH.GetHistoryDiff)
 
 				// WebSocket
 				r.Get("/ws/recommendations", recH.GetRecommendationsWS)
@@ -180,6 +245,18 @@ func main() {
 
 			// --- mantenimiento de similitudes / mapeos ---
 			handler.MountAdminMaintenanceRoutes(r, adminMaintH)
+
+			// --- estado / registro de nodos ML ---
+			handler.MountAdminClusterRoutes(r, clusterH)
+
+			// --- modelos de factorización (algo=mf) ---
+			handler.MountAdminMFRoutes(r, mfH)
+
+			// --- precálculo de recomendaciones ---
+			handler.MountAdminPrecomputeRoutes(r, precomputeH)
+
+			// --- experimentos A/B ---
+			handler.MountAdminExperimentRoutes(r, experimentH)
 		})
 	})
 
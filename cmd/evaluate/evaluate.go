@@ -0,0 +1,496 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+)
+
+// algoritmo de referencia: lo más popular que el usuario no vio
+const algoPopular = "popular"
+
+// paramSet un conjunto de parámetros a comparar.
+type paramSet struct {
+	Name string `json:"name"`
+	Algo string `json:"algo"`
+	Mode string `json:"mode,omitempty"`
+
+	// item-kNN: mismos defaults que POST /admin/maintenance/similarities/rebuild
+	Metric    string `json:"metric,omitempty"`
+	Neighbors int    `json:"neighbors,omitempty"`
+	MinCommon int    `json:"minCommon,omitempty"`
+	Shrink    int    `json:"shrink,omitempty"`
+
+	MF *ml.MFParams `json:"mf,omitempty"`
+}
+
+// parseSets "algo=item-knn,mode=baseline;algo=mf,factors=64".
+func parseSets(s string) ([]paramSet, error) {
+	var out []paramSet
+	for _, raw := range strings.Split(s, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		p := paramSet{Name: raw, Neighbors: 20, MinCommon: 3}
+		var mf ml.MFParams
+		for _, kv := range strings.Split(raw, ",") {
+			key, val, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok {
+				return nil, fmt.Errorf("%q: se esperaba clave=valor", kv)
+			}
+			var err error
+			switch key {
+			case "name":
+				p.Name = val
+			case "algo":
+				p.Algo = val
+			case "mode":
+				p.Mode = val
+			case "metric":
+				p.Metric = val
+			case "neighbors":
+				p.Neighbors, err = strconv.Atoi(val)
+			case "minCommon":
+				p.MinCommon, err = strconv.Atoi(val)
+			case "shrink":
+				p.Shrink, err = strconv.Atoi(val)
+			case "factors":
+				mf.Factors, err = strconv.Atoi(val)
+			case "epochs":
+				mf.Epochs, err = strconv.Atoi(val)
+			case "lr":
+				mf.LearningRate, err = strconv.ParseFloat(val, 64)
+			case "reg":
+				mf.Reg, err = strconv.ParseFloat(val, 64)
+			default:
+				return nil, fmt.Errorf("clave desconocida %q", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+
+		if p.Algo != algoPopular {
+			algo, err := ml.ParseAlgo(p.Algo)
+			if err != nil {
+				return nil, err
+			}
+			p.Algo = algo
+		}
+		switch p.Algo {
+		case ml.AlgoItemKNN:
+			mode, err := ml.ParseMode(p.Mode)
+			if err != nil {
+				return nil, err
+			}
+			p.Mode = mode
+			metric, err := ml.ParseMetric(p.Metric)
+			if err != nil {
+				return nil, err
+			}
+			// los vecinos por embedding salen del índice ANN de los nodos
+			if metric == ml.MetricEmbedding {
+				return nil, fmt.Errorf("la métrica %q no se puede evaluar offline", metric)
+			}
+			p.Metric = metric
+		case ml.AlgoMF:
+			mf = mf.WithDefaults()
+			p.MF = &mf
+		case algoPopular:
+		default:
+			return nil, fmt.Errorf("algo %q no soportado en la evaluación (item-knn | mf | popular)", p.Algo)
+		}
+		out = append(out, p)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no hay conjuntos de parámetros")
+	}
+	return out, nil
+}
+
+// setResult métricas de un conjunto de parámetros.
+type setResult struct {
+	paramSet
+
+	RMSE float64 `json:"rmse"`
+	MAE  float64 `json:"mae"`
+	// fracción de ratings de test para los que el modelo tuvo predicción
+	// propia (el resto cae a la media del usuario)
+	PredCoverage float64 `json:"predCoverage"`
+
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	NDCG      float64 `json:"ndcg"`
+	MAP       float64 `json:"map"`
+	// fracción del catálogo que aparece en alguna lista
+	Coverage float64 `json:"coverage"`
+	Novelty  float64 `json:"novelty"`
+
+	// usuarios con al menos una película relevante en test
+	RankedUsers int   `json:"rankedUsers"`
+	ElapsedMs   int64 `json:"elapsedMs"`
+}
+
+// scorer lo que cada algoritmo hace por usuario: la lista ordenada de
+// candidatas y, si puede, la predicción de un rating.
+type scorer interface {
+	user(userID int, train []models.RatingDoc) userScores
+}
+
+type userScores interface {
+	ranked(k int) []int
+	predict(movieID int) (float64, bool)
+}
+
+func evaluate(ds *dataset, p paramSet, k int, threshold float64) (*setResult, error) {
+	var sc scorer
+	switch p.Algo {
+	case ml.AlgoItemKNN:
+		sc = newKNNScorer(ds, p)
+	case ml.AlgoMF:
+		sc = newMFScorer(ds, *p.MF)
+	case algoPopular:
+		sc = newPopularScorer(ds)
+	}
+
+	res := &setResult{paramSet: p}
+	var errs ml.ErrorStats
+	var predicted int
+	recommended := make(map[int]bool)
+	var novelty float64
+	var lists int
+
+	for _, userID := range ds.evalUsers {
+		train := ds.train[userID]
+		us := sc.user(userID, train)
+		mean := ml.UserMean(train)
+
+		relevant := make(map[int]bool)
+		for _, r := range ds.test[userID] {
+			pred, ok := us.predict(r.MovieID)
+			if ok {
+				predicted++
+			} else {
+				pred = mean
+			}
+			errs.Add(clampRating(pred), r.Rating)
+			if r.Rating >= threshold {
+				relevant[r.MovieID] = true
+			}
+		}
+
+		recs := us.ranked(k)
+		for _, id := range recs {
+			recommended[id] = true
+		}
+		if len(recs) > 0 {
+			novelty += ml.Novelty(recs, ds.raters, len(ds.train))
+			lists++
+		}
+		if len(relevant) == 0 {
+			continue
+		}
+		res.RankedUsers++
+		res.Precision += ml.PrecisionAtK(recs, relevant, k)
+		res.Recall += ml.RecallAtK(recs, relevant, k)
+		res.NDCG += ml.NDCGAtK(recs, relevant, k)
+		res.MAP += ml.AveragePrecisionAtK(recs, relevant, k)
+	}
+
+	res.RMSE, res.MAE = errs.RMSE(), errs.MAE()
+	if errs.N() > 0 {
+		res.PredCoverage = float64(predicted) / float64(errs.N())
+	}
+	if n := float64(res.RankedUsers); n > 0 {
+		res.Precision /= n
+		res.Recall /= n
+		res.NDCG /= n
+		res.MAP /= n
+	}
+	if len(ds.items) > 0 {
+		res.Coverage = float64(len(recommended)) / float64(len(ds.items))
+	}
+	if lists > 0 {
+		res.Novelty = novelty / float64(lists)
+	}
+	return res, nil
+}
+
+// clampRating lleva una predicción a la escala de MovieLens.
+func clampRating(r float64) float64 {
+	return math.Max(0.5, math.Min(5, r))
+}
+
+// rankScores ordena candidatas por score (desempate por movieId) y corta a k.
+func rankScores(scores map[int]float64, k int) []int {
+	ids := make([]int, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > k {
+		ids = ids[:k]
+	}
+	return ids
+}
+
+// ====== item-kNN (igual que computeShardRecommendations en los nodos) ======
+
+type knnScorer struct {
+	p         paramSet
+	neighbors map[int][]models.Neighbor
+	baselines *ml.Baselines
+}
+
+func newKNNScorer(ds *dataset, p paramSet) *knnScorer {
+	return &knnScorer{
+		p:         p,
+		neighbors: trainNeighbors(ds, p),
+		baselines: trainBaselines(ds),
+	}
+}
+
+func (s *knnScorer) user(_ int, train []models.RatingDoc) userScores {
+	params := ml.PredictParams{Mode: s.p.Mode}
+	switch s.p.Mode {
+	case ml.ModeMeanCentered:
+		params.UserMean = ml.UserMean(train)
+	case ml.ModeBaseline:
+		params.Baselines = s.baselines
+		params.UserBias = s.baselines.UserBias(train)
+	}
+
+	rated := make(map[int]bool, len(train))
+	for _, r := range train {
+		rated[r.MovieID] = true
+	}
+	acc := ml.NewKNNAccumulator(params, rated)
+	for _, r := range train {
+		acc.Add(r, s.neighbors[r.MovieID])
+	}
+	return knnUser{acc.Partials}
+}
+
+type knnUser struct {
+	partials map[int]*ml.Partial
+}
+
+func (u knnUser) ranked(k int) []int {
+	scores := make(map[int]float64, len(u.partials))
+	for id, p := range u.partials {
+		if p.Den > 0 {
+			scores[id] = p.Score()
+		}
+	}
+	return rankScores(scores, k)
+}
+
+func (u knnUser) predict(movieID int) (float64, bool) {
+	p, ok := u.partials[movieID]
+	if !ok || p.Den <= 0 {
+		return 0, false
+	}
+	return p.Score(), true
+}
+
+// neighborsCache vecinos por (metric, neighbors, minCommon, shrink): los
+// modos del kNN comparten similitudes.
+var (
+	neighborsMu    sync.Mutex
+	neighborsCache = map[string]map[int][]models.Neighbor{}
+)
+
+// trainNeighbors similitudes item-item calculadas solo con train (las
+// de la colección similarities usan todos los ratings y filtrarían el test).
+// Solo se calculan para los ítems que valoraron los usuarios evaluados.
+func trainNeighbors(ds *dataset, p paramSet) map[int][]models.Neighbor {
+	key := fmt.Sprintf("%s/%d/%d/%d", p.Metric, p.Neighbors, p.MinCommon, p.Shrink)
+	neighborsMu.Lock()
+	defer neighborsMu.Unlock()
+	if n, ok := neighborsCache[key]; ok {
+		return n
+	}
+
+	universe := ml.UniverseFromRatings(ds.train, nil)
+
+	needed := make(map[int]bool)
+	for _, userID := range ds.evalUsers {
+		for _, r := range ds.train[userID] {
+			needed[r.MovieID] = true
+		}
+	}
+	targets := make([]int, 0, len(needed))
+	for id := range needed {
+		targets = append(targets, id)
+	}
+	log.Printf("[evaluate] similitudes %s: %d ítems", key, len(targets))
+
+	sp := ml.SimilarityParams{K: p.Neighbors, MinCommonUsers: p.MinCommon, Shrink: p.Shrink}
+	out := make(map[int][]models.Neighbor, len(targets))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for movieID := range jobs {
+				scored := ml.ItemNeighbors(p.Metric, movieID, ds.byItem[movieID], ds.train, universe, sp)
+				neighs := make([]models.Neighbor, len(scored))
+				for i, s := range scored {
+					neighs[i] = models.Neighbor{MovieID: s.MovieID, Sim: s.Sim}
+				}
+				mu.Lock()
+				out[movieID] = neighs
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, id := range targets {
+		jobs <- id
+	}
+	close(jobs)
+	wg.Wait()
+
+	neighborsCache[key] = out
+	return out
+}
+
+func trainBaselines(ds *dataset) *ml.Baselines {
+	stats := make(map[int]ml.ItemStat, len(ds.byItem))
+	for movieID, raters := range ds.byItem {
+		st := ml.ItemStat{Count: len(raters)}
+		for _, r := range raters {
+			st.Sum += r
+		}
+		stats[movieID] = st
+	}
+	return ml.NewBaselines(stats)
+}
+
+// ====== factorización (mismo entrenamiento que POST /admin/models/mf/train) ======
+
+type mfScorer struct {
+	model  *ml.MF
+	rowOf  map[int]int // userId -> fila
+	colOf  map[int]int // movieId -> fila de ítem
+	movies []int       // fila de ítem -> movieId
+}
+
+func newMFScorer(ds *dataset, p ml.MFParams) *mfScorer {
+	s := &mfScorer{rowOf: make(map[int]int), colOf: make(map[int]int, len(ds.items)), movies: ds.items}
+	for i, id := range ds.items {
+		s.colOf[id] = i
+	}
+
+	data := make([]ml.Triplet, 0, ds.trainCount)
+	for userID, rs := range ds.train {
+		if len(rs) == 0 {
+			continue
+		}
+		u, ok := s.rowOf[userID]
+		if !ok {
+			u = len(s.rowOf)
+			s.rowOf[userID] = u
+		}
+		for _, r := range rs {
+			data = append(data, ml.Triplet{U: int32(u), I: int32(s.colOf[r.MovieID]), R: float32(r.Rating)})
+		}
+	}
+	s.model = ml.TrainSGD(data, len(s.rowOf), len(ds.items), p, func(epoch int, rmse float64) {
+		log.Printf("[evaluate] mf época %d rmse=%.4f", epoch, rmse)
+	})
+	return s
+}
+
+func (s *mfScorer) user(userID int, train []models.RatingDoc) userScores {
+	rated := make(map[int]bool, len(train))
+	for _, r := range train {
+		rated[r.MovieID] = true
+	}
+	return mfUser{s: s, u: s.rowOf[userID], rated: rated}
+}
+
+type mfUser struct {
+	s     *mfScorer
+	u     int
+	rated map[int]bool
+}
+
+func (u mfUser) ranked(k int) []int {
+	scores := make(map[int]float64, len(u.s.movies))
+	for i, id := range u.s.movies {
+		if !u.rated[id] {
+			scores[id] = u.s.model.Predict(u.u, i)
+		}
+	}
+	return rankScores(scores, k)
+}
+
+func (u mfUser) predict(movieID int) (float64, bool) {
+	i, ok := u.s.colOf[movieID]
+	if !ok {
+		return 0, false
+	}
+	return u.s.model.Predict(u.u, i), true
+}
+
+// ====== popularidad (referencia) ======
+
+type popularScorer struct {
+	byPop     []int
+	baselines *ml.Baselines
+}
+
+func newPopularScorer(ds *dataset) *popularScorer {
+	byPop := append([]int(nil), ds.items...)
+	sort.SliceStable(byPop, func(i, j int) bool { return ds.raters[byPop[i]] > ds.raters[byPop[j]] })
+	return &popularScorer{byPop: byPop, baselines: trainBaselines(ds)}
+}
+
+func (s *popularScorer) user(_ int, train []models.RatingDoc) userScores {
+	rated := make(map[int]bool, len(train))
+	for _, r := range train {
+		rated[r.MovieID] = true
+	}
+	return popularUser{s: s, rated: rated, bias: s.baselines.UserBias(train)}
+}
+
+type popularUser struct {
+	s     *popularScorer
+	rated map[int]bool
+	bias  float64
+}
+
+func (u popularUser) ranked(k int) []int {
+	out := make([]int, 0, k)
+	for _, id := range u.s.byPop {
+		if len(out) == k {
+			break
+		}
+		if !u.rated[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// predict predictor base mu + b_u + b_i.
+func (u popularUser) predict(movieID int) (float64, bool) {
+	b := u.s.baselines
+	return b.GlobalMean + u.bias + b.ItemBias[movieID], true
+}
@@ -0,0 +1,191 @@
+// Command evaluate mide offline la calidad de las recomendaciones: parte
+// los ratings en train/test (temporal o leave-k-out por usuario), calcula
+// las recomendaciones con el mismo código que los nodos ML (internal/ml) y
+// reporta RMSE/MAE, precision@K, recall@K, NDCG@K, MAP, cobertura de
+// catálogo y novedad para cada conjunto de parámetros.
+//
+//	go run ./cmd/evaluate -split leave-k-out -holdout 5 -k 10 \
+//	    -sets "algo=item-knn,mode=weighted;algo=item-knn,mode=baseline;algo=mf;algo=popular"
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"nodosml-pc4/internal/config"
+	"nodosml-pc4/internal/db"
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+)
+
+const defaultSets = "algo=item-knn,mode=weighted;" +
+	"algo=item-knn,mode=mean-centered;" +
+	"algo=item-knn,mode=baseline;" +
+	"algo=mf;" +
+	"algo=popular"
+
+func main() {
+	var (
+		split     = flag.String("split", splitLeaveKOut, "temporal | leave-k-out")
+		testFrac  = flag.Float64("test-frac", 0.2, "split temporal: fracción más reciente de los ratings que va a test")
+		holdout   = flag.Int("holdout", 5, "split leave-k-out: últimos ratings de cada usuario que van a test")
+		minTrain  = flag.Int("min-train", 5, "mínimo de ratings en train para evaluar a un usuario")
+		k         = flag.Int("k", 10, "largo de la lista recomendada")
+		threshold = flag.Float64("relevant", 4.0, "rating mínimo en test para considerar relevante una película")
+		maxUsers  = flag.Int("max-users", 1000, "usuarios evaluados (muestra aleatoria; 0 = todos)")
+		seed      = flag.Int64("seed", 42, "semilla de la muestra de usuarios")
+		sets      = flag.String("sets", defaultSets, "conjuntos de parámetros separados por ';' (claves: name, algo, mode, metric, neighbors, minCommon, shrink, factors, epochs, lr, reg)")
+		csvPath   = flag.String("ratings-csv", "", "leer ratings de un CSV de MovieLens (userId,movieId,rating,timestamp) en vez de Mongo")
+		out       = flag.String("out", "evaluation.json", "archivo JSON con los resultados (vacío = no escribir)")
+	)
+	flag.Parse()
+
+	paramSets, err := parseSets(*sets)
+	if err != nil {
+		log.Fatalf("-sets: %v", err)
+	}
+
+	start := time.Now()
+	ratings, err := loadRatings(*csvPath)
+	if err != nil {
+		log.Fatalf("cargando ratings: %v", err)
+	}
+	log.Printf("[evaluate] ratings=%d (%s)", len(ratings), time.Since(start).Round(time.Millisecond))
+
+	ds, err := splitRatings(ratings, splitOptions{
+		Kind:     *split,
+		TestFrac: *testFrac,
+		Holdout:  *holdout,
+		MinTrain: *minTrain,
+		MaxUsers: *maxUsers,
+		Seed:     *seed,
+	})
+	if err != nil {
+		log.Fatalf("split: %v", err)
+	}
+	log.Printf("[evaluate] split=%s train=%d test=%d usuarios evaluados=%d ítems=%d",
+		*split, ds.trainCount, ds.testCount, len(ds.evalUsers), len(ds.items))
+
+	report := evaluationReport{
+		Split:        *split,
+		K:            *k,
+		Relevant:     *threshold,
+		TrainRatings: ds.trainCount,
+		TestRatings:  ds.testCount,
+		Users:        len(ds.evalUsers),
+		Items:        len(ds.items),
+		GeneratedAt:  time.Now(),
+	}
+	for _, p := range paramSets {
+		t := time.Now()
+		res, err := evaluate(ds, p, *k, *threshold)
+		if err != nil {
+			log.Fatalf("%s: %v", p.Name, err)
+		}
+		res.ElapsedMs = time.Since(t).Milliseconds()
+		log.Printf("[evaluate] %s: ndcg@%d=%.4f rmse=%.4f (%s)", p.Name, *k, res.NDCG, res.RMSE, time.Since(t).Round(time.Millisecond))
+		report.Results = append(report.Results, res)
+	}
+
+	printTable(os.Stdout, report)
+	if *out != "" {
+		if err := writeJSON(*out, report); err != nil {
+			log.Fatalf("escribiendo %s: %v", *out, err)
+		}
+		log.Printf("[evaluate] resultados en %s", *out)
+	}
+}
+
+// evaluationReport lo que se escribe en el JSON.
+type evaluationReport struct {
+	Split        string       `json:"split"`
+	K            int          `json:"k"`
+	Relevant     float64      `json:"relevantThreshold"`
+	TrainRatings int          `json:"trainRatings"`
+	TestRatings  int          `json:"testRatings"`
+	Users        int          `json:"users"`
+	Items        int          `json:"items"`
+	GeneratedAt  time.Time    `json:"generatedAt"`
+	Results      []*setResult `json:"results"`
+}
+
+// loadRatings de Mongo (config por env, como la API y los nodos) o de un CSV.
+func loadRatings(csvPath string) ([]models.RatingDoc, error) {
+	if csvPath != "" {
+		f, err := os.Open(csvPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readRatingsCSV(f)
+	}
+
+	cfg := config.Load()
+	db.InitMongo(cfg)
+
+	var out []models.RatingDoc
+	err := repository.NewRatingRepository().ForEach(context.Background(), func(rd *models.RatingDoc) {
+		out = append(out, *rd)
+	})
+	return out, err
+}
+
+// readRatingsCSV formato ratings.csv de MovieLens (con encabezado).
+func readRatingsCSV(r io.Reader) ([]models.RatingDoc, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	if _, err := cr.Read(); err != nil { // encabezado
+		return nil, err
+	}
+
+	var out []models.RatingDoc
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 4 {
+			return nil, fmt.Errorf("línea %d: se esperaban 4 columnas", len(out)+2)
+		}
+		u, err1 := strconv.Atoi(rec[0])
+		m, err2 := strconv.Atoi(rec[1])
+		rt, err3 := strconv.ParseFloat(rec[2], 64)
+		ts, err4 := strconv.ParseInt(rec[3], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			return nil, fmt.Errorf("línea %d: valores inválidos", len(out)+2)
+		}
+		out = append(out, models.RatingDoc{UserID: u, MovieID: m, Rating: rt, Timestamp: ts})
+	}
+}
+
+func printTable(w io.Writer, r evaluationReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "set\tRMSE\tMAE\tpred.cov\tP@%d\tR@%d\tNDCG@%d\tMAP@%d\tcoverage\tnovelty\tusers\tms\t\n", r.K, r.K, r.K, r.K)
+	for _, res := range r.Results {
+		fmt.Fprintf(tw, "%s\t%.4f\t%.4f\t%.3f\t%.4f\t%.4f\t%.4f\t%.4f\t%.3f\t%.2f\t%d\t%d\t\n",
+			res.Name, res.RMSE, res.MAE, res.PredCoverage,
+			res.Precision, res.Recall, res.NDCG, res.MAP,
+			res.Coverage, res.Novelty, res.RankedUsers, res.ElapsedMs)
+	}
+	tw.Flush()
+}
+
+func writeJSON(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"nodosml-pc4/internal/models"
+)
+
+const (
+	splitTemporal  = "temporal"
+	splitLeaveKOut = "leave-k-out"
+)
+
+type splitOptions struct {
+	Kind     string
+	TestFrac float64 // temporal
+	Holdout  int     // leave-k-out
+	MinTrain int
+	MaxUsers int
+	Seed     int64
+}
+
+// dataset ratings partidos en train/test, indexados como los necesita la
+// evaluación.
+type dataset struct {
+	train map[int][]models.RatingDoc // userId -> ratings de train
+	test  map[int][]models.RatingDoc // userId -> ratings de test
+
+	// movieId -> userId -> rating (train), para las similitudes
+	byItem map[int]map[int]float64
+	// movieId -> usuarios que la valoraron en train (popularidad/novedad)
+	raters map[int]int
+	items  []int // catálogo: películas con algún rating en train
+
+	evalUsers  []int // usuarios con train y test suficientes (muestra)
+	trainCount int
+	testCount  int
+}
+
+// splitRatings temporal: un corte global por timestamp (lo más reciente a
+// test), así no se usa el futuro para predecir el pasado. leave-k-out: los
+// últimos Holdout ratings de cada usuario a test.
+func splitRatings(all []models.RatingDoc, o splitOptions) (*dataset, error) {
+	byUser := make(map[int][]models.RatingDoc)
+	for _, r := range all {
+		byUser[r.UserID] = append(byUser[r.UserID], r)
+	}
+	for _, rs := range byUser {
+		sort.Slice(rs, func(i, j int) bool {
+			if rs[i].Timestamp != rs[j].Timestamp {
+				return rs[i].Timestamp < rs[j].Timestamp
+			}
+			return rs[i].MovieID < rs[j].MovieID
+		})
+	}
+
+	ds := &dataset{
+		train:  make(map[int][]models.RatingDoc, len(byUser)),
+		test:   make(map[int][]models.RatingDoc),
+		byItem: make(map[int]map[int]float64),
+		raters: make(map[int]int),
+	}
+
+	switch o.Kind {
+	case splitTemporal:
+		if o.TestFrac <= 0 || o.TestFrac >= 1 {
+			return nil, fmt.Errorf("test-frac debe estar en (0,1)")
+		}
+		ts := make([]int64, len(all))
+		for i, r := range all {
+			ts[i] = r.Timestamp
+		}
+		sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+		var cutoff int64
+		if len(ts) > 0 {
+			cutoff = ts[int(float64(len(ts))*(1-o.TestFrac))]
+		}
+		for userID, rs := range byUser {
+			for _, r := range rs {
+				if r.Timestamp >= cutoff {
+					ds.test[userID] = append(ds.test[userID], r)
+				} else {
+					ds.train[userID] = append(ds.train[userID], r)
+				}
+			}
+		}
+	case splitLeaveKOut:
+		if o.Holdout <= 0 {
+			return nil, fmt.Errorf("holdout debe ser > 0")
+		}
+		for userID, rs := range byUser {
+			if len(rs) < o.Holdout+o.MinTrain {
+				ds.train[userID] = rs
+				continue
+			}
+			cut := len(rs) - o.Holdout
+			ds.train[userID] = rs[:cut]
+			ds.test[userID] = rs[cut:]
+		}
+	default:
+		return nil, fmt.Errorf("split desconocido %q (temporal | leave-k-out)", o.Kind)
+	}
+
+	for userID, rs := range ds.train {
+		for _, r := range rs {
+			m := ds.byItem[r.MovieID]
+			if m == nil {
+				m = make(map[int]float64)
+				ds.byItem[r.MovieID] = m
+			}
+			m[userID] = r.Rating
+			ds.raters[r.MovieID]++
+		}
+		ds.trainCount += len(rs)
+	}
+	for movieID := range ds.byItem {
+		ds.items = append(ds.items, movieID)
+	}
+	sort.Ints(ds.items)
+
+	// usuarios evaluables: con historia en train y algo de test sobre
+	// películas conocidas (las nuevas no las puede recomendar nadie)
+	for userID, rs := range ds.test {
+		known := rs[:0]
+		for _, r := range rs {
+			if _, ok := ds.byItem[r.MovieID]; ok {
+				known = append(known, r)
+			}
+		}
+		ds.test[userID] = known
+		if len(known) > 0 && len(ds.train[userID]) >= o.MinTrain {
+			ds.evalUsers = append(ds.evalUsers, userID)
+		}
+	}
+	sort.Ints(ds.evalUsers)
+	if o.MaxUsers > 0 && len(ds.evalUsers) > o.MaxUsers {
+		rng := rand.New(rand.NewSource(o.Seed))
+		rng.Shuffle(len(ds.evalUsers), func(i, j int) {
+			ds.evalUsers[i], ds.evalUsers[j] = ds.evalUsers[j], ds.evalUsers[i]
+		})
+		ds.evalUsers = ds.evalUsers[:o.MaxUsers]
+		sort.Ints(ds.evalUsers)
+	}
+	for _, userID := range ds.evalUsers {
+		ds.testCount += len(ds.test[userID])
+	}
+	return ds, nil
+}
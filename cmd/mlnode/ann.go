@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/cluster"
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/repository"
+)
+
+// annStore índice de vecinos aproximados (HNSW) sobre vectores de ítems,
+// uno a la vez como el modelo MF. Se guarda en dir para no rearmarlo al
+// reiniciar el nodo; el armado es determinista (semilla fija), así todos
+// los nodos llegan al mismo grafo.
+type annStore struct {
+	nodeID string
+	dir    string
+	movies *repository.MovieRepository
+	mf     *mfStore
+
+	build sync.Mutex // una carga o armado a la vez
+
+	mu      sync.RWMutex
+	ix      *ml.HNSW
+	spec    cluster.ANNSpec
+	from    string
+	path    string
+	buildMs int64
+}
+
+func newANNStore(nodeID, dir string, movies *repository.MovieRepository, mf *mfStore) *annStore {
+	return &annStore{nodeID: nodeID, dir: dir, movies: movies, mf: mf}
+}
+
+// normalizeANNSpec valida la fuente y completa los parámetros del grafo.
+func normalizeANNSpec(spec cluster.ANNSpec) (cluster.ANNSpec, error) {
+	source, err := ml.ParseEmbeddingSource(spec.Source)
+	if err != nil {
+		return spec, cluster.NewError(cluster.ErrCodeBadPayload, "%v", err)
+	}
+	spec.Source = source
+	switch source {
+	case ml.EmbeddingMF:
+		if spec.ModelID == "" {
+			return spec, cluster.NewError(cluster.ErrCodeBadPayload, "falta modelId para source=mf")
+		}
+	default:
+		spec.ModelID = ""
+	}
+	p := ml.HNSWParams{M: spec.M, EfConstruction: spec.EfConstruction, EfSearch: spec.EfSearch}.WithDefaults()
+	spec.M, spec.EfConstruction, spec.EfSearch = p.M, p.EfConstruction, p.EfSearch
+	return spec, nil
+}
+
+// el grafo no depende de efSearch: se busca con el de cada pedido
+func sameGraph(a, b cluster.ANNSpec) bool {
+	return a.Source == b.Source && a.ModelID == b.ModelID && a.M == b.M && a.EfConstruction == b.EfConstruction
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+func (s *annStore) filePath(spec cluster.ANNSpec) string {
+	name := fmt.Sprintf("%s-%s-m%d-efc%d.hnsw", spec.Source, spec.ModelID, spec.M, spec.EfConstruction)
+	return filepath.Join(s.dir, unsafeFileChars.ReplaceAllString(name, "_"))
+}
+
+// get devuelve el índice de spec: el de memoria, el de disco o uno recién
+// armado (rebuild salta memoria y disco).
+func (s *annStore) get(ctx context.Context, spec cluster.ANNSpec, rebuild bool) (*ml.HNSW, cluster.ANNSpec, error) {
+	spec, err := normalizeANNSpec(spec)
+	if err != nil {
+		return nil, spec, err
+	}
+	if ix := s.current(spec); ix != nil && !rebuild {
+		return ix, spec, nil
+	}
+
+	s.build.Lock()
+	defer s.build.Unlock()
+	// otro pedido pudo dejarlo listo mientras esperábamos
+	if ix := s.current(spec); ix != nil && !rebuild {
+		return ix, spec, nil
+	}
+
+	path := s.filePath(spec)
+	start := time.Now()
+	if !rebuild {
+		if ix, err := readHNSW(path); err == nil {
+			s.set(ix, spec, "disk", path, start)
+			return ix, spec, nil
+		} else if !os.IsNotExist(err) {
+			log.Printf("[ML NODE %s] índice ANN en %s ilegible, se rearma: %v", s.nodeID, path, err)
+		}
+	}
+
+	ix, err := s.buildIndex(ctx, spec)
+	if err != nil {
+		return nil, spec, err
+	}
+	if err := writeHNSW(path, ix); err != nil {
+		// sin disco el índice igual sirve mientras el nodo siga vivo
+		log.Printf("[ML NODE %s] no se pudo guardar el índice ANN en %s: %v", s.nodeID, path, err)
+		path = ""
+	}
+	s.set(ix, spec, "built", path, start)
+	return ix, spec, nil
+}
+
+func (s *annStore) current(spec cluster.ANNSpec) *ml.HNSW {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.ix != nil && sameGraph(s.spec, spec) {
+		return s.ix
+	}
+	return nil
+}
+
+func (s *annStore) set(ix *ml.HNSW, spec cluster.ANNSpec, from, path string, start time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ix, s.spec, s.from, s.path = ix, spec, from, path
+	s.buildMs = time.Since(start).Milliseconds()
+	log.Printf("[ML NODE %s] índice ANN listo (%s): source=%s modelo=%s ítems=%d dim=%d memoria≈%.1fMB tiempo=%dms",
+		s.nodeID, from, spec.Source, spec.ModelID, ix.Len(), ix.Dim, float64(ix.ApproxBytes())/(1<<20), s.buildMs)
+}
+
+// buildIndex arma el grafo con los vectores de la fuente (solo películas
+// con iIdx, el universo de las similitudes).
+func (s *annStore) buildIndex(ctx context.Context, spec cluster.ANNSpec) (*ml.HNSW, error) {
+	var (
+		ids  []int
+		vecs [][]float32
+		dim  int
+	)
+	switch spec.Source {
+	case ml.EmbeddingMF:
+		m, err := s.mf.get(ctx, spec.ModelID)
+		if err != nil {
+			return nil, err
+		}
+		dim = m.f.Factors
+		for row, movieID := range m.movieOf {
+			if movieID != 0 {
+				ids = append(ids, movieID)
+				vecs = append(vecs, m.f.Vec(row))
+			}
+		}
+	case ml.EmbeddingGenome:
+		movies, err := s.movies.ContentFeatures(ctx)
+		if err != nil {
+			return nil, err
+		}
+		mapped := movies[:0]
+		for _, m := range movies {
+			if m.IIdx != nil {
+				mapped = append(mapped, m)
+			}
+		}
+		ids, vecs = ml.GenomeEmbeddings(mapped)
+		if len(vecs) > 0 {
+			dim = len(vecs[0])
+		}
+	}
+	if len(ids) == 0 {
+		return nil, cluster.NewError(cluster.ErrCodeInternal, "no hay vectores de ítems para source=%s", spec.Source)
+	}
+
+	log.Printf("[ML NODE %s] armando índice ANN: source=%s ítems=%d dim=%d m=%d efConstruction=%d",
+		s.nodeID, spec.Source, len(ids), dim, spec.M, spec.EfConstruction)
+	ix := ml.NewHNSW(dim, ml.HNSWParams{M: spec.M, EfConstruction: spec.EfConstruction, EfSearch: spec.EfSearch})
+	for i, id := range ids {
+		if i%1000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		ix.Add(id, vecs[i])
+	}
+	return ix, nil
+}
+
+func readHNSW(path string) (*ml.HNSW, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ml.LoadHNSW(bufio.NewReader(f))
+}
+
+// writeHNSW escribe a un temporal y renombra: un nodo que muere a mitad
+// de la escritura no deja un índice cortado.
+func writeHNSW(path string, ix *ml.HNSW) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := ix.Save(w); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// stats resumen del índice cargado para el mensaje stats.
+func (s *annStore) stats() map[string]any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := map[string]any{"dir": s.dir}
+	if s.ix != nil {
+		out["source"] = s.spec.Source
+		out["modelId"] = s.spec.ModelID
+		out["items"] = s.ix.Len()
+		out["dim"] = s.ix.Dim
+		out["from"] = s.from
+		out["approxBytes"] = s.ix.ApproxBytes()
+	}
+	return out
+}
+
+func (n *mlNode) handleBuildANNMsg(ctx context.Context, payload json.RawMessage) (any, error) {
+	var task cluster.ANNBuildTask
+	if err := cluster.Decode(payload, &task); err != nil {
+		return nil, err
+	}
+
+	ix, spec, err := n.ann.get(ctx, task.ANNSpec, task.Rebuild)
+	if err != nil {
+		return nil, err
+	}
+
+	n.ann.mu.RLock()
+	resp := &cluster.ANNBuildResponse{
+		ANNSpec:     spec,
+		Items:       ix.Len(),
+		Dim:         ix.Dim,
+		From:        n.ann.from,
+		Path:        n.ann.path,
+		BuildMs:     n.ann.buildMs,
+		ApproxBytes: ix.ApproxBytes(),
+	}
+	n.ann.mu.RUnlock()
+
+	// recall contra la búsqueda exacta sobre una muestra de ítems
+	if task.RecallSample > 0 {
+		k := task.RecallK
+		if k <= 0 {
+			k = 20
+		}
+		start := time.Now()
+		resp.Recall = ix.Recall(task.RecallSample, k, spec.EfSearch, ml.DefaultHNSWParams.Seed)
+		resp.RecallK = k
+		resp.RecallSample = min(task.RecallSample, ix.Len())
+		resp.RecallMs = time.Since(start).Milliseconds()
+		log.Printf("[ML NODE %s] recall@%d del índice ANN: %.4f (muestra=%d efSearch=%d)",
+			n.id, k, resp.Recall, resp.RecallSample, spec.EfSearch)
+	}
+	return resp, nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nodosml-pc4/internal/cluster"
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+)
+
+// neighborIndex grafo de similitudes en formato compacto (tipo CSR): los
+// vecinos de todas las películas van en dos arreglos planos y cada fila
+// apunta a su tramo con offsets. Son ~12 bytes por vecino en vez de los
+// ~24 de un []models.Neighbor más el overhead de un slice por película.
+type neighborIndex struct {
+	row     map[int32]int32 // movieId -> fila
+	offsets []int32         // len = filas+1
+	ids     []int32         // movieId del vecino
+	iIdxs   []int32         // iIdx del vecino
+	sims    []float32
+}
+
+func (ix *neighborIndex) lookup(movieID, k int) ([]models.Neighbor, bool) {
+	r, ok := ix.row[int32(movieID)]
+	if !ok {
+		return nil, false
+	}
+	from, to := ix.offsets[r], ix.offsets[r+1]
+	if k > 0 && int(to-from) > k {
+		to = from + int32(k)
+	}
+
+	out := make([]models.Neighbor, 0, to-from)
+	for i := from; i < to; i++ {
+		out = append(out, models.Neighbor{
+			MovieID: int(ix.ids[i]),
+			IIdx:    int(ix.iIdxs[i]),
+			Sim:     float64(ix.sims[i]),
+		})
+	}
+	return out, true
+}
+
+// approxBytes estimación de memoria: arreglos planos + entradas del mapa
+// (clave+valor+overhead de bucket ~ 16 bytes por fila).
+func (ix *neighborIndex) approxBytes() int64 {
+	return int64(len(ix.ids))*4 + int64(len(ix.iIdxs))*4 + int64(len(ix.sims))*4 +
+		int64(len(ix.offsets))*4 + int64(len(ix.row))*16
+}
+
+// indexBuilder arma el índice fila por fila mientras se recorre Mongo.
+type indexBuilder struct {
+	ix neighborIndex
+}
+
+func newIndexBuilder() *indexBuilder {
+	return &indexBuilder{ix: neighborIndex{
+		row:     make(map[int32]int32),
+		offsets: []int32{0},
+	}}
+}
+
+func (b *indexBuilder) add(doc *models.SimilarityDoc) {
+	if _, dup := b.ix.row[int32(doc.MovieID)]; dup {
+		return
+	}
+	b.ix.row[int32(doc.MovieID)] = int32(len(b.ix.offsets) - 1)
+	for _, n := range doc.Neighbors {
+		b.ix.ids = append(b.ix.ids, int32(n.MovieID))
+		b.ix.iIdxs = append(b.ix.iIdxs, int32(n.IIdx))
+		b.ix.sims = append(b.ix.sims, float32(n.Sim))
+	}
+	b.ix.offsets = append(b.ix.offsets, int32(len(b.ix.ids)))
+}
+
+func (b *indexBuilder) build() *neighborIndex {
+	// recortar la capacidad sobrante de los append
+	b.ix.ids = append([]int32(nil), b.ix.ids...)
+	b.ix.iIdxs = append([]int32(nil), b.ix.iIdxs...)
+	b.ix.sims = append([]float32(nil), b.ix.sims...)
+	return &b.ix
+}
+
+// nodeIndex mantiene en memoria los vecinos de las películas que le tocan
+// a este nodo según el anillo de hashing consistente (o todo el grafo si
+// el nodo no tiene identidad en el anillo), un grafo por métrica. Se
+// recarga si cambia el anillo o si lo pide el coordinador; lo que no esté
+// en memoria se lee de Mongo.
+type nodeIndex struct {
+	self   string // identidad del nodo en el anillo (host:puerto); "" = grafo completo
+	nodeID string
+	sims   *repository.SimilarityRepository
+
+	mu       sync.RWMutex
+	ix       map[string]*neighborIndex // métrica -> grafo
+	ring     []string
+	ringSig  string
+	loadedAt time.Time
+	loadTime time.Duration
+	loading  bool
+	// último anillo recibido durante una carga: se carga al terminar
+	pending []string
+	// filas recalculadas de forma incremental después de la carga: tienen
+	// prioridad sobre ix hasta la próxima carga
+	patched map[patchKey]patchedRow
+	// películas que tampoco tienen documento en Mongo: no se vuelven a
+	// buscar hasta la próxima carga o un patch que las traiga
+	absent map[patchKey]struct{}
+
+	hits, misses int64
+}
+
+type patchKey struct {
+	metric  string
+	movieID int32
+}
+
+type patchedRow struct {
+	neighbors []models.Neighbor
+	at        time.Time
+}
+
+func newNodeIndex(self, nodeID string, sims *repository.SimilarityRepository) *nodeIndex {
+	return &nodeIndex{self: self, nodeID: nodeID, sims: sims}
+}
+
+// ensure dispara la carga en segundo plano si el anillo recibido difiere
+// del cargado.
+func (p *nodeIndex) ensure(ring []string) {
+	if p.self == "" || len(ring) == 0 {
+		return
+	}
+	sig := cluster.NewRing(ring, cluster.DefaultVNodes).Signature()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.loading {
+		p.pending = ring
+		return
+	}
+	if p.ringSig == sig {
+		return
+	}
+	p.loading = true
+	go p.loadAsync(ring)
+}
+
+func (p *nodeIndex) loadAsync(ring []string) {
+	if err := p.load(context.Background(), ring); err != nil {
+		log.Printf("[ML NODE %s] error cargando índice: %v", p.nodeID, err)
+	}
+}
+
+// loadPendingLocked arranca la carga del último anillo que llegó durante
+// la carga anterior, si difiere del que quedó cargado.
+func (p *nodeIndex) loadPendingLocked() {
+	next := p.pending
+	p.pending = nil
+	if len(next) == 0 || cluster.NewRing(next, cluster.DefaultVNodes).Signature() == p.ringSig {
+		return
+	}
+	p.loading = true
+	go p.loadAsync(next)
+}
+
+// reload fuerza la recarga con el anillo actual (p.e. tras un rebuild de
+// similitudes) y espera a que termine.
+func (p *nodeIndex) reload(ctx context.Context) error {
+	p.mu.Lock()
+	if p.loading {
+		p.mu.Unlock()
+		return cluster.NewError(cluster.ErrCodeInternal, "ya hay una carga del índice en curso")
+	}
+	p.loading = true
+	ring := p.ring
+	p.mu.Unlock()
+
+	return p.load(ctx, ring)
+}
+
+// load lee de similarities solo las filas propias (o todas, sin identidad
+// en el anillo). Quien llama debe haber puesto loading=true. Si mientras
+// tanto llegó otro anillo, al terminar se dispara su carga.
+func (p *nodeIndex) load(ctx context.Context, members []string) error {
+	ring := cluster.NewRing(members, cluster.DefaultVNodes)
+	partial := p.self != "" && len(members) > 0
+
+	start := time.Now()
+	builders := make(map[string]*indexBuilder)
+	add := func(doc *models.SimilarityDoc) {
+		metric := docMetric(doc)
+		b := builders[metric]
+		if b == nil {
+			b = newIndexBuilder()
+			builders[metric] = b
+		}
+		b.add(doc)
+	}
+
+	var err error
+	if partial {
+		// el anillo se resuelve sobre la lista de iIdxs (chica) y a Mongo
+		// se le piden solo los documentos de los propios
+		var all []int
+		if all, err = p.sims.IIdxs(ctx); err == nil {
+			owned := make([]int, 0, len(all)/max(len(members), 1)+1)
+			for _, iIdx := range all {
+				if ring.Owner(iIdx) == p.self {
+					owned = append(owned, iIdx)
+				}
+			}
+			err = p.sims.ForEachIIdx(ctx, owned, add)
+		}
+	} else {
+		err = p.sims.ForEach(ctx, add)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loading = false
+	defer p.loadPendingLocked()
+	if err != nil {
+		return err
+	}
+
+	p.ix = make(map[string]*neighborIndex, len(builders))
+	for metric, b := range builders {
+		p.ix[metric] = b.build()
+	}
+	clear(p.absent)
+	// lo parcheado mientras se leía Mongo puede no estar en la carga
+	for id, row := range p.patched {
+		if row.at.Before(start) {
+			delete(p.patched, id)
+		}
+	}
+	p.ring = members
+	p.ringSig = ring.Signature()
+	p.loadedAt = time.Now()
+	p.loadTime = time.Since(start)
+
+	scope := "grafo completo"
+	if partial {
+		scope = "ring=[" + strings.Join(ring.Members(), ",") + "]"
+	}
+	st := p.statsLocked()
+	log.Printf("[ML NODE %s] índice cargado (%s): películas=%v vecinos=%d memoria≈%.1fMB tiempo=%s",
+		p.nodeID, scope, st.Metrics, st.Neighbors, float64(st.ApproxBytes)/(1<<20), p.loadTime)
+	return nil
+}
+
+// patch reemplaza las filas propias con vecinos recalculados. Sin índice
+// cargado no hace nada: las búsquedas van a Mongo, que ya está al día.
+func (p *nodeIndex) patch(docs []models.SimilarityDoc) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// las que ahora tienen vecinos dejan de estar ausentes, sean o no propias
+	for i := range docs {
+		delete(p.absent, patchKey{docMetric(&docs[i]), int32(docs[i].MovieID)})
+	}
+	if p.ix == nil {
+		return 0
+	}
+
+	var ring *cluster.Ring
+	if p.self != "" && len(p.ring) > 0 {
+		ring = cluster.NewRing(p.ring, cluster.DefaultVNodes)
+	}
+	if p.patched == nil {
+		p.patched = make(map[patchKey]patchedRow)
+	}
+	now := time.Now()
+	applied := 0
+	for _, doc := range docs {
+		if ring != nil && ring.Owner(doc.IIdx) != p.self {
+			continue
+		}
+		neighbors := make([]models.Neighbor, len(doc.Neighbors))
+		for i, n := range doc.Neighbors {
+			neighbors[i] = models.Neighbor{MovieID: n.MovieID, IIdx: n.IIdx, Sim: n.Sim}
+		}
+		p.patched[patchKey{docMetric(&doc), int32(doc.MovieID)}] = patchedRow{neighbors: neighbors, at: now}
+		applied++
+	}
+	return applied
+}
+
+// get busca en memoria los vecinos de una película en la métrica pedida.
+// Una película marcada como ausente devuelve una lista vacía.
+func (p *nodeIndex) get(movieID int, metric string, k int) ([]models.Neighbor, bool) {
+	key := patchKey{metric, int32(movieID)}
+	p.mu.RLock()
+	ix := p.ix[metric]
+	row, patched := p.patched[key]
+	_, absent := p.absent[key]
+	p.mu.RUnlock()
+
+	if absent {
+		atomic.AddInt64(&p.hits, 1)
+		return []models.Neighbor{}, true
+	}
+	if patched {
+		atomic.AddInt64(&p.hits, 1)
+		n := row.neighbors
+		if k > 0 && len(n) > k {
+			n = n[:k]
+		}
+		return append([]models.Neighbor(nil), n...), true
+	}
+	if ix != nil {
+		if n, ok := ix.lookup(movieID, k); ok {
+			atomic.AddInt64(&p.hits, 1)
+			return n, true
+		}
+	}
+	atomic.AddInt64(&p.misses, 1)
+	return nil, false
+}
+
+// markAbsent recuerda que la película no tiene vecinos en Mongo.
+func (p *nodeIndex) markAbsent(movieID int, metric string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.absent == nil {
+		p.absent = make(map[patchKey]struct{})
+	}
+	p.absent[patchKey{metric, int32(movieID)}] = struct{}{}
+}
+
+// stats resumen del índice para el mensaje stats / reload-index.
+func (p *nodeIndex) stats() *cluster.IndexStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.statsLocked()
+}
+
+func (p *nodeIndex) statsLocked() *cluster.IndexStats {
+	st := &cluster.IndexStats{
+		Ring:    p.ring,
+		Loading: p.loading,
+		Hits:    atomic.LoadInt64(&p.hits),
+		Misses:  atomic.LoadInt64(&p.misses),
+		Patched: len(p.patched),
+		Absent:  len(p.absent),
+	}
+	if p.ix != nil {
+		st.Metrics = make(map[string]int, len(p.ix))
+		for metric, ix := range p.ix {
+			st.Metrics[metric] = len(ix.row)
+			st.Movies += len(ix.row)
+			st.Neighbors += len(ix.ids)
+			st.ApproxBytes += ix.approxBytes()
+		}
+		st.LoadedAt = p.loadedAt
+		st.LoadMs = p.loadTime.Milliseconds()
+	}
+	return st
+}
+
+// docMetric métrica de un documento (los anteriores a las métricas no la traen).
+func docMetric(doc *models.SimilarityDoc) string {
+	if doc.Metric == "" {
+		return ml.DefaultMetric
+	}
+	return doc.Metric
+}
@@ -1,20 +1,76 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"nodosml-pc4/internal/cluster"
 	"nodosml-pc4/internal/config"
 	"nodosml-pc4/internal/db"
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
 	"nodosml-pc4/internal/repository"
 )
 
+// mlNode agrupa lo que necesita un nodo para atender tareas.
+type mlNode struct {
+	id      string
+	sims    *repository.SimilarityRepository
+	ratings *repository.RatingRepository
+	movies  *repository.MovieRepository
+	users   *repository.UserRepository
+	mfRepo  *repository.MFRepository
+
+	// normas y mapeo movieId->iIdx para el recálculo de similitudes
+	universe *itemUniverse
+
+	// vecinos en memoria de los ítems que le tocan a este nodo
+	index *nodeIndex
+
+	// media global y sesgos de ítem para el modo baseline
+	baselines *ml.BaselinesCache
+
+	// modelo de factorización en memoria (algo=mf)
+	mf *mfStore
+
+	// índice de vecinos aproximados sobre vectores de ítems (métrica embedding)
+	ann *annStore
+}
+
+// newMLNode arma el nodo con sus repositorios (Mongo ya inicializado).
+// advertise es su identidad en el anillo ("" = grafo completo) y annDir
+// dónde guarda los índices ANN.
+func newMLNode(nodeID, advertise, annDir string) *mlNode {
+	ratingsRepo := repository.NewRatingRepository()
+	moviesRepo := repository.NewMovieRepository()
+	simsRepo := repository.NewSimilarityRepository()
+	mfRepo := repository.NewMFRepository()
+
+	mfs := newMFStore(nodeID, mfRepo)
+	return &mlNode{
+		id:        nodeID,
+		sims:      simsRepo,
+		ratings:   ratingsRepo,
+		movies:    moviesRepo,
+		users:     repository.NewUserRepository(),
+		mfRepo:    mfRepo,
+		universe:  newItemUniverse(ratingsRepo, moviesRepo, 10*time.Minute),
+		index:     newNodeIndex(advertise, nodeID, simsRepo),
+		baselines: ml.NewBaselinesCache(ratingsRepo.ItemStats, 10*time.Minute),
+		mf:        mfs,
+		ann:       newANNStore(nodeID, annDir, moviesRepo, mfs),
+	}
+}
+
 func main() {
 	cfg := config.Load()
 	db.InitMongo(cfg)
@@ -29,114 +85,257 @@ func main() {
 		nodeID = "?"
 	}
 
+	// dónde se guardan los índices ANN armados
+	annDir := os.Getenv("ML_ANN_DIR")
+	if annDir == "" {
+		annDir = filepath.Join(os.TempDir(), "nodosml-ann")
+	}
+
 	log.Printf("[ML NODE %s] escuchando en %s", nodeID, addr)
 
-	simsRepo := repository.NewSimilarityRepository()
+	// identidad del nodo en el anillo: la misma dirección con la que lo
+	// conoce (o con la que se registra en) el coordinador
+	coordinatorURL := os.Getenv("COORDINATOR_URL")
+	advertise := os.Getenv("ML_NODE_ADVERTISE_ADDR")
+	if advertise == "" && coordinatorURL != "" {
+		host, _ := os.Hostname()
+		_, port, _ := net.SplitHostPort(addr)
+		advertise = net.JoinHostPort(host, port)
+	}
+
+	node := newMLNode(nodeID, advertise, annDir)
+
+	// carga del índice al arrancar: la partición propia si conocemos el
+	// anillo, el grafo completo si el nodo no tiene identidad en el anillo.
+	// Si hay identidad pero no anillo, se carga con el de la primera tarea.
+	switch ring := os.Getenv("ML_RING_NODES"); {
+	case advertise != "" && ring != "":
+		node.index.ensure(splitAddrs(ring))
+	case advertise == "":
+		if err := node.index.reload(context.Background()); err != nil {
+			log.Printf("[ML NODE %s] error cargando índice: %v", nodeID, err)
+		}
+	}
+
+	srv := cluster.NewServer(nodeID)
+	srv.Handle(cluster.MsgRecommend, node.handleRecommendMsg)
+	srv.Handle(cluster.MsgBuildSimilarities, node.handleBuildSimilaritiesMsg)
+	srv.Handle(cluster.MsgReloadIndex, node.handleReloadIndexMsg)
+	srv.Handle(cluster.MsgTrainMF, node.handleTrainMFMsg)
+	srv.Handle(cluster.MsgLoadMF, node.handleLoadMFMsg)
+	srv.Handle(cluster.MsgUpdateNeighbors, node.handleUpdateNeighborsMsg)
+	srv.Handle(cluster.MsgBuildANN, node.handleBuildANNMsg)
+	srv.SetStatsExtra(func() map[string]any {
+		return map[string]any{
+			"index": node.index.stats(),
+			"mf":    node.mf.stats(),
+			"ann":   node.ann.stats(),
+		}
+	})
+	// coordinadores viejos mandan el RecTask sin sobre
+	srv.HandleLegacy(node.handleRecommendMsg)
 
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Println("accept error:", err)
-			continue
+	// auto-registro en el coordinador (opcional)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var reg *coordinatorRegistration
+	if coordinatorURL != "" && cfg.ClusterToken == "" {
+		log.Printf("[ML NODE %s] COORDINATOR_URL sin CLUSTER_TOKEN: no se auto-registra", nodeID)
+	} else if coordinatorURL != "" {
+		reg = &coordinatorRegistration{
+			url:       coordinatorURL,
+			token:     cfg.ClusterToken,
+			advertise: advertise,
+			nodeID:    nodeID,
+			client:    &http.Client{Timeout: 5 * time.Second},
 		}
-		go handleConn(nodeID, conn, simsRepo)
+		go reg.run(ctx, time.Minute)
 	}
-}
 
-func handleConn(nodeID string, conn net.Conn, sims *repository.SimilarityRepository) {
-	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		if reg != nil {
+			reg.deregister()
+		}
+		ln.Close()
+	}()
+
+	if err := srv.Serve(ln); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+	log.Printf("[ML NODE %s] apagado", nodeID)
+}
 
-	dec := json.NewDecoder(bufio.NewReader(conn))
+func (n *mlNode) handleRecommendMsg(ctx context.Context, payload json.RawMessage) (any, error) {
 	var task cluster.RecTask
-	if err := dec.Decode(&task); err != nil {
-		log.Printf("[ML NODE %s] decode task error: %v", nodeID, err)
-		return
+	if err := cluster.Decode(payload, &task); err != nil {
+		log.Printf("[ML NODE %s] decode task error: %v", n.id, err)
+		return nil, err
 	}
+	return n.handleRecommend(ctx, task)
+}
 
-	log.Printf("[ML NODE %s] tarea recibida: user=%d shard=%d/%d ratings=%d",
-		nodeID, task.UserID, task.ShardID, task.Shards, len(task.Ratings))
+func (n *mlNode) handleRecommend(ctx context.Context, task cluster.RecTask) (*cluster.RecResponse, error) {
+	log.Printf("[ML NODE %s] tarea recibida: user=%d shard=%d/%d ratings=%d algo=%s mode=%s metric=%s",
+		n.id, task.UserID, task.ShardID, task.Shards, len(task.Ratings), task.Algo, task.Mode, task.Metric)
 
 	start := time.Now()
 
-	partials, err := computeShardRecommendations(context.Background(), task, sims)
+	if task.Algo == ml.AlgoMF {
+		m, err := n.mf.get(ctx, task.ModelID)
+		if err != nil {
+			return nil, err
+		}
+		partials, err := computeShardMF(task, m)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[ML NODE %s] completado (mf): user=%d shard=%d/%d candidatos=%d tiempo=%s",
+			n.id, task.UserID, task.ShardID, task.Shards, len(partials), time.Since(start))
+		return &cluster.RecResponse{ShardID: task.ShardID, Partials: partials}, nil
+	}
+
+	n.index.ensure(task.Ring)
+
+	params := ml.PredictParams{
+		Mode:     task.Mode,
+		UserMean: task.UserMean,
+		UserBias: task.UserBias,
+	}
+	if task.Mode == ml.ModeBaseline {
+		b, err := n.baselines.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// la media global que usó el coordinador para b_u
+		params.Baselines = &ml.Baselines{GlobalMean: task.GlobalMean, ItemBias: b.ItemBias}
+	}
+
+	metric := task.Metric
+	if metric == "" {
+		metric = ml.DefaultMetric
+	}
+	getNeighbors := func(ctx context.Context, movieID, k int) ([]models.Neighbor, error) {
+		return n.neighbors(ctx, movieID, metric, k)
+	}
+
+	partials, misses, err := computeShardRecommendations(ctx, task, params, getNeighbors)
 	if err != nil {
-		log.Printf("[ML NODE %s] compute error: %v", nodeID, err)
-		return
+		log.Printf("[ML NODE %s] compute error: %v", n.id, err)
+		return nil, err
 	}
 
 	elapsed := time.Since(start)
 
 	log.Printf(
 		"[ML NODE %s] completado: user=%d shard=%d/%d movies_parciales=%d tiempo=%s",
-		nodeID, task.UserID, task.ShardID, task.Shards, len(partials), elapsed,
+		n.id, task.UserID, task.ShardID, task.Shards, len(partials), elapsed,
 	)
 
-	resp := cluster.RecResponse{
+	return &cluster.RecResponse{
 		ShardID:  task.ShardID,
 		Partials: partials,
+		Misses:   misses,
+	}, nil
+}
+
+// neighbors devuelve los vecinos de una película en una métrica: del
+// índice en memoria si está, si no de Mongo. Si Mongo tampoco los tiene se
+// recuerda, así la película no vuelve a costar una consulta por petición.
+func (n *mlNode) neighbors(ctx context.Context, movieID int, metric string, k int) ([]models.Neighbor, error) {
+	if neighs, ok := n.index.get(movieID, metric, k); ok {
+		return neighs, nil
+	}
+	neighs, err := n.sims.GetNeighbors(ctx, movieID, metric, k)
+	if err == nil && len(neighs) == 0 {
+		n.index.markAbsent(movieID, metric)
+	}
+	return neighs, err
+}
+
+func (n *mlNode) handleReloadIndexMsg(ctx context.Context, _ json.RawMessage) (any, error) {
+	if err := n.index.reload(ctx); err != nil {
+		return nil, err
 	}
+	return n.index.stats(), nil
+}
 
-	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
-		log.Printf("[ML NODE %s] encode resp error: %v", nodeID, err)
+func (n *mlNode) handleUpdateNeighborsMsg(_ context.Context, payload json.RawMessage) (any, error) {
+	var upd cluster.NeighborsUpdate
+	if err := cluster.Decode(payload, &upd); err != nil {
+		return nil, err
 	}
+	applied := n.index.patch(upd.Docs)
+	log.Printf("[ML NODE %s] vecinos actualizados: recibidos=%d aplicados=%d", n.id, len(upd.Docs), applied)
+	return &cluster.NeighborsUpdateResponse{Applied: applied}, nil
 }
 
 func computeShardRecommendations(
 	ctx context.Context,
 	task cluster.RecTask,
-	simsRepo *repository.SimilarityRepository,
-) ([]cluster.PartialScore, error) {
+	params ml.PredictParams,
+	getNeighbors func(ctx context.Context, movieID, k int) ([]models.Neighbor, error),
+) ([]cluster.PartialScore, []int, error) {
 
-	rated := make(map[int]float64)
+	rated := make(map[int]bool, len(task.Ratings))
 	for _, r := range task.Ratings {
-		rated[r.MovieID] = r.Rating
+		rated[r.MovieID] = true
 	}
 
-	scores := make(map[int]float64)
-	weights := make(map[int]float64)
+	acc := ml.NewKNNAccumulator(params, rated)
+	var misses []int
+
+	k := task.Neighbors
+	if k <= 0 {
+		k = cluster.DefaultRecNeighbors
+	}
 
 	for idx, r := range task.Ratings {
-		if task.Shards > 0 && idx%task.Shards != task.ShardID {
+		// con anillo, el coordinador ya mandó solo lo de este shard
+		if len(task.Ring) == 0 && task.Shards > 0 && idx%task.Shards != task.ShardID {
 			continue
 		}
 
-		neighs, err := simsRepo.GetNeighbors(ctx, r.MovieID, 100)
+		neighs, err := getNeighbors(ctx, r.MovieID, k)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-
-		for _, n := range neighs {
-			targetID := n.MovieID
-			if _, ya := rated[targetID]; ya {
-				continue
-			}
-			if n.Sim <= 0 {
-				continue
-			}
-
-			scores[targetID] += n.Sim * r.Rating
-			weights[targetID] += n.Sim
+		if len(neighs) == 0 {
+			misses = append(misses, r.MovieID)
+			continue
 		}
+		acc.Add(r, neighs)
 	}
 
-	partials := make([]cluster.PartialScore, 0, len(scores))
+	partials := make([]cluster.PartialScore, 0, len(acc.Partials))
 
-	for mID, num := range scores {
-		den := weights[mID]
-		if den <= 0 {
+	for mID, p := range acc.Partials {
+		if p.Den <= 0 {
 			continue
 		}
 		partials = append(partials, cluster.PartialScore{
 			MovieID: mID,
-			Num:     num,
-			Den:     den,
+			Num:     p.Num,
+			Den:     p.Den,
+			Base:    p.Base,
 		})
 	}
 
-	return partials, nil
+	return partials, misses, nil
+}
+
+func splitAddrs(env string) []string {
+	var out []string
+	for _, v := range strings.Split(env, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
 }
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/cluster"
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+)
+
+// factores por lote al guardar en mf_factors
+const mfInsertBatch = 1000
+
+// mfModel factores de ítem de un modelo cargados en memoria. Las filas son
+// iIdx; movieOf traduce fila -> movieId (0 = fila sin película).
+type mfModel struct {
+	id      string
+	f       *ml.ItemFactors
+	rowOf   map[int]int // movieId -> fila
+	movieOf []int
+
+	// filas de cada shard, por firma de anillo + dueño (se calcula una vez)
+	mu    sync.Mutex
+	owned map[string][]int
+}
+
+func (m *mfModel) approxBytes() int64 {
+	return int64(len(m.f.Q))*4 + int64(len(m.f.Bias))*4 +
+		int64(len(m.movieOf))*8 + int64(len(m.rowOf))*16
+}
+
+// shardRows filas que le tocan al shard de la tarea: las del dueño en el
+// anillo, o iIdx % Shards sin anillo. Las filas se calculan por el shard
+// y no por este nodo, así un reintento en otro nodo puntúa lo mismo.
+func (m *mfModel) shardRows(task cluster.RecTask) []int {
+	var key string
+	var owns func(row int) bool
+
+	switch {
+	case len(task.Ring) > 0 && task.ShardID >= 0 && task.ShardID < len(task.Ring):
+		ring := cluster.NewRing(task.Ring, cluster.DefaultVNodes)
+		owner := ring.Members()[task.ShardID]
+		key = ring.Signature() + "|" + owner
+		owns = func(row int) bool { return ring.Owner(row) == owner }
+	case task.Shards > 1:
+		key = fmt.Sprintf("mod|%d|%d", task.Shards, task.ShardID)
+		owns = func(row int) bool { return row%task.Shards == task.ShardID }
+	default:
+		key = "all"
+		owns = func(int) bool { return true }
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rows, ok := m.owned[key]; ok {
+		return rows
+	}
+	var rows []int
+	for row, movieID := range m.movieOf {
+		if movieID != 0 && owns(row) {
+			rows = append(rows, row)
+		}
+	}
+	m.owned[key] = rows
+	return rows
+}
+
+// mfStore carga bajo demanda el modelo pedido por las tareas (uno a la vez).
+type mfStore struct {
+	nodeID string
+	repo   *repository.MFRepository
+
+	mu      sync.Mutex
+	model   *mfModel
+	loading string        // id en carga
+	done    chan struct{} // se cierra al terminar la carga en curso
+	loadErr error
+}
+
+func newMFStore(nodeID string, repo *repository.MFRepository) *mfStore {
+	return &mfStore{nodeID: nodeID, repo: repo}
+}
+
+// get devuelve el modelo modelID, cargándolo si hace falta. La carga no
+// depende de ctx: si la petición expira, el modelo sigue cargándose para
+// las siguientes.
+func (s *mfStore) get(ctx context.Context, modelID string) (*mfModel, error) {
+	s.mu.Lock()
+	if s.model != nil && s.model.id == modelID {
+		m := s.model
+		s.mu.Unlock()
+		return m, nil
+	}
+	if s.loading != modelID {
+		s.loading = modelID
+		s.done = make(chan struct{})
+		go s.load(modelID, s.done)
+	}
+	done := s.done
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.model != nil && s.model.id == modelID {
+		return s.model, nil
+	}
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return nil, cluster.NewError(cluster.ErrCodeInternal, "modelo %s no disponible", modelID)
+}
+
+func (s *mfStore) load(modelID string, done chan struct{}) {
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	m, err := s.read(ctx, modelID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loading == modelID {
+		s.loading = ""
+	}
+	s.loadErr = err
+	if err != nil {
+		log.Printf("[ML NODE %s] error cargando modelo MF %s: %v", s.nodeID, modelID, err)
+		return
+	}
+	s.model = m
+	log.Printf("[ML NODE %s] modelo MF %s cargado: ítems=%d memoria≈%.1fMB tiempo=%s",
+		s.nodeID, modelID, len(m.rowOf), float64(m.approxBytes())/(1<<20), time.Since(start))
+}
+
+func (s *mfStore) read(ctx context.Context, modelID string) (*mfModel, error) {
+	meta, err := s.repo.GetModel(ctx, modelID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil || meta.Status != models.MFStatusReady {
+		return nil, cluster.NewError(cluster.ErrCodeBadPayload, "modelo %s no existe o no está listo", modelID)
+	}
+
+	var docs []models.MFFactorDoc
+	maxRow := -1
+	err = s.repo.ForEachFactor(ctx, modelID, models.MFKindItem, func(doc *models.MFFactorDoc) {
+		docs = append(docs, *doc)
+		if doc.Idx > maxRow {
+			maxRow = doc.Idx
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	F := meta.Factors
+	rows := maxRow + 1
+	m := &mfModel{
+		id: modelID,
+		f: &ml.ItemFactors{
+			GlobalMean: meta.GlobalMean,
+			Factors:    F,
+			Bias:       make([]float32, rows),
+			Q:          make([]float32, rows*F),
+		},
+		rowOf:   make(map[int]int, len(docs)),
+		movieOf: make([]int, rows),
+		owned:   make(map[string][]int),
+	}
+	for _, d := range docs {
+		m.f.Bias[d.Idx] = float32(d.Bias)
+		for k := 0; k < F && k < len(d.Vec); k++ {
+			m.f.Q[d.Idx*F+k] = float32(d.Vec[k])
+		}
+		m.rowOf[d.ID] = d.Idx
+		m.movieOf[d.Idx] = d.ID
+	}
+	return m, nil
+}
+
+// stats resumen del modelo cargado para el mensaje stats.
+func (s *mfStore) stats() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := map[string]any{"loading": s.loading}
+	if s.model != nil {
+		out["modelId"] = s.model.id
+		out["items"] = len(s.model.rowOf)
+		out["approxBytes"] = s.model.approxBytes()
+	}
+	return out
+}
+
+// ---------------------- recomendación ----------------------
+
+// computeShardMF puntúa con el modelo los ítems del shard: el usuario se
+// arma (fold-in) con todos sus ratings y se devuelven los mejores K.
+func computeShardMF(task cluster.RecTask, m *mfModel) ([]cluster.PartialScore, error) {
+	rated := make(map[int]float64, len(task.Ratings))
+	ratedMovies := make(map[int]bool, len(task.Ratings))
+	for _, r := range task.Ratings {
+		ratedMovies[r.MovieID] = true
+		if row, ok := m.rowOf[r.MovieID]; ok {
+			rated[row] = r.Rating
+		}
+	}
+	if len(rated) == 0 {
+		return []cluster.PartialScore{}, nil
+	}
+
+	bu, pu, err := m.f.FoldIn(rated, ml.DefaultMFParams.Reg)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := m.shardRows(task)
+	scored := make([]cluster.PartialScore, 0, len(rows))
+	for _, row := range rows {
+		movieID := m.movieOf[row]
+		if ratedMovies[movieID] {
+			continue
+		}
+		// score directo: num/den = score
+		scored = append(scored, cluster.PartialScore{MovieID: movieID, Num: m.f.Score(row, bu, pu), Den: 1})
+	}
+
+	sort.Slice(scored, func(i, j int) bool { return scored[i].Num > scored[j].Num })
+	if task.K > 0 && len(scored) > task.K {
+		scored = scored[:task.K]
+	}
+	return scored, nil
+}
+
+// ---------------------- entrenamiento ----------------------
+
+func (n *mlNode) handleTrainMFMsg(ctx context.Context, payload json.RawMessage) (any, error) {
+	var task cluster.MFTrainTask
+	if err := cluster.Decode(payload, &task); err != nil {
+		return nil, err
+	}
+	if task.ModelID == "" {
+		return nil, cluster.NewError(cluster.ErrCodeBadPayload, "falta modelId")
+	}
+	return n.trainMF(ctx, task)
+}
+
+func (n *mlNode) handleLoadMFMsg(ctx context.Context, payload json.RawMessage) (any, error) {
+	var req cluster.MFLoadRequest
+	if err := cluster.Decode(payload, &req); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	m, err := n.mf.get(ctx, req.ModelID)
+	if err != nil {
+		return nil, err
+	}
+	return &cluster.MFLoadResponse{
+		ModelID:     m.id,
+		Items:       len(m.rowOf),
+		ApproxBytes: m.approxBytes(),
+		LoadMs:      time.Since(start).Milliseconds(),
+	}, nil
+}
+
+// trainMF entrena sobre toda la colección ratings usando los uIdx/iIdx
+// existentes como filas y guarda el modelo en mf_models/mf_factors.
+func (n *mlNode) trainMF(ctx context.Context, task cluster.MFTrainTask) (*cluster.MFTrainResponse, error) {
+	params := ml.MFParams{
+		Factors:      task.Factors,
+		Epochs:       task.Epochs,
+		LearningRate: task.LearningRate,
+		Reg:          task.Reg,
+		Seed:         task.Seed,
+	}.WithDefaults()
+
+	log.Printf("[ML NODE %s] entrenando MF %s: factores=%d épocas=%d lr=%g reg=%g",
+		n.id, task.ModelID, params.Factors, params.Epochs, params.LearningRate, params.Reg)
+	start := time.Now()
+
+	meta, err := n.mfRepo.GetModel(ctx, task.ModelID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		meta = &models.MFModel{ID: task.ModelID, Status: models.MFStatusTraining, StartedAt: start}
+		if err := n.mfRepo.CreateModel(ctx, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	uIdxOf, err := n.users.UIdxMapping(ctx)
+	if err != nil {
+		return nil, err
+	}
+	iIdxOf, err := n.movies.IIdxMapping(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []ml.Triplet
+	nUsers, nItems := 0, 0
+	err = n.ratings.ForEach(ctx, func(rd *models.RatingDoc) {
+		u, okU := uIdxOf[rd.UserID]
+		i, okI := iIdxOf[rd.MovieID]
+		if !okU || !okI {
+			return
+		}
+		data = append(data, ml.Triplet{U: int32(u), I: int32(i), R: float32(rd.Rating)})
+		if u >= nUsers {
+			nUsers = u + 1
+		}
+		if i >= nItems {
+			nItems = i + 1
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, cluster.NewError(cluster.ErrCodeInternal, "no hay ratings con uIdx/iIdx para entrenar")
+	}
+	log.Printf("[ML NODE %s] MF %s: %d ratings cargados (%s)", n.id, task.ModelID, len(data), time.Since(start))
+
+	var rmse float64
+	model := ml.TrainSGD(data, nUsers, nItems, params, func(epoch int, e float64) {
+		rmse = e
+		log.Printf("[ML NODE %s] MF %s época %d/%d rmse=%.4f", n.id, task.ModelID, epoch, params.Epochs, e)
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// solo se guardan filas que existen en los mapeos; si la escritura
+	// falla a medias no dejamos factores sueltos
+	users, err := n.saveFactors(ctx, task.ModelID, models.MFKindUser, uIdxOf, model.UserBias, model.P, model.Factors)
+	if err != nil {
+		_ = n.mfRepo.DeleteFactors(context.Background(), task.ModelID)
+		return nil, err
+	}
+	items, err := n.saveFactors(ctx, task.ModelID, models.MFKindItem, iIdxOf, model.Bias, model.Q, model.Factors)
+	if err != nil {
+		_ = n.mfRepo.DeleteFactors(context.Background(), task.ModelID)
+		return nil, err
+	}
+
+	finished := time.Now()
+	meta.Status = models.MFStatusReady
+	meta.Node = n.id
+	meta.Factors, meta.Epochs = params.Factors, params.Epochs
+	meta.LearningRate, meta.Reg = params.LearningRate, params.Reg
+	meta.GlobalMean = model.GlobalMean
+	meta.Users, meta.Items, meta.Ratings = users, items, len(data)
+	meta.TrainRMSE = rmse
+	meta.FinishedAt = &finished
+	if err := n.mfRepo.UpdateModel(ctx, meta); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[ML NODE %s] MF %s listo: usuarios=%d ítems=%d rmse=%.4f tiempo=%s",
+		n.id, task.ModelID, users, items, rmse, time.Since(start))
+
+	return &cluster.MFTrainResponse{
+		ModelID:   task.ModelID,
+		Users:     users,
+		Items:     items,
+		Ratings:   len(data),
+		TrainRMSE: rmse,
+		Seconds:   time.Since(start).Seconds(),
+	}, nil
+}
+
+// saveFactors guarda una fila por id (userId/movieId) con su índice.
+func (n *mlNode) saveFactors(
+	ctx context.Context,
+	modelID, kind string,
+	idxOf map[int]int,
+	bias, vecs []float32,
+	factors int,
+) (int, error) {
+
+	batch := make([]models.MFFactorDoc, 0, mfInsertBatch)
+	saved := 0
+	flush := func() error {
+		if err := n.mfRepo.InsertFactors(ctx, batch); err != nil {
+			return err
+		}
+		saved += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for id, idx := range idxOf {
+		if idx < 0 || idx >= len(bias) {
+			continue // sin ratings en el entrenamiento
+		}
+		vec := make([]float64, factors)
+		for k := range vec {
+			vec[k] = float64(vecs[idx*factors+k])
+		}
+		batch = append(batch, models.MFFactorDoc{
+			ModelID: modelID, Kind: kind, Idx: idx, ID: id,
+			Bias: float64(bias[idx]), Vec: vec,
+		})
+		if len(batch) == mfInsertBatch {
+			if err := flush(); err != nil {
+				return saved, err
+			}
+		}
+	}
+	return saved, flush()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"nodosml-pc4/internal/cluster"
+	"nodosml-pc4/internal/db"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testNode nodo armado igual que en main. Sin MONGO_TEST_URI apunta a un
+// Mongo inexistente: las operaciones fallan rápido, pero el cableado del
+// nodo se ejercita entero.
+func testNode(t *testing.T) *mlNode {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		uri = "mongodb://127.0.0.1:1"
+	}
+	client, err := mongo.Connect(context.Background(),
+		options.Client().ApplyURI(uri).SetServerSelectionTimeout(300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+	db.UseDatabase(client.Database("nodosml_test_mlnode"))
+
+	return newMLNode("test", "", t.TempDir())
+}
+
+func TestNewMLNodeWiring(t *testing.T) {
+	n := testNode(t)
+	switch {
+	case n.sims == nil, n.ratings == nil, n.movies == nil, n.users == nil, n.mfRepo == nil:
+		t.Fatalf("repositorio sin setear: %+v", n)
+	case n.universe == nil, n.index == nil, n.baselines == nil, n.mf == nil, n.ann == nil:
+		t.Fatalf("componente sin setear: %+v", n)
+	}
+}
+
+func TestHandleTrainMFMsg(t *testing.T) {
+	n := testNode(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := n.handleTrainMFMsg(ctx, json.RawMessage(`{}`)); err == nil {
+		t.Fatal("aceptó una tarea sin modelId")
+	}
+
+	// base vacía (o inalcanzable): la tarea falla con error, sin tirar el nodo
+	payload, _ := json.Marshal(cluster.MFTrainTask{ModelID: "mf-test", Epochs: 1})
+	if _, err := n.handleTrainMFMsg(ctx, payload); err == nil {
+		t.Fatal("entrenó sin ratings")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// coordinatorRegistration registra el nodo en la API (coordinador) para que
+// lo tenga en cuenta aunque no esté en ML_NODE_ADDRS. El alta se repite
+// cada cierto tiempo: si la API se reinicia, el nodo vuelve a aparecer.
+type coordinatorRegistration struct {
+	url       string // p.e. http://api:8080
+	token     string
+	advertise string // host:puerto con el que la API llega al nodo
+	nodeID    string
+	client    *http.Client
+}
+
+func (c *coordinatorRegistration) run(ctx context.Context, every time.Duration) {
+	registered := false
+	for {
+		err := c.post(ctx, "/cluster/nodes/register")
+		switch {
+		case err != nil:
+			log.Printf("[ML NODE %s] no se pudo registrar en %s: %v", c.nodeID, c.url, err)
+		case !registered:
+			log.Printf("[ML NODE %s] registrado en %s como %s", c.nodeID, c.url, c.advertise)
+		}
+		registered = err == nil
+
+		// si aún no se pudo registrar, reintenta más seguido
+		wait := every
+		if !registered {
+			wait = 5 * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *coordinatorRegistration) deregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := c.post(ctx, "/cluster/nodes/deregister"); err != nil {
+		log.Printf("[ML NODE %s] no se pudo dar de baja: %v", c.nodeID, err)
+		return
+	}
+	log.Printf("[ML NODE %s] dado de baja en %s", c.nodeID, c.url)
+}
+
+func (c *coordinatorRegistration) post(ctx context.Context, path string) error {
+	body, _ := json.Marshal(map[string]string{"addr": c.advertise, "nodeId": c.nodeID})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cluster-Token", c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/cluster"
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+)
+
+// itemUniverse cachea los datos por ítem que usan las métricas y el mapeo
+// movieId->iIdx. Calcularlos implica recorrer toda la colección ratings,
+// así que se reutilizan entre batches durante ttl. Las normas siempre se
+// cargan; lo propio de cada métrica, la primera vez que se pide.
+type itemUniverse struct {
+	ratings *repository.RatingRepository
+	movies  *repository.MovieRepository
+	ttl     time.Duration
+
+	mu       sync.Mutex
+	u        *ml.ItemUniverse // solo películas con iIdx
+	iIdxOf   map[int]int
+	loadedAt time.Time
+}
+
+func newItemUniverse(r *repository.RatingRepository, m *repository.MovieRepository, ttl time.Duration) *itemUniverse {
+	return &itemUniverse{ratings: r, movies: m, ttl: ttl}
+}
+
+func (u *itemUniverse) get(ctx context.Context, metrics []string) (*ml.ItemUniverse, map[int]int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.u == nil || time.Since(u.loadedAt) >= u.ttl {
+		iIdxOf, err := u.movies.IIdxMapping(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		allNorms, err := u.ratings.ItemNorms(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// el universo de vecinos son solo las películas mapeadas
+		norms := make(map[int]float64, len(iIdxOf))
+		for movieID := range iIdxOf {
+			if n, ok := allNorms[movieID]; ok {
+				norms[movieID] = n
+			}
+		}
+		u.u, u.iIdxOf, u.loadedAt = &ml.ItemUniverse{Norms: norms}, iIdxOf, time.Now()
+	}
+
+	// los batches en curso pueden estar leyendo u.u: se completa una copia
+	next := *u.u
+	for _, metric := range metrics {
+		var err error
+		switch metric {
+		case ml.MetricPearson, ml.MetricJaccard:
+			if next.Means == nil {
+				err = u.loadItemStats(ctx, &next)
+			}
+		case ml.MetricAdjustedCosine:
+			if next.CenteredNorms == nil {
+				err = u.loadCenteredNorms(ctx, &next)
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	u.u = &next
+	return u.u, u.iIdxOf, nil
+}
+
+// loadItemStats medias y cantidad de ratings por película (pearson, jaccard).
+func (u *itemUniverse) loadItemStats(ctx context.Context, next *ml.ItemUniverse) error {
+	stats, err := u.ratings.ItemStats(ctx)
+	if err != nil {
+		return err
+	}
+	next.Means = make(map[int]float64, len(next.Norms))
+	next.Counts = make(map[int]int, len(next.Norms))
+	for movieID := range next.Norms {
+		if st, ok := stats[movieID]; ok && st.Count > 0 {
+			next.Means[movieID] = st.Sum / float64(st.Count)
+			next.Counts[movieID] = st.Count
+		}
+	}
+	return nil
+}
+
+// loadCenteredNorms medias por usuario y normas de los ratings centrados en
+// ellas (adjusted-cosine). Necesita una pasada completa por ratings.
+func (u *itemUniverse) loadCenteredNorms(ctx context.Context, next *ml.ItemUniverse) error {
+	means, err := u.ratings.UserMeans(ctx)
+	if err != nil {
+		return err
+	}
+	sq := make(map[int]float64, len(next.Norms))
+	err = u.ratings.ForEach(ctx, func(rd *models.RatingDoc) {
+		if _, ok := next.Norms[rd.MovieID]; !ok {
+			return
+		}
+		c := rd.Rating - means[rd.UserID]
+		sq[rd.MovieID] += c * c
+	})
+	if err != nil {
+		return err
+	}
+	for movieID, v := range sq {
+		sq[movieID] = math.Sqrt(v)
+	}
+	next.UserMeans, next.CenteredNorms = means, sq
+	return nil
+}
+
+func (n *mlNode) handleBuildSimilaritiesMsg(ctx context.Context, payload json.RawMessage) (any, error) {
+	var task cluster.SimTask
+	if err := cluster.Decode(payload, &task); err != nil {
+		return nil, err
+	}
+	return n.handleBuildSimilarities(ctx, task)
+}
+
+// handleBuildSimilarities calcula y guarda los vecinos de un batch de iIdxs
+// en cada métrica pedida.
+func (n *mlNode) handleBuildSimilarities(ctx context.Context, task cluster.SimTask) (*cluster.SimResponse, error) {
+	log.Printf("[ML NODE %s] batch similitudes recibido: batch=%d items=%d k=%d minCommon=%d shrink=%d metrics=%v",
+		n.id, task.BatchID, len(task.IIdxs), task.K, task.MinCommonUsers, task.Shrink, task.Metrics)
+
+	start := time.Now()
+	resp := &cluster.SimResponse{BatchID: task.BatchID}
+
+	processed, upserted, err := n.buildSimilarities(ctx, task)
+	resp.Processed, resp.Upserted = processed, upserted
+	if err != nil {
+		log.Printf("[ML NODE %s] error en batch %d (procesadas=%d upserts=%d): %v",
+			n.id, task.BatchID, processed, upserted, err)
+		return nil, err
+	}
+
+	log.Printf("[ML NODE %s] batch %d completado: procesadas=%d upserts=%d tiempo=%s",
+		n.id, task.BatchID, processed, upserted, time.Since(start))
+	return resp, nil
+}
+
+func (n *mlNode) buildSimilarities(ctx context.Context, task cluster.SimTask) (int, int, error) {
+	metrics := task.Metrics
+	if len(metrics) == 0 {
+		metrics = []string{ml.DefaultMetric}
+	}
+
+	// la métrica embedding sale del índice ANN; el resto, de los co-ratings
+	var (
+		fromRatings bool
+		ann         *ml.HNSW
+		annSpec     cluster.ANNSpec
+		err         error
+	)
+	for _, metric := range metrics {
+		if metric != ml.MetricEmbedding {
+			fromRatings = true
+			continue
+		}
+		if task.ANN == nil {
+			return 0, 0, cluster.NewError(cluster.ErrCodeBadPayload, "la métrica embedding necesita el índice ann")
+		}
+		if ann, annSpec, err = n.ann.get(ctx, *task.ANN, false); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var (
+		universe *ml.ItemUniverse
+		iIdxOf   map[int]int
+	)
+	if fromRatings {
+		universe, iIdxOf, err = n.universe.get(ctx, metrics)
+	} else {
+		iIdxOf, err = n.movies.IIdxMapping(ctx)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	targets, err := n.movies.GetByIIdxs(ctx, task.IIdxs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	params := ml.SimilarityParams{
+		K:              task.K,
+		MinCommonUsers: task.MinCommonUsers,
+		Shrink:         task.Shrink,
+	}
+
+	upserted := 0
+	for _, m := range targets {
+		if err := ctx.Err(); err != nil {
+			return len(targets), upserted, err
+		}
+
+		// los co-ratings se leen una vez y sirven para todas las métricas
+		var (
+			raters map[int]float64
+			byUser map[int][]models.RatingDoc
+		)
+		if fromRatings {
+			if raters, byUser, err = n.coRatings(ctx, m.MovieID); err != nil {
+				return len(targets), upserted, err
+			}
+		}
+
+		for _, metric := range metrics {
+			var scored []ml.ScoredItem
+			if metric == ml.MetricEmbedding {
+				scored = ann.SearchID(m.MovieID, task.K, annSpec.EfSearch)
+			} else {
+				scored = ml.ItemNeighbors(metric, m.MovieID, raters, byUser, universe, params)
+			}
+
+			neighbors := make([]models.Neighbor, 0, len(scored))
+			for _, s := range scored {
+				iIdx, ok := iIdxOf[s.MovieID]
+				if !ok {
+					continue
+				}
+				neighbors = append(neighbors, models.Neighbor{
+					MovieID: s.MovieID,
+					IIdx:    iIdx,
+					Sim:     s.Sim,
+				})
+			}
+
+			doc := &models.SimilarityDoc{
+				MovieID:   m.MovieID,
+				IIdx:      *m.IIdx,
+				Metric:    metric,
+				K:         task.K,
+				Neighbors: neighbors,
+				UpdatedAt: time.Now().Format(time.RFC3339),
+			}
+			if err := n.sims.Upsert(ctx, doc); err != nil {
+				return len(targets), upserted, err
+			}
+			upserted++
+		}
+	}
+
+	return len(targets), upserted, nil
+}
+
+// coRatings ratings de la película (userId -> rating) y todos los ratings
+// de esos usuarios, agrupados por usuario.
+func (n *mlNode) coRatings(ctx context.Context, movieID int) (map[int]float64, map[int][]models.RatingDoc, error) {
+	targetRatings, err := n.ratings.GetByMovie(ctx, movieID)
+	if err != nil {
+		return nil, nil, err
+	}
+	raters := make(map[int]float64, len(targetRatings))
+	userIDs := make([]int, 0, len(targetRatings))
+	for _, r := range targetRatings {
+		raters[r.UserID] = r.Rating
+		userIDs = append(userIDs, r.UserID)
+	}
+
+	coRatings, err := n.ratings.GetByUsers(ctx, userIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	byUser := make(map[int][]models.RatingDoc, len(userIDs))
+	for _, r := range coRatings {
+		byUser[r.UserID] = append(byUser[r.UserID], r)
+	}
+	return raters, byUser, nil
+}
@@ -1,29 +1,198 @@
 package cluster
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
-	"net"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultClient es el cliente que usan las funciones de paquete.
+var DefaultClient = NewClient(ClientOptions{})
+
+// contador para IDs de petición únicos dentro del proceso
+var reqSeq uint64
+
+func nextRequestID() string {
+	n := atomic.AddUint64(&reqSeq, 1)
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(n, 36)
+}
+
 func SendTask(ctx context.Context, addr string, task *RecTask) (*RecResponse, error) {
-	d := net.Dialer{}
-	conn, err := d.DialContext(ctx, "tcp", addr)
-	if err != nil {
+	return DefaultClient.SendTask(ctx, addr, task)
+}
+
+// SendSimTask envía un batch de recálculo de similitudes a un nodo ML.
+func SendSimTask(ctx context.Context, addr string, task *SimTask) (*SimResponse, error) {
+	return DefaultClient.SendSimTask(ctx, addr, task)
+}
+
+// Ping verifica que el nodo responda.
+func Ping(ctx context.Context, addr string) (*PingResponse, error) {
+	return DefaultClient.Ping(ctx, addr)
+}
+
+// Stats pide las estadísticas de un nodo.
+func Stats(ctx context.Context, addr string) (*NodeStats, error) {
+	return DefaultClient.Stats(ctx, addr)
+}
+
+// Cancel pide a un nodo cancelar la petición con ese ID.
+func Cancel(ctx context.Context, addr, targetID string) (bool, error) {
+	return DefaultClient.Cancel(ctx, addr, targetID)
+}
+
+// ReloadIndex pide a un nodo recargar su índice de vecinos en memoria.
+func ReloadIndex(ctx context.Context, addr string) (*IndexStats, error) {
+	return DefaultClient.ReloadIndex(ctx, addr)
+}
+
+// TrainMF pide a un nodo entrenar un modelo de factorización.
+func TrainMF(ctx context.Context, addr string, task *MFTrainTask) (*MFTrainResponse, error) {
+	return DefaultClient.TrainMF(ctx, addr, task)
+}
+
+// LoadMF pide a un nodo cargar en memoria un modelo de factorización.
+func LoadMF(ctx context.Context, addr, modelID string) (*MFLoadResponse, error) {
+	return DefaultClient.LoadMF(ctx, addr, modelID)
+}
+
+// UpdateNeighbors manda a un nodo vecinos recalculados para que reemplace
+// esas filas de su índice en memoria.
+func UpdateNeighbors(ctx context.Context, addr string, upd *NeighborsUpdate) (*NeighborsUpdateResponse, error) {
+	return DefaultClient.UpdateNeighbors(ctx, addr, upd)
+}
+
+// BuildANN pide a un nodo preparar su índice de vecinos aproximados.
+func BuildANN(ctx context.Context, addr string, task *ANNBuildTask) (*ANNBuildResponse, error) {
+	return DefaultClient.BuildANN(ctx, addr, task)
+}
+
+// Call envía un mensaje tipado usando DefaultClient.
+func Call(ctx context.Context, addr, msgType string, req, resp any) error {
+	return DefaultClient.Call(ctx, addr, msgType, req, resp)
+}
+
+func (c *Client) SendTask(ctx context.Context, addr string, task *RecTask) (*RecResponse, error) {
+	var resp RecResponse
+	if err := c.Call(ctx, addr, MsgRecommend, task, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) SendSimTask(ctx context.Context, addr string, task *SimTask) (*SimResponse, error) {
+	var resp SimResponse
+	if err := c.Call(ctx, addr, MsgBuildSimilarities, task, &resp); err != nil {
 		return nil, err
 	}
-	defer conn.Close()
+	return &resp, nil
+}
 
-	enc := json.NewEncoder(conn)
-	if err := enc.Encode(task); err != nil {
+func (c *Client) Ping(ctx context.Context, addr string) (*PingResponse, error) {
+	var resp PingResponse
+	if err := c.Call(ctx, addr, MsgPing, struct{}{}, &resp); err != nil {
 		return nil, err
 	}
+	return &resp, nil
+}
 
-	dec := json.NewDecoder(bufio.NewReader(conn))
-	var resp RecResponse
-	if err := dec.Decode(&resp); err != nil {
+func (c *Client) Stats(ctx context.Context, addr string) (*NodeStats, error) {
+	var resp NodeStats
+	if err := c.Call(ctx, addr, MsgStats, struct{}{}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) Cancel(ctx context.Context, addr, targetID string) (bool, error) {
+	var resp CancelResponse
+	if err := c.Call(ctx, addr, MsgCancel, &CancelRequest{TargetID: targetID}, &resp); err != nil {
+		return false, err
+	}
+	return resp.Canceled, nil
+}
+
+func (c *Client) ReloadIndex(ctx context.Context, addr string) (*IndexStats, error) {
+	var resp IndexStats
+	if err := c.Call(ctx, addr, MsgReloadIndex, struct{}{}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) TrainMF(ctx context.Context, addr string, task *MFTrainTask) (*MFTrainResponse, error) {
+	var resp MFTrainResponse
+	if err := c.Call(ctx, addr, MsgTrainMF, task, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) LoadMF(ctx context.Context, addr, modelID string) (*MFLoadResponse, error) {
+	var resp MFLoadResponse
+	if err := c.Call(ctx, addr, MsgLoadMF, &MFLoadRequest{ModelID: modelID}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) UpdateNeighbors(ctx context.Context, addr string, upd *NeighborsUpdate) (*NeighborsUpdateResponse, error) {
+	var resp NeighborsUpdateResponse
+	if err := c.Call(ctx, addr, MsgUpdateNeighbors, upd, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) BuildANN(ctx context.Context, addr string, task *ANNBuildTask) (*ANNBuildResponse, error) {
+	var resp ANNBuildResponse
+	if err := c.Call(ctx, addr, MsgBuildANN, task, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
+
+// Call envía un mensaje tipado a un nodo y decodifica el payload de la
+// respuesta en resp. Si el nodo devuelve un error estructurado se
+// retorna como *RemoteError. Si ctx se cancela antes de la respuesta,
+// se avisa al nodo (best effort) para que aborte el trabajo.
+func (c *Client) Call(ctx context.Context, addr, msgType string, req, resp any) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	env := &Envelope{
+		Type:    msgType,
+		Version: ProtocolVersion,
+		ID:      nextRequestID(),
+		Payload: payload,
+	}
+
+	out, err := c.roundTrip(ctx, addr, env)
+	if err != nil {
+		if ctx.Err() != nil && msgType != MsgCancel {
+			go c.sendCancel(addr, env.ID)
+		}
+		return err
+	}
+
+	if out.Error != nil {
+		return &RemoteError{Code: out.Error.Code, Message: out.Error.Message}
+	}
+	if resp == nil || len(out.Payload) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(out.Payload, resp); err != nil {
+		return fmt.Errorf("respuesta %s inválida: %w", msgType, err)
+	}
+	return nil
+}
+
+func (c *Client) sendCancel(addr, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, _ = c.Cancel(ctx, addr, id)
+}
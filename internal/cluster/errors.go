@@ -0,0 +1,19 @@
+package cluster
+
+import "fmt"
+
+// RemoteError error devuelto por un nodo dentro del sobre de respuesta.
+type RemoteError struct {
+	Code    string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("nodo ML: %s: %s", e.Code, e.Message)
+}
+
+// NewError crea un error con código para que un handler del nodo
+// controle qué código viaja en la respuesta (por defecto es "internal").
+func NewError(code, format string, args ...any) error {
+	return &RemoteError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -1,26 +1,246 @@
 package cluster
 
-import "nodosml-pc4/internal/models"
+import (
+	"encoding/json"
+	"time"
+
+	"nodosml-pc4/internal/models"
+)
+
+// Versión del protocolo coordinador <-> nodos. Se sube cuando cambia el
+// formato del sobre; agregar tipos nuevos NO requiere subirla.
+const ProtocolVersion = 1
+
+// Tipos de mensaje que viajan en el sobre.
+const (
+	MsgRecommend         = "recommend"
+	MsgBuildSimilarities = "build-similarities"
+	MsgPing              = "ping"
+	MsgStats             = "stats"
+	MsgCancel            = "cancel"
+	MsgReloadIndex       = "reload-index"
+	MsgTrainMF           = "train-mf"
+	MsgLoadMF            = "load-mf"
+	MsgUpdateNeighbors   = "update-neighbors"
+	MsgBuildANN          = "build-ann"
+
+	// MsgError tipo de la respuesta a un mensaje sin sobre que falló.
+	MsgError = "error"
+)
+
+// Códigos de error estructurados que devuelve un nodo.
+const (
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeBadPayload         = "bad_payload"
+	ErrCodeCanceled           = "canceled"
+	ErrCodeInternal           = "internal"
+)
+
+// Envelope es el sobre de todo mensaje (petición o respuesta). La respuesta
+// reusa Type e ID de la petición; si algo falló, Error viene seteado.
+type Envelope struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   *ErrorPayload   `json:"error,omitempty"`
+}
+
+// ErrorPayload error estructurado devuelto por un nodo.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
 
 // Tarea enviada desde el coordinador (API) a cada nodo ML.
+//
+// Con Ring seteado, Ratings trae solo los ratings de las películas cuyo
+// iIdx pertenece al nodo dueño del shard (hashing consistente). Sin Ring
+// (coordinadores viejos) viajan todos y el nodo filtra por idx%Shards.
 type RecTask struct {
 	UserID  int                `json:"userId"`
 	K       int                `json:"k"`
 	ShardID int                `json:"shardId"` // id del shard (0..Shards-1)
 	Shards  int                `json:"shards"`  // total de shards/nodos
 	Ratings []models.RatingDoc `json:"ratings"`
+	Ring    []string           `json:"ring,omitempty"` // miembros del anillo usado para repartir
+
+	// Algoritmo (ml.Algo*, "" = item-knn). Con mf el nodo recibe todos los
+	// ratings del usuario y puntúa los ítems del shard con el modelo ModelID.
+	Algo    string `json:"algo,omitempty"`
+	ModelID string `json:"modelId,omitempty"`
+
+	// Modo de predicción (ml.Mode*, "" = weighted). Los datos del usuario
+	// los calcula el coordinador sobre todos sus ratings, no solo el shard.
+	Mode       string  `json:"mode,omitempty"`
+	UserMean   float64 `json:"userMean,omitempty"`   // mean-centered
+	UserBias   float64 `json:"userBias,omitempty"`   // baseline
+	GlobalMean float64 `json:"globalMean,omitempty"` // baseline
+
+	// Vecinos por ítem valorado que se usan (0 = DefaultRecNeighbors).
+	Neighbors int `json:"neighbors,omitempty"`
+	// Métrica de similitud de los vecinos (ml.Metric*; "" = cosine).
+	Metric string `json:"metric,omitempty"`
 }
 
+// DefaultRecNeighbors vecinos por ítem valorado si la tarea no indica otro.
+const DefaultRecNeighbors = 100
+
 // Parcial de score: no devolvemos score final, sino numerador y denominador
 // para que el coordinador combine correctamente entre shards.
 type PartialScore struct {
 	MovieID int     `json:"movieId"`
-	Num     float64 `json:"num"` // sum(sim * rating)
-	Den     float64 `json:"den"` // sum(sim)
+	Num     float64 `json:"num"`            // sum(sim * desvío) (weighted: sum(sim * rating))
+	Den     float64 `json:"den"`            // sum(sim)
+	Base    float64 `json:"base,omitempty"` // predicción base del ítem: score = base + num/den
 }
 
 // Respuesta de un nodo ML a la API.
 type RecResponse struct {
 	ShardID  int            `json:"shardId"`
 	Partials []PartialScore `json:"partials"`
+	// Misses películas valoradas sin vecinos precalculados (item-kNN): el
+	// coordinador las cubre con similitud por contenido.
+	Misses []int `json:"misses,omitempty"`
+}
+
+// Tarea de recálculo de similitudes item-item para un batch de iIdxs.
+type SimTask struct {
+	BatchID        int   `json:"batchId"`
+	IIdxs          []int `json:"iIdxs"`
+	K              int   `json:"k"`
+	MinCommonUsers int   `json:"minCommonUsers"`
+	Shrink         int   `json:"shrink"`
+	// métricas a calcular para cada película (vacío = solo cosine)
+	Metrics []string `json:"metrics,omitempty"`
+	// índice de vecinos aproximados para la métrica embedding
+	ANN *ANNSpec `json:"ann,omitempty"`
+}
+
+// Respuesta de un nodo ML a una SimTask.
+type SimResponse struct {
+	BatchID   int `json:"batchId"`
+	Processed int `json:"processed"` // películas del batch encontradas en movies
+	Upserted  int `json:"upserted"`  // documentos escritos en similarities (uno por película y métrica)
+}
+
+// PingResponse respuesta a un ping.
+type PingResponse struct {
+	NodeID string    `json:"nodeId"`
+	Time   time.Time `json:"time"`
+}
+
+// CancelRequest pide cancelar una petición en curso (por su ID de sobre).
+type CancelRequest struct {
+	TargetID string `json:"targetId"`
+}
+
+// CancelResponse indica si la petición seguía en curso.
+type CancelResponse struct {
+	Canceled bool `json:"canceled"`
+}
+
+// NodeStats estadísticas de un nodo ML.
+type NodeStats struct {
+	NodeID    string           `json:"nodeId"`
+	StartedAt time.Time        `json:"startedAt"`
+	UptimeSec int64            `json:"uptimeSec"`
+	InFlight  int              `json:"inFlight"`
+	Served    map[string]int64 `json:"served"` // por tipo de mensaje
+	Failed    map[string]int64 `json:"failed"` // por tipo de mensaje
+	Extra     map[string]any   `json:"extra,omitempty"`
+}
+
+// IndexStats estado del índice de vecinos en memoria de un nodo.
+type IndexStats struct {
+	Movies      int       `json:"movies"`      // películas con vecinos en memoria
+	Neighbors   int       `json:"neighbors"`   // total de vecinos guardados
+	ApproxBytes int64     `json:"approxBytes"` // memoria estimada del índice
+	LoadedAt    time.Time `json:"loadedAt,omitempty"`
+	LoadMs      int64     `json:"loadMs"`
+	Loading     bool      `json:"loading"`
+	Ring        []string  `json:"ring,omitempty"` // anillo con el que se cargó
+	Hits        int64     `json:"hits"`           // búsquedas resueltas en memoria
+	Misses      int64     `json:"misses"`         // búsquedas que fueron a Mongo
+	Patched     int       `json:"patched"`        // filas reemplazadas desde la última carga
+	Absent      int       `json:"absent"`         // películas sin vecinos en Mongo recordadas
+	// películas en memoria por métrica de similitud
+	Metrics map[string]int `json:"metrics,omitempty"`
+}
+
+// MFTrainTask pide a un nodo entrenar un modelo de factorización sobre
+// toda la colección ratings y guardarlo con ese ID.
+type MFTrainTask struct {
+	ModelID      string  `json:"modelId"`
+	Factors      int     `json:"factors"`
+	Epochs       int     `json:"epochs"`
+	LearningRate float64 `json:"learningRate"`
+	Reg          float64 `json:"reg"`
+	Seed         int64   `json:"seed,omitempty"`
+}
+
+type MFTrainResponse struct {
+	ModelID   string  `json:"modelId"`
+	Users     int     `json:"users"`
+	Items     int     `json:"items"`
+	Ratings   int     `json:"ratings"`
+	TrainRMSE float64 `json:"trainRmse"`
+	Seconds   float64 `json:"seconds"`
+}
+
+// MFLoadRequest pide a un nodo cargar en memoria los factores de ítem de
+// un modelo (para que la primera petición no pague la carga).
+type MFLoadRequest struct {
+	ModelID string `json:"modelId"`
+}
+
+type MFLoadResponse struct {
+	ModelID     string `json:"modelId"`
+	Items       int    `json:"items"`
+	ApproxBytes int64  `json:"approxBytes"`
+	LoadMs      int64  `json:"loadMs"`
+}
+
+// NeighborsUpdate vecinos recalculados de forma incremental. Cada nodo
+// reemplaza en memoria las filas que le tocan según el anillo.
+type NeighborsUpdate struct {
+	Docs []models.SimilarityDoc `json:"docs"`
+}
+
+type NeighborsUpdateResponse struct {
+	Applied int `json:"applied"` // filas que el nodo tomó
+}
+
+// ANNSpec índice de vecinos aproximados (HNSW) sobre vectores de ítems.
+// Con los mismos parámetros todos los nodos arman el mismo grafo.
+type ANNSpec struct {
+	Source         string `json:"source"`            // ml.Embedding*
+	ModelID        string `json:"modelId,omitempty"` // modelo MF (source=mf)
+	M              int    `json:"m"`
+	EfConstruction int    `json:"efConstruction"`
+	EfSearch       int    `json:"efSearch"`
+}
+
+// ANNBuildTask pide a un nodo tener listo el índice (de memoria, de disco
+// o armándolo) y medir su recall contra la búsqueda exacta.
+type ANNBuildTask struct {
+	ANNSpec
+	Rebuild      bool `json:"rebuild,omitempty"`      // ignora lo que haya en memoria y disco
+	RecallSample int  `json:"recallSample,omitempty"` // ítems de la muestra (0 = no medir)
+	RecallK      int  `json:"recallK,omitempty"`
+}
+
+type ANNBuildResponse struct {
+	ANNSpec
+	Items        int     `json:"items"`
+	Dim          int     `json:"dim"`
+	From         string  `json:"from"` // memory | disk | built
+	Path         string  `json:"path,omitempty"`
+	BuildMs      int64   `json:"buildMs"`
+	ApproxBytes  int64   `json:"approxBytes"`
+	Recall       float64 `json:"recall"` // recall@RecallK de la muestra
+	RecallK      int     `json:"recallK"`
+	RecallSample int     `json:"recallSample"`
+	RecallMs     int64   `json:"recallMs"`
 }
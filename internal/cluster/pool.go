@@ -0,0 +1,379 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errConnClosed = errors.New("conexión con nodo ML cerrada")
+
+// ClientOptions configura el pool de conexiones hacia los nodos.
+type ClientOptions struct {
+	ConnsPerNode int           // conexiones persistentes por nodo (default 2)
+	IdleTimeout  time.Duration // se cierran las conexiones sin uso (default 90s)
+	DialTimeout  time.Duration // timeout al abrir una conexión (default 3s)
+}
+
+func (o *ClientOptions) withDefaults() {
+	if o.ConnsPerNode <= 0 {
+		o.ConnsPerNode = 2
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = 90 * time.Second
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 3 * time.Second
+	}
+}
+
+// Client mantiene un pool de conexiones TCP largas por dirección de nodo.
+// Sobre cada conexión viajan muchas peticiones a la vez; las respuestas se
+// emparejan por el ID del sobre.
+type Client struct {
+	opts ClientOptions
+
+	mu     sync.Mutex
+	pools  map[string]*nodePool
+	closed bool
+	stop   chan struct{}
+}
+
+// NewClient crea un cliente y arranca el cierre de conexiones ociosas.
+func NewClient(opts ClientOptions) *Client {
+	opts.withDefaults()
+	c := &Client{
+		opts:  opts,
+		pools: make(map[string]*nodePool),
+		stop:  make(chan struct{}),
+	}
+	go c.reapIdle()
+	return c
+}
+
+// Close cierra todas las conexiones del cliente.
+func (c *Client) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	pools := c.pools
+	c.pools = map[string]*nodePool{}
+	c.mu.Unlock()
+
+	close(c.stop)
+	for _, p := range pools {
+		p.closeAll()
+	}
+}
+
+func (c *Client) pool(addr string) (*nodePool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errConnClosed
+	}
+	p, ok := c.pools[addr]
+	if !ok {
+		p = &nodePool{addr: addr, opts: c.opts}
+		c.pools[addr] = p
+	}
+	return p, nil
+}
+
+// roundTrip manda el sobre por una conexión del pool y espera la respuesta.
+// Si la conexión estaba rota se reintenta una vez con una nueva.
+func (c *Client) roundTrip(ctx context.Context, addr string, env *Envelope) (*Envelope, error) {
+	p, err := c.pool(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		mc, err := p.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		ch, err := mc.send(ctx, env)
+		if err != nil {
+			// no llegó a salir: conexión rota, probamos con otra
+			p.remove(mc)
+			lastErr = err
+			continue
+		}
+
+		select {
+		case out, ok := <-ch:
+			if !ok {
+				return nil, mc.failure()
+			}
+			return out, nil
+		case <-ctx.Done():
+			mc.forget(env.ID)
+			return nil, ctx.Err()
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *Client) reapIdle() {
+	t := time.NewTicker(c.opts.IdleTimeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+		}
+
+		c.mu.Lock()
+		pools := make([]*nodePool, 0, len(c.pools))
+		for _, p := range c.pools {
+			pools = append(pools, p)
+		}
+		c.mu.Unlock()
+
+		for _, p := range pools {
+			p.closeIdle()
+		}
+	}
+}
+
+// ---------------------- pool por nodo ----------------------
+
+type nodePool struct {
+	addr string
+	opts ClientOptions
+
+	mu      sync.Mutex
+	conns   []*muxConn
+	dialing int           // plazas reservadas con una conexión abriéndose
+	dialed  chan struct{} // se cierra cuando termina un dial (éxito o no)
+	next    int
+}
+
+// get devuelve una conexión viva; abre una nueva mientras no se llegue a
+// ConnsPerNode y si no reparte round-robin entre las existentes. La plaza
+// se reserva bajo el lock, así llamadas concurrentes no abren de más.
+func (p *nodePool) get(ctx context.Context) (*muxConn, error) {
+	for {
+		p.mu.Lock()
+		if len(p.conns)+p.dialing < p.opts.ConnsPerNode {
+			p.dialing++
+			p.mu.Unlock()
+			return p.dial(ctx)
+		}
+		if len(p.conns) > 0 {
+			mc := p.conns[p.next%len(p.conns)]
+			p.next++
+			p.mu.Unlock()
+			return mc, nil
+		}
+
+		// todas las plazas se están abriendo: esperamos a que termine alguna
+		if p.dialed == nil {
+			p.dialed = make(chan struct{})
+		}
+		wait := p.dialed
+		p.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// dial abre la conexión de una plaza ya reservada en get.
+func (p *nodePool) dial(ctx context.Context) (*muxConn, error) {
+	d := net.Dialer{Timeout: p.opts.DialTimeout, KeepAlive: 30 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing--
+	if p.dialed != nil {
+		close(p.dialed)
+		p.dialed = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	mc := newMuxConn(conn, p.remove)
+	p.conns = append(p.conns, mc)
+	return mc, nil
+}
+
+func (p *nodePool) remove(mc *muxConn) {
+	p.mu.Lock()
+	for i, c := range p.conns {
+		if c == mc {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+	mc.close(errConnClosed)
+}
+
+func (p *nodePool) closeIdle() {
+	p.mu.Lock()
+	var idle []*muxConn
+	keep := p.conns[:0]
+	for _, c := range p.conns {
+		if c.idleFor() > p.opts.IdleTimeout {
+			idle = append(idle, c)
+		} else {
+			keep = append(keep, c)
+		}
+	}
+	p.conns = keep
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		c.close(errConnClosed)
+	}
+}
+
+func (p *nodePool) closeAll() {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.mu.Unlock()
+	for _, c := range conns {
+		c.close(errConnClosed)
+	}
+}
+
+// ---------------------- conexión multiplexada ----------------------
+
+type muxConn struct {
+	conn     net.Conn
+	wmu      sync.Mutex
+	enc      *json.Encoder
+	onBroken func(*muxConn)
+
+	mu      sync.Mutex
+	pending map[string]chan *Envelope
+	closed  bool
+	err     error
+
+	lastUsed int64 // unix nano
+}
+
+func newMuxConn(conn net.Conn, onBroken func(*muxConn)) *muxConn {
+	mc := &muxConn{
+		conn:     conn,
+		enc:      json.NewEncoder(conn),
+		onBroken: onBroken,
+		pending:  make(map[string]chan *Envelope),
+		lastUsed: time.Now().UnixNano(),
+	}
+	go mc.readLoop()
+	return mc
+}
+
+func (mc *muxConn) send(ctx context.Context, env *Envelope) (<-chan *Envelope, error) {
+	ch := make(chan *Envelope, 1)
+
+	mc.mu.Lock()
+	if mc.closed {
+		mc.mu.Unlock()
+		return nil, mc.err
+	}
+	mc.pending[env.ID] = ch
+	mc.mu.Unlock()
+	atomic.StoreInt64(&mc.lastUsed, time.Now().UnixNano())
+
+	mc.wmu.Lock()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	_ = mc.conn.SetWriteDeadline(deadline)
+	err := mc.enc.Encode(env)
+	mc.wmu.Unlock()
+
+	if err != nil {
+		mc.forget(env.ID)
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (mc *muxConn) readLoop() {
+	dec := json.NewDecoder(bufio.NewReader(mc.conn))
+	for {
+		var env Envelope
+		if err := dec.Decode(&env); err != nil {
+			mc.close(err)
+			mc.onBroken(mc)
+			return
+		}
+		atomic.StoreInt64(&mc.lastUsed, time.Now().UnixNano())
+
+		mc.mu.Lock()
+		ch, ok := mc.pending[env.ID]
+		delete(mc.pending, env.ID)
+		mc.mu.Unlock()
+		if ok {
+			ch <- &env
+		}
+	}
+}
+
+// forget descarta una petición cuya respuesta ya no interesa.
+func (mc *muxConn) forget(id string) {
+	mc.mu.Lock()
+	delete(mc.pending, id)
+	mc.mu.Unlock()
+}
+
+// idleFor cuánto lleva la conexión sin peticiones pendientes.
+func (mc *muxConn) idleFor() time.Duration {
+	mc.mu.Lock()
+	busy := len(mc.pending) > 0
+	mc.mu.Unlock()
+	if busy {
+		return 0
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(&mc.lastUsed)))
+}
+
+func (mc *muxConn) failure() error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.err != nil {
+		return mc.err
+	}
+	return errConnClosed
+}
+
+// close cierra la conexión y despierta a todas las peticiones pendientes.
+func (mc *muxConn) close(err error) {
+	mc.mu.Lock()
+	if mc.closed {
+		mc.mu.Unlock()
+		return
+	}
+	mc.closed = true
+	mc.err = err
+	pending := mc.pending
+	mc.pending = nil
+	mc.mu.Unlock()
+
+	_ = mc.conn.Close()
+	for _, ch := range pending {
+		close(ch)
+	}
+}
@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const msgEcho = "echo"
+
+type echoMsg struct {
+	N int `json:"n"`
+}
+
+// countingListener cuenta las conexiones aceptadas por el servidor.
+type countingListener struct {
+	net.Listener
+	accepted int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt64(&l.accepted, 1)
+	}
+	return c, err
+}
+
+// startServer levanta un nodo en proceso con un handler echo que responde
+// con demora inversa al número recibido, así las respuestas salen en otro
+// orden que las peticiones.
+func startServer(t *testing.T) (string, *countingListener) {
+	t.Helper()
+	srv := NewServer("test")
+	srv.Handle(msgEcho, func(ctx context.Context, payload json.RawMessage) (any, error) {
+		var m echoMsg
+		if err := Decode(payload, &m); err != nil {
+			return nil, err
+		}
+		time.Sleep(time.Duration(50-m.N%50) * 100 * time.Microsecond)
+		return &m, nil
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := &countingListener{Listener: ln}
+	go srv.Serve(cl)
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String(), cl
+}
+
+func TestClientConcurrentRequestIDs(t *testing.T) {
+	addr, _ := startServer(t)
+	c := NewClient(ClientOptions{ConnsPerNode: 2})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const n = 200
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var out echoMsg
+			if err := c.Call(ctx, addr, msgEcho, &echoMsg{N: i}, &out); err != nil {
+				errs <- err
+				return
+			}
+			if out.N != i {
+				t.Errorf("petición %d recibió la respuesta de %d", i, out.N)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestClientReusesConnections(t *testing.T) {
+	addr, ln := startServer(t)
+	c := NewClient(ClientOptions{ConnsPerNode: 2})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// ráfaga concurrente sobre un pool vacío: no puede abrir de más
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := c.Call(ctx, addr, msgEcho, &echoMsg{N: i}, nil); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// y las siguientes peticiones reusan las mismas conexiones
+	for i := 0; i < 20; i++ {
+		if _, err := c.Ping(ctx, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := atomic.LoadInt64(&ln.accepted); got > 2 {
+		t.Fatalf("conexiones abiertas = %d, want <= 2", got)
+	}
+	p, _ := c.pool(addr)
+	p.mu.Lock()
+	conns, dialing := len(p.conns), p.dialing
+	p.mu.Unlock()
+	if conns > 2 || dialing != 0 {
+		t.Fatalf("pool con %d conexiones y %d abriéndose", conns, dialing)
+	}
+}
+
+func TestClientReconnectsAfterServerClose(t *testing.T) {
+	addr, ln := startServer(t)
+	c := NewClient(ClientOptions{ConnsPerNode: 1})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := c.Ping(ctx, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	// el nodo corta la conexión: el pool la descarta y abre otra
+	p, _ := c.pool(addr)
+	p.mu.Lock()
+	mc := p.conns[0]
+	p.mu.Unlock()
+	mc.conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p.mu.Lock()
+		gone := len(p.conns) == 0
+		p.mu.Unlock()
+		if gone || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := c.Ping(ctx, addr); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt64(&ln.accepted); got != 2 {
+		t.Fatalf("conexiones aceptadas = %d, want 2", got)
+	}
+}
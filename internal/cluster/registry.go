@@ -0,0 +1,240 @@
+package cluster
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// NodeStatus estado conocido de un nodo ML.
+type NodeStatus struct {
+	Addr         string    `json:"addr"`
+	NodeID       string    `json:"nodeId,omitempty"`
+	Up           bool      `json:"up"`
+	Static       bool      `json:"static"` // viene de ML_NODE_ADDRS (no se puede dar de baja)
+	LatencyMs    float64   `json:"latencyMs"`
+	Failures     int       `json:"consecutiveFailures"`
+	LastError    string    `json:"lastError,omitempty"`
+	LastCheck    time.Time `json:"lastCheck"`
+	LastSeen     time.Time `json:"lastSeen,omitempty"`
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// RegistryOptions configura los health checks.
+type RegistryOptions struct {
+	Interval time.Duration // cada cuánto se hace ping (default 5s)
+	Timeout  time.Duration // timeout de cada ping (default 2s)
+}
+
+// Registry lleva la lista viva de nodos ML: hace ping periódico a cada uno
+// y permite que los nodos se registren / den de baja en runtime.
+type Registry struct {
+	client *Client
+	opts   RegistryOptions
+
+	mu    sync.RWMutex
+	nodes map[string]*NodeStatus
+	order []string // orden de alta, para que el reparto de shards sea estable
+}
+
+// NewRegistry crea el registro con los nodos estáticos de la configuración.
+func NewRegistry(client *Client, static []string, opts RegistryOptions) *Registry {
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+
+	r := &Registry{
+		client: client,
+		opts:   opts,
+		nodes:  make(map[string]*NodeStatus),
+	}
+	now := time.Now()
+	for _, addr := range static {
+		if _, ok := r.nodes[addr]; ok {
+			continue
+		}
+		r.nodes[addr] = &NodeStatus{Addr: addr, Static: true, RegisteredAt: now}
+		r.order = append(r.order, addr)
+	}
+	return r
+}
+
+// Start hace un primer chequeo (bloqueante) y luego chequea en segundo
+// plano hasta que ctx se cancele.
+func (r *Registry) Start(ctx context.Context) {
+	r.checkAll(ctx)
+
+	go func() {
+		t := time.NewTicker(r.opts.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				r.checkAll(ctx)
+			}
+		}
+	}()
+}
+
+// Register da de alta (o refresca) un nodo y lo chequea de inmediato.
+func (r *Registry) Register(ctx context.Context, addr string) NodeStatus {
+	r.mu.Lock()
+	if _, ok := r.nodes[addr]; !ok {
+		r.nodes[addr] = &NodeStatus{Addr: addr, RegisteredAt: time.Now()}
+		r.order = append(r.order, addr)
+		log.Printf("[cluster] nodo registrado: %s", addr)
+	}
+	r.mu.Unlock()
+
+	r.check(ctx, addr)
+
+	st, _ := r.Get(addr)
+	return st
+}
+
+// Deregister da de baja un nodo registrado en runtime. Los nodos estáticos
+// no se eliminan: solo se marcan caídos hasta el próximo ping.
+func (r *Registry) Deregister(addr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[addr]
+	if !ok {
+		return false
+	}
+	if n.Static {
+		n.Up = false
+		n.LastError = "dado de baja por el nodo"
+		return true
+	}
+
+	delete(r.nodes, addr)
+	for i, a := range r.order {
+		if a == addr {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	log.Printf("[cluster] nodo dado de baja: %s", addr)
+	return true
+}
+
+// Healthy devuelve las direcciones de los nodos que respondieron el último ping.
+func (r *Registry) Healthy() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]string, 0, len(r.order))
+	for _, addr := range r.order {
+		if r.nodes[addr].Up {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
+// Members devuelve todos los nodos registrados (sanos o no). Es la base del
+// anillo de hashing: así una caída temporal no reparte de nuevo los ítems.
+func (r *Registry) Members() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.order...)
+}
+
+// All devuelve una copia del estado de todos los nodos.
+func (r *Registry) All() []NodeStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]NodeStatus, 0, len(r.order))
+	for _, addr := range r.order {
+		out = append(out, *r.nodes[addr])
+	}
+	return out
+}
+
+// Get devuelve el estado de un nodo.
+func (r *Registry) Get(addr string) (NodeStatus, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n, ok := r.nodes[addr]
+	if !ok {
+		return NodeStatus{}, false
+	}
+	return *n, true
+}
+
+// MarkDown marca un nodo como caído tras un error al despacharle trabajo;
+// vuelve a estar disponible cuando responda el siguiente ping.
+func (r *Registry) MarkDown(addr string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n, ok := r.nodes[addr]; ok {
+		n.Up = false
+		n.Failures++
+		if err != nil {
+			n.LastError = err.Error()
+		}
+	}
+}
+
+func (r *Registry) checkAll(ctx context.Context) {
+	r.mu.RLock()
+	addrs := append([]string(nil), r.order...)
+	r.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			r.check(ctx, addr)
+		}(addr)
+	}
+	wg.Wait()
+}
+
+func (r *Registry) check(ctx context.Context, addr string) {
+	pingCtx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	pong, err := r.client.Ping(pingCtx, addr)
+	latency := time.Since(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[addr]
+	if !ok {
+		return // lo dieron de baja mientras hacíamos ping
+	}
+	wasUp := n.Up
+	n.LastCheck = time.Now()
+
+	if err != nil {
+		n.Up = false
+		n.Failures++
+		n.LastError = err.Error()
+		if wasUp {
+			log.Printf("[cluster] nodo %s CAÍDO: %v", addr, err)
+		}
+		return
+	}
+
+	n.Up = true
+	n.Failures = 0
+	n.LastError = ""
+	n.NodeID = pong.NodeID
+	n.LatencyMs = float64(latency.Microseconds()) / 1000
+	n.LastSeen = n.LastCheck
+	if !wasUp {
+		log.Printf("[cluster] nodo %s (id=%s) disponible, latencia=%s", addr, pong.NodeID, latency)
+	}
+}
@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultVNodes nodos virtuales por nodo real en el anillo. Coordinador y
+// nodos deben usar el mismo valor para calcular el mismo reparto.
+const DefaultVNodes = 64
+
+// Ring anillo de hashing consistente sobre los iIdx de las películas.
+// Al agregar o quitar un nodo solo se mueven ~1/N de los ítems.
+type Ring struct {
+	members []string
+	hashes  []uint32
+	owners  map[uint32]string
+}
+
+// NewRing arma el anillo. El resultado no depende del orden de members.
+func NewRing(members []string, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVNodes
+	}
+
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+
+	r := &Ring{
+		members: sorted,
+		owners:  make(map[uint32]string, len(sorted)*vnodes),
+	}
+	for _, m := range sorted {
+		for v := 0; v < vnodes; v++ {
+			h := hashKey(m + "#" + strconv.Itoa(v))
+			if _, taken := r.owners[h]; taken {
+				continue // colisión: gana el primero (orden estable)
+			}
+			r.owners[h] = m
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Owner devuelve el nodo dueño de un iIdx ("" si el anillo está vacío).
+func (r *Ring) Owner(iIdx int) string {
+	return r.OwnerOf("item:" + strconv.Itoa(iIdx))
+}
+
+// OwnerOf devuelve el nodo dueño de una clave cualquiera (p.e. el ID de un
+// modelo), con el mismo reparto que los ítems.
+func (r *Ring) OwnerOf(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Members devuelve los nodos del anillo (ordenados).
+func (r *Ring) Members() []string {
+	return append([]string(nil), r.members...)
+}
+
+// Signature identifica el anillo; sirve para saber si cambió.
+func (r *Ring) Signature() string {
+	return strings.Join(r.members, ",")
+}
+
+// hashKey FNV-1a de 64 bits + el mezclador final de murmur3: FNV solo
+// reparte muy mal claves casi iguales ("mlnode1#0", "mlnode1#1", ...).
+func hashKey(s string) uint32 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return uint32(x >> 32)
+}
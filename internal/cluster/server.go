@@ -0,0 +1,328 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tiempo máximo que una conexión puede estar sin mandar mensajes
+const serverIdleTimeout = 10 * time.Minute
+
+// HandlerFunc atiende el payload de un tipo de mensaje. Lo que devuelve se
+// serializa como payload de la respuesta.
+type HandlerFunc func(ctx context.Context, payload json.RawMessage) (any, error)
+
+// Server despacha los mensajes que llegan a un nodo ML según su tipo.
+// Ping, stats y cancel vienen incluidos.
+type Server struct {
+	nodeID    string
+	startedAt time.Time
+
+	handlers map[string]HandlerFunc
+	legacy   HandlerFunc
+	extra    func() map[string]any
+
+	mu       sync.Mutex
+	inflight map[string]context.CancelFunc
+	served   map[string]int64
+	failed   map[string]int64
+}
+
+// NewServer crea el servidor de un nodo.
+func NewServer(nodeID string) *Server {
+	s := &Server{
+		nodeID:    nodeID,
+		startedAt: time.Now(),
+		handlers:  make(map[string]HandlerFunc),
+		inflight:  make(map[string]context.CancelFunc),
+		served:    make(map[string]int64),
+		failed:    make(map[string]int64),
+	}
+	s.Handle(MsgPing, s.handlePing)
+	s.Handle(MsgStats, s.handleStats)
+	s.Handle(MsgCancel, s.handleCancel)
+	return s
+}
+
+// Handle registra el handler de un tipo de mensaje.
+func (s *Server) Handle(msgType string, fn HandlerFunc) {
+	s.handlers[msgType] = fn
+}
+
+// HandleLegacy registra el handler para mensajes sin sobre (coordinadores
+// viejos que mandan un RecTask pelado y esperan un RecResponse pelado).
+func (s *Server) HandleLegacy(fn HandlerFunc) {
+	s.legacy = fn
+}
+
+// SetStatsExtra permite al nodo agregar datos propios a las stats.
+func (s *Server) SetStatsExtra(fn func() map[string]any) {
+	s.extra = fn
+}
+
+// Serve acepta conexiones hasta que el listener se cierre.
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Println("accept error:", err)
+			continue
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn atiende una conexión larga: lee sobres en bucle y procesa
+// cada uno en su goroutine, así el coordinador puede multiplexar muchas
+// peticiones por la misma conexión. Las respuestas pueden salir en otro
+// orden; el cliente las empareja por ID.
+func (s *Server) handleConn(conn net.Conn) {
+	// al cortarse la conexión se cancela lo que quede en curso
+	connCtx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer conn.Close()
+	defer wg.Wait()
+	defer cancel()
+
+	var (
+		active   int64 // peticiones en curso en esta conexión
+		lastSeen int64 // unix nano del último mensaje leído/escrito
+	)
+	touch := func() { atomic.StoreInt64(&lastSeen, time.Now().UnixNano()) }
+	touch()
+
+	// una conexión ociosa demasiado tiempo se cierra; el cliente reconecta
+	go func() {
+		t := time.NewTicker(time.Minute)
+		defer t.Stop()
+		for {
+			select {
+			case <-connCtx.Done():
+				return
+			case <-t.C:
+				idle := time.Since(time.Unix(0, atomic.LoadInt64(&lastSeen)))
+				if atomic.LoadInt64(&active) == 0 && idle > serverIdleTimeout {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	var wmu sync.Mutex
+	reply := func(v any) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		_ = conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		if err := json.NewEncoder(conn).Encode(v); err != nil {
+			log.Printf("[ML NODE %s] encode resp error: %v", s.nodeID, err)
+		}
+		touch()
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for first := true; ; first = false {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if first && !errors.Is(err, io.EOF) {
+				log.Printf("[ML NODE %s] decode mensaje error: %v", s.nodeID, err)
+			}
+			return
+		}
+		touch()
+
+		var env Envelope
+		if err := json.Unmarshal(raw, &env); err != nil {
+			// se rescata el id para que el cliente empareje el error; sin id
+			// no hay a quién responderle y se corta la conexión, así las
+			// llamadas pendientes fallan en vez de esperar su deadline
+			var probe struct {
+				Type string `json:"type"`
+				ID   string `json:"id"`
+			}
+			if json.Unmarshal(raw, &probe) != nil || probe.ID == "" {
+				log.Printf("[ML NODE %s] sobre inválido sin id: %v", s.nodeID, err)
+				return
+			}
+			reply(&Envelope{
+				Type:    probe.Type,
+				Version: ProtocolVersion,
+				ID:      probe.ID,
+				Error:   &ErrorPayload{Code: ErrCodeBadPayload, Message: err.Error()},
+			})
+			continue
+		}
+
+		// sin "type" en el primer mensaje: formato original sin sobre,
+		// una sola tarea por conexión
+		if env.Type == "" && first && s.legacy != nil {
+			resp, err := s.legacy(connCtx, raw)
+			if err != nil {
+				// sin sobre de entrada igual se responde el error con código,
+				// en vez de cortar la conexión en silencio
+				reply(&Envelope{Type: MsgError, Version: ProtocolVersion, Error: toErrorPayload(err)})
+				return
+			}
+			reply(resp)
+			return
+		}
+
+		wg.Add(1)
+		atomic.AddInt64(&active, 1)
+		go func(env Envelope) {
+			defer wg.Done()
+			defer atomic.AddInt64(&active, -1)
+			reply(s.dispatch(connCtx, &env))
+		}(env)
+	}
+}
+
+// dispatch ejecuta el handler del tipo y arma el sobre de respuesta.
+func (s *Server) dispatch(parent context.Context, env *Envelope) *Envelope {
+	out := &Envelope{Type: env.Type, Version: ProtocolVersion, ID: env.ID}
+
+	if env.Version > ProtocolVersion {
+		out.Error = &ErrorPayload{
+			Code:    ErrCodeUnsupportedVersion,
+			Message: fmt.Sprintf("versión %d no soportada (máx %d)", env.Version, ProtocolVersion),
+		}
+		return out
+	}
+
+	fn, ok := s.handlers[env.Type]
+	if !ok {
+		out.Error = &ErrorPayload{
+			Code:    ErrCodeUnknownType,
+			Message: fmt.Sprintf("tipo de mensaje desconocido: %q", env.Type),
+		}
+		s.count(env.Type, false)
+		return out
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	if env.ID != "" && cancelable(env.Type) {
+		s.mu.Lock()
+		s.inflight[env.ID] = cancel
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			delete(s.inflight, env.ID)
+			s.mu.Unlock()
+		}()
+	}
+
+	resp, err := fn(ctx, env.Payload)
+	if err != nil {
+		out.Error = toErrorPayload(err)
+		s.count(env.Type, false)
+		return out
+	}
+
+	payload, err := json.Marshal(resp)
+	if err != nil {
+		out.Error = &ErrorPayload{Code: ErrCodeInternal, Message: err.Error()}
+		s.count(env.Type, false)
+		return out
+	}
+	out.Payload = payload
+	s.count(env.Type, true)
+	return out
+}
+
+// cancelable indica si vale la pena registrar la petición para cancel
+// (los mensajes de control responden al instante).
+func cancelable(msgType string) bool {
+	switch msgType {
+	case MsgPing, MsgStats, MsgCancel:
+		return false
+	}
+	return true
+}
+
+func (s *Server) count(msgType string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ok {
+		s.served[msgType]++
+	} else {
+		s.failed[msgType]++
+	}
+}
+
+func toErrorPayload(err error) *ErrorPayload {
+	var re *RemoteError
+	switch {
+	case errors.As(err, &re):
+		return &ErrorPayload{Code: re.Code, Message: re.Message}
+	case errors.Is(err, context.Canceled):
+		return &ErrorPayload{Code: ErrCodeCanceled, Message: err.Error()}
+	default:
+		return &ErrorPayload{Code: ErrCodeInternal, Message: err.Error()}
+	}
+}
+
+// ---------------------- handlers incluidos ----------------------
+
+func (s *Server) handlePing(ctx context.Context, _ json.RawMessage) (any, error) {
+	return &PingResponse{NodeID: s.nodeID, Time: time.Now()}, nil
+}
+
+func (s *Server) handleStats(ctx context.Context, _ json.RawMessage) (any, error) {
+	s.mu.Lock()
+	st := &NodeStats{
+		NodeID:    s.nodeID,
+		StartedAt: s.startedAt,
+		UptimeSec: int64(time.Since(s.startedAt).Seconds()),
+		InFlight:  len(s.inflight),
+		Served:    make(map[string]int64, len(s.served)),
+		Failed:    make(map[string]int64, len(s.failed)),
+	}
+	for k, v := range s.served {
+		st.Served[k] = v
+	}
+	for k, v := range s.failed {
+		st.Failed[k] = v
+	}
+	s.mu.Unlock()
+
+	if s.extra != nil {
+		st.Extra = s.extra()
+	}
+	return st, nil
+}
+
+func (s *Server) handleCancel(ctx context.Context, payload json.RawMessage) (any, error) {
+	var req CancelRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, NewError(ErrCodeBadPayload, "%v", err)
+	}
+
+	s.mu.Lock()
+	cancel, ok := s.inflight[req.TargetID]
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return &CancelResponse{Canceled: ok}, nil
+}
+
+// Decode helper para handlers: decodifica el payload o devuelve bad_payload.
+func Decode(payload json.RawMessage, v any) error {
+	if err := json.Unmarshal(payload, v); err != nil {
+		return NewError(ErrCodeBadPayload, "%v", err)
+	}
+	return nil
+}
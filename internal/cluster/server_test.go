@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// rawConn conexión sin cliente para mandar sobres a mano.
+func rawConn(t *testing.T, addr string) (net.Conn, *json.Decoder) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, json.NewDecoder(bufio.NewReader(conn))
+}
+
+func TestServerBadEnvelopeKeepsID(t *testing.T) {
+	addr, _ := startServer(t)
+	conn, dec := rawConn(t, addr)
+
+	// version con tipo equivocado: el sobre no decodifica pero el id sí
+	if _, err := io.WriteString(conn, `{"type":"echo","version":"1","id":"abc"}`+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	var resp Envelope
+	if err := dec.Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != "abc" || resp.Error == nil || resp.Error.Code != ErrCodeBadPayload {
+		t.Fatalf("respuesta = %+v, want id abc con bad_payload", resp)
+	}
+
+	// la conexión sigue sirviendo
+	if _, err := io.WriteString(conn, `{"type":"echo","version":1,"id":"def","payload":{"n":49}}`+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	var ok Envelope
+	if err := dec.Decode(&ok); err != nil {
+		t.Fatal(err)
+	}
+	if ok.ID != "def" || ok.Error != nil {
+		t.Fatalf("respuesta = %+v, want id def sin error", ok)
+	}
+}
+
+func TestServerBadEnvelopeWithoutIDCloses(t *testing.T) {
+	addr, _ := startServer(t)
+	conn, dec := rawConn(t, addr)
+
+	if _, err := io.WriteString(conn, `{"type":"echo","version":1}`+"\n"+`{"id":5}`+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	// primero la respuesta al sobre válido, después el corte
+	var resp Envelope
+	if err := dec.Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&resp); !errors.Is(err, io.EOF) {
+		t.Fatalf("err = %v, want EOF", err)
+	}
+}
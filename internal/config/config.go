@@ -15,6 +15,12 @@ type Config struct {
 	JWTSecret  string
 	HTTPPort   string
 	TMDBAPIKey string
+	// token compartido con los nodos ML para registrarse en el coordinador
+	// (vacío = sin auto-registro)
+	ClusterToken string
+	// rating equivalente de los eventos implícitos, p.e. "view:3,click:3.5,watchlist:4"
+	// (vacío = ml.DefaultImplicitWeights)
+	ImplicitWeights string
 }
 
 func Load() *Config {
@@ -28,6 +34,10 @@ func Load() *Config {
 		JWTSecret:  getEnv("JWT_SECRET", "super-secret"),
 		HTTPPort:   getEnv("HTTP_PORT", "8080"),
 		TMDBAPIKey: getEnv("TMDB_API_KEY", "5f947eefe9278165015da465d0af58c3"),
+
+		ClusterToken: getEnv("CLUSTER_TOKEN", ""),
+
+		ImplicitWeights: getEnv("IMPLICIT_WEIGHTS", ""),
 	}
 }
 
@@ -35,3 +35,10 @@ func InitMongo(cfg *config.Config) {
 func DB() *mongo.Database {
 	return mongoDB
 }
+
+// UseDatabase fija la base sin conectar ni hacer ping (tests que arman el
+// cliente por su cuenta).
+func UseDatabase(d *mongo.Database) {
+	mongoClient = d.Client()
+	mongoDB = d
+}
@@ -2,9 +2,11 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
+	"nodosml-pc4/internal/ml"
 	"nodosml-pc4/internal/models"
 	"nodosml-pc4/internal/service"
 
@@ -27,7 +29,9 @@ func NewAdminMaintenanceHandler(svc *service.AdminMaintenanceService) *AdminMain
 // @Security BearerAuth
 // @Produce json
 // @Param minRatings query int false "Mínimo de ratings para considerar una película (default 5)"
+// @Param metric query string false "Métrica de similitud: cosine (default) | adjusted-cosine | pearson | jaccard | embedding"
 // @Success 200 {object} models.AdminSimilaritySummary
+// @Failure 400 {string} string "métrica inválida"
 // @Failure 500 {string} string "error interno"
 // @Router /admin/maintenance/similarities/summary [get]
 // GET /admin/maintenance/similarities/summary
@@ -39,7 +43,13 @@ func (h *AdminMaintenanceHandler) GetSummary(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	summary, err := h.svc.GetSimilaritySummary(r.Context(), minRatings)
+	metric, err := ml.ParseMetric(r.URL.Query().Get("metric"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	summary, err := h.svc.GetSimilaritySummary(r.Context(), minRatings, metric)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -56,7 +66,9 @@ func (h *AdminMaintenanceHandler) GetSummary(w http.ResponseWriter, r *http.Requ
 // @Param minRatings query int false "Mínimo de ratings para considerar una película (default 5)"
 // @Param limitWithoutIdx query int false "Límite de películas sin iIdx (default 50)"
 // @Param limitWithoutSims query int false "Límite de películas sin similitudes (default 50)"
+// @Param metric query string false "Métrica de similitud: cosine (default) | adjusted-cosine | pearson | jaccard | embedding"
 // @Success 200 {object} models.AdminPendingSimilarities
+// @Failure 400 {string} string "métrica inválida"
 // @Failure 500 {string} string "error interno"
 // @Router /admin/maintenance/similarities/pending [get]
 // GET /admin/maintenance/similarities/pending
@@ -80,7 +92,13 @@ func (h *AdminMaintenanceHandler) GetPending(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	resp, err := h.svc.GetPendingSimilarities(r.Context(), minRatings, limitWithoutIdx, limitWithoutSims)
+	metric, err := ml.ParseMetric(r.URL.Query().Get("metric"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.svc.GetPendingSimilarities(r.Context(), minRatings, limitWithoutIdx, limitWithoutSims, metric)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -122,14 +140,14 @@ func (h *AdminMaintenanceHandler) PostRemapMissing(w http.ResponseWriter, r *htt
 }
 
 // @Summary Recalcular similitudes pendientes
-// @Description Lanza el recálculo de similitudes en batches contra los nodos ML para películas sin entry en similarities.
+// @Description Lanza el recálculo de similitudes en batches contra los nodos ML para películas sin entry en similarities en alguna de las métricas pedidas.
 // @Tags admin-maintenance
 // @Security BearerAuth
 // @Accept json
 // @Produce json
 // @Param body body models.RebuildSimilaritiesRequest true "Parámetros de reconstrucción"
 // @Success 200 {object} models.RebuildSimilaritiesResult
-// @Failure 400 {string} string "body inválido"
+// @Failure 400 {string} string "body inválido o métrica desconocida"
 // @Failure 500 {string} string "error interno"
 // @Router /admin/maintenance/similarities/rebuild [post]
 // POST /admin/maintenance/similarities/rebuild
@@ -157,15 +175,115 @@ func (h *AdminMaintenanceHandler) PostRebuild(w http.ResponseWriter, r *http.Req
 	if req.Shrink < 0 {
 		req.Shrink = 20
 	}
+	metrics, err := parseMetrics(req.Metrics)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Metrics = metrics
+	if req.ANN != nil {
+		if _, err := ml.ParseEmbeddingSource(req.ANN.Source); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	res, err := h.svc.RebuildSimilarities(r.Context(), &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeANNError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// @Summary Armar el índice ANN de ítems
+// @Description Pide a todos los nodos ML sanos tener listo el índice HNSW sobre los vectores de ítems (factores del modelo MF o tags del genome): lo cargan de memoria o disco, o lo arman. Devuelve por nodo el origen, el tamaño y el recall@k medido contra la búsqueda exacta.
+// @Tags admin-maintenance
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body models.ANNIndexRequest false "Fuente de vectores y parámetros del grafo (ceros = default)"
+// @Success 200 {array} service.ANNBuildResult
+// @Failure 400 {string} string "body inválido o fuente desconocida"
+// @Failure 404 {string} string "modelo no encontrado"
+// @Failure 409 {string} string "no hay modelo MF terminado"
+// @Failure 503 {string} string "no hay nodos ML disponibles"
+// @Router /admin/maintenance/similarities/ann [post]
+// POST /admin/maintenance/similarities/ann
+func (h *AdminMaintenanceHandler) PostBuildANN(w http.ResponseWriter, r *http.Request) {
+	var req models.ANNIndexRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "body inválido", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := ml.ParseEmbeddingSource(req.Source); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.svc.BuildANN(r.Context(), &req)
+	if err != nil {
+		writeANNError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, res)
 }
 
+// writeANNError traduce los errores de resolver el índice ANN.
+func writeANNError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrMFModelNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrNoMFModel), errors.Is(err, service.ErrMFModelNotReady):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrNoHealthyNodes):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Estado de la actualización incremental de similitudes
+// @Description Cambios de ratings en cola, aplicados, películas sembradas y listas de vecinos reescritas desde que arrancó la API.
+// @Tags admin-maintenance
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.IncrementalSimilarityStatus
+// @Failure 500 {string} string "error interno"
+// @Router /admin/maintenance/similarities/incremental [get]
+// GET /admin/maintenance/similarities/incremental
+func (h *AdminMaintenanceHandler) GetIncremental(w http.ResponseWriter, r *http.Request) {
+	st, err := h.svc.IncrementalStatus(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// parseMetrics valida y deduplica las métricas del rebuild (vacío = solo la
+// métrica por defecto).
+func parseMetrics(in []string) ([]string, error) {
+	if len(in) == 0 {
+		return []string{ml.DefaultMetric}, nil
+	}
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, m := range in {
+		metric, err := ml.ParseMetric(m)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[metric] {
+			seen[metric] = true
+			out = append(out, metric)
+		}
+	}
+	return out, nil
+}
+
 // Utilidad pequeña para respuestas JSON.
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -180,5 +298,7 @@ func MountAdminMaintenanceRoutes(r chi.Router, h *AdminMaintenanceHandler) {
 		r.Get("/similarities/pending", h.GetPending)
 		r.Post("/similarities/remap-missing", h.PostRemapMissing)
 		r.Post("/similarities/rebuild", h.PostRebuild)
+		r.Post("/similarities/ann", h.PostBuildANN)
+		r.Get("/similarities/incremental", h.GetIncremental)
 	})
 }
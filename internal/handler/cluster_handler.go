@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"nodosml-pc4/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ClusterHandler expone el registro de nodos ML.
+type ClusterHandler struct {
+	svc *service.ClusterService
+}
+
+// NewClusterHandler crea el handler.
+func NewClusterHandler(svc *service.ClusterService) *ClusterHandler {
+	return &ClusterHandler{svc: svc}
+}
+
+// body de alta / baja de un nodo
+type nodeRegistrationRequest struct {
+	Addr   string `json:"addr" example:"mlnode1:9001"`
+	NodeID string `json:"nodeId,omitempty" example:"1"`
+}
+
+// @Summary Estado de los nodos ML
+// @Description Lista los nodos conocidos con su estado (up/down), latencia del último ping y errores.
+// @Tags admin-cluster
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} cluster.NodeStatus
+// @Router /admin/cluster/nodes [get]
+// GET /admin/cluster/nodes
+func (h *ClusterHandler) ListNodes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.svc.ListNodes())
+}
+
+// @Summary Registrar nodo ML (ADMIN)
+// @Tags admin-cluster
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body nodeRegistrationRequest true "Dirección host:puerto del nodo"
+// @Success 200 {object} cluster.NodeStatus
+// @Failure 400 {string} string "dirección inválida"
+// @Router /admin/cluster/nodes [post]
+// POST /admin/cluster/nodes
+func (h *ClusterHandler) RegisterNode(w http.ResponseWriter, r *http.Request) {
+	var req nodeRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "body inválido", http.StatusBadRequest)
+		return
+	}
+
+	st, err := h.svc.RegisterNode(r.Context(), req.Addr)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidNodeAddr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// @Summary Dar de baja nodo ML (ADMIN)
+// @Tags admin-cluster
+// @Security BearerAuth
+// @Param addr path string true "host:puerto del nodo"
+// @Success 204
+// @Failure 404 {string} string "nodo no registrado"
+// @Router /admin/cluster/nodes/{addr} [delete]
+// DELETE /admin/cluster/nodes/{addr}
+func (h *ClusterHandler) DeregisterNode(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeregisterNode(chi.URLParam(r, "addr")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Estadísticas internas de un nodo ML
+// @Tags admin-cluster
+// @Security BearerAuth
+// @Produce json
+// @Param addr path string true "host:puerto del nodo"
+// @Success 200 {object} cluster.NodeStats
+// @Failure 404 {string} string "nodo no registrado"
+// @Failure 502 {string} string "el nodo no respondió"
+// @Router /admin/cluster/nodes/{addr}/stats [get]
+// GET /admin/cluster/nodes/{addr}/stats
+func (h *ClusterHandler) GetNodeStats(w http.ResponseWriter, r *http.Request) {
+	st, err := h.svc.NodeStats(r.Context(), chi.URLParam(r, "addr"))
+	if err != nil {
+		if errors.Is(err, service.ErrNodeNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// @Summary Recargar índice de vecinos de un nodo ML (ADMIN)
+// @Description El nodo vuelve a leer la colección similarities a su índice en memoria y devuelve su tamaño y tiempo de carga.
+// @Tags admin-cluster
+// @Security BearerAuth
+// @Produce json
+// @Param addr path string true "host:puerto del nodo"
+// @Success 200 {object} cluster.IndexStats
+// @Failure 404 {string} string "nodo no registrado"
+// @Failure 502 {string} string "el nodo no pudo recargar"
+// @Router /admin/cluster/nodes/{addr}/reload-index [post]
+// POST /admin/cluster/nodes/{addr}/reload-index
+func (h *ClusterHandler) ReloadNodeIndex(w http.ResponseWriter, r *http.Request) {
+	st, err := h.svc.ReloadIndex(r.Context(), chi.URLParam(r, "addr"))
+	if err != nil {
+		if errors.Is(err, service.ErrNodeNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// @Summary Recargar índice de vecinos en todos los nodos (ADMIN)
+// @Tags admin-cluster
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} service.IndexReloadResult
+// @Router /admin/cluster/reload-index [post]
+// POST /admin/cluster/reload-index
+func (h *ClusterHandler) ReloadAllIndexes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.svc.ReloadAllIndexes(r.Context()))
+}
+
+// ---------------------- auto-registro de nodos ----------------------
+
+// @Summary Auto-registro de un nodo ML
+// @Description Lo llaman los propios nodos al arrancar (y periódicamente). Requiere el header X-Cluster-Token.
+// @Tags cluster
+// @Accept json
+// @Produce json
+// @Param X-Cluster-Token header string true "token compartido del cluster"
+// @Param body body nodeRegistrationRequest true "Dirección host:puerto del nodo"
+// @Success 200 {object} cluster.NodeStatus
+// @Router /cluster/nodes/register [post]
+// POST /cluster/nodes/register
+func (h *ClusterHandler) SelfRegister(w http.ResponseWriter, r *http.Request) {
+	h.RegisterNode(w, r)
+}
+
+// @Summary Auto-baja de un nodo ML
+// @Description Lo llama el nodo al apagarse. Requiere el header X-Cluster-Token.
+// @Tags cluster
+// @Accept json
+// @Param X-Cluster-Token header string true "token compartido del cluster"
+// @Param body body nodeRegistrationRequest true "Dirección host:puerto del nodo"
+// @Success 204
+// @Router /cluster/nodes/deregister [post]
+// POST /cluster/nodes/deregister
+func (h *ClusterHandler) SelfDeregister(w http.ResponseWriter, r *http.Request) {
+	var req nodeRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "body inválido", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.DeregisterNode(req.Addr); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ClusterTokenAuth solo deja pasar peticiones con el token compartido del cluster.
+func ClusterTokenAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := r.Header.Get("X-Cluster-Token")
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "invalid cluster token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Helper para montar las rutas admin en main.go
+func MountAdminClusterRoutes(r chi.Router, h *ClusterHandler) {
+	r.Route("/admin/cluster", func(r chi.Router) {
+		r.Get("/nodes", h.ListNodes)
+		r.Post("/nodes", h.RegisterNode)
+		r.Delete("/nodes/{addr}", h.DeregisterNode)
+		r.Get("/nodes/{addr}/stats", h.GetNodeStats)
+		r.Post("/nodes/{addr}/reload-index", h.ReloadNodeIndex)
+		r.Post("/reload-index", h.ReloadAllIndexes)
+	})
+}
+
+// Helper para montar las rutas de auto-registro (protegidas por token).
+func MountClusterRoutes(r chi.Router, h *ClusterHandler, token string) {
+	r.Route("/cluster", func(r chi.Router) {
+		r.Use(ClusterTokenAuth(token))
+		r.Post("/nodes/register", h.SelfRegister)
+		r.Post("/nodes/deregister", h.SelfDeregister)
+	})
+}
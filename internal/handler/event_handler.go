@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/service"
+)
+
+// EventHandler ingesta de eventos de interacción (feedback implícito).
+type EventHandler struct {
+	svc *service.EventService
+}
+
+// NewEventHandler crea el handler.
+func NewEventHandler(svc *service.EventService) *EventHandler {
+	return &EventHandler{svc: svc}
+}
+
+// @Summary Registrar un evento de interacción
+// @Description view / click / watchlist cuentan como feedback implícito en las recomendaciones; dismiss hace que la película no se le vuelva a recomendar.
+// @Tags events
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body models.EventRequest true "Evento"
+// @Success 201 {object} models.EventDoc
+// @Failure 400 {string} string "evento inválido"
+// @Failure 404 {string} string "película no encontrada"
+// @Router /me/events [post]
+// POST /me/events
+func (h *EventHandler) PostMyEvent(w http.ResponseWriter, r *http.Request) {
+	userID := UserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "no user in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.EventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "body inválido", http.StatusBadRequest)
+		return
+	}
+
+	ev, err := h.svc.Record(r.Context(), userID, req)
+	switch {
+	case errors.Is(err, service.ErrInvalidEvent):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrMovieNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ev)
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ExperimentHandler experimentos A/B sobre las recomendaciones.
+type ExperimentHandler struct {
+	svc *service.ExperimentService
+}
+
+// NewExperimentHandler crea el handler.
+func NewExperimentHandler(svc *service.ExperimentService) *ExperimentHandler {
+	return &ExperimentHandler{svc: svc}
+}
+
+// @Summary Crear experimento A/B (ADMIN)
+// @Description Crea un experimento en borrador. Cada variante fija algoritmo, modo, vecinos por ítem, diversidad (mmrLambda) y/o pesos del híbrido; los usuarios se reparten por userId según los pesos de las variantes.
+// @Tags admin-experiments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body models.ExperimentCreateRequest true "Experimento"
+// @Success 201 {object} models.Experiment
+// @Failure 400 {string} string "experimento inválido"
+// @Failure 409 {string} string "ya existe un experimento con ese id"
+// @Router /admin/experiments [post]
+// POST /admin/experiments
+func (h *ExperimentHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.ExperimentCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "body inválido", http.StatusBadRequest)
+		return
+	}
+
+	e, err := h.svc.Create(r.Context(), req)
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, e)
+}
+
+// @Summary Experimentos A/B (ADMIN)
+// @Tags admin-experiments
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} models.Experiment
+// @Router /admin/experiments [get]
+// GET /admin/experiments
+func (h *ExperimentHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// @Summary Experimento A/B (ADMIN)
+// @Tags admin-experiments
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "id del experimento"
+// @Success 200 {object} models.Experiment
+// @Failure 404 {string} string "experimento no encontrado"
+// @Router /admin/experiments/{id} [get]
+// GET /admin/experiments/{id}
+func (h *ExperimentHandler) Get(w http.ResponseWriter, r *http.Request) {
+	e, err := h.svc.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// @Summary Iniciar experimento A/B (ADMIN)
+// @Description Pasa el borrador a running. Solo puede haber un experimento en curso.
+// @Tags admin-experiments
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "id del experimento"
+// @Success 200 {object} models.Experiment
+// @Failure 404 {string} string "experimento no encontrado"
+// @Failure 409 {string} string "ya hay otro experimento en curso o no es un borrador"
+// @Router /admin/experiments/{id}/start [post]
+// POST /admin/experiments/{id}/start
+func (h *ExperimentHandler) Start(w http.ResponseWriter, r *http.Request) {
+	e, err := h.svc.Start(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// @Summary Detener experimento A/B (ADMIN)
+// @Tags admin-experiments
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "id del experimento"
+// @Success 200 {object} models.Experiment
+// @Failure 404 {string} string "experimento no encontrado"
+// @Failure 409 {string} string "el experimento no está en curso"
+// @Router /admin/experiments/{id}/stop [post]
+// POST /admin/experiments/{id}/stop
+func (h *ExperimentHandler) Stop(w http.ResponseWriter, r *http.Request) {
+	e, err := h.svc.Stop(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// @Summary Reporte de un experimento A/B (ADMIN)
+// @Description Por variante: usuarios expuestos, respuestas servidas, clics (eventos click de esos usuarios durante el experimento) y CTR.
+// @Tags admin-experiments
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "id del experimento"
+// @Success 200 {object} models.ExperimentReport
+// @Failure 404 {string} string "experimento no encontrado"
+// @Router /admin/experiments/{id}/report [get]
+// GET /admin/experiments/{id}/report
+func (h *ExperimentHandler) Report(w http.ResponseWriter, r *http.Request) {
+	rep, err := h.svc.Report(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+func writeExperimentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidExperiment):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrExperimentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrExperimentExists),
+		errors.Is(err, service.ErrExperimentRunning),
+		errors.Is(err, service.ErrExperimentState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Helper para montar las rutas admin en main.go
+func MountAdminExperimentRoutes(r chi.Router, h *ExperimentHandler) {
+	r.Route("/admin/experiments", func(r chi.Router) {
+		r.Get("/", h.List)
+		r.Post("/", h.Create)
+		r.Get("/{id}", h.Get)
+		r.Post("/{id}/start", h.Start)
+		r.Post("/{id}/stop", h.Stop)
+		r.Get("/{id}/report", h.Report)
+	})
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// MFHandler entrenamiento y consulta de modelos de factorización.
+type MFHandler struct {
+	svc *service.MFService
+}
+
+// NewMFHandler crea el handler.
+func NewMFHandler(svc *service.MFService) *MFHandler {
+	return &MFHandler{svc: svc}
+}
+
+// @Summary Entrenar modelo de factorización (ADMIN)
+// @Description Lanza en un nodo ML el entrenamiento (SGD) sobre toda la colección ratings. Responde al instante; el estado se consulta en GET /admin/models/mf/{id}.
+// @Tags admin-models
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body models.MFTrainRequest false "Hiperparámetros (ceros = default)"
+// @Success 202 {object} models.MFModel
+// @Failure 503 {string} string "no hay nodos ML disponibles"
+// @Router /admin/models/mf/train [post]
+// POST /admin/models/mf/train
+func (h *MFHandler) Train(w http.ResponseWriter, r *http.Request) {
+	var req models.MFTrainRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "body inválido", http.StatusBadRequest)
+			return
+		}
+	}
+
+	m, err := h.svc.Train(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, service.ErrNoHealthyNodes) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, m)
+}
+
+// @Summary Modelos de factorización (ADMIN)
+// @Tags admin-models
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} models.MFModel
+// @Router /admin/models/mf [get]
+// GET /admin/models/mf
+func (h *MFHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// @Summary Estado de un modelo de factorización (ADMIN)
+// @Tags admin-models
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "id del modelo"
+// @Success 200 {object} models.MFModel
+// @Failure 404 {string} string "modelo no encontrado"
+// @Router /admin/models/mf/{id} [get]
+// GET /admin/models/mf/{id}
+func (h *MFHandler) Get(w http.ResponseWriter, r *http.Request) {
+	m, err := h.svc.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, service.ErrMFModelNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// Helper para montar las rutas admin en main.go
+func MountAdminMFRoutes(r chi.Router, h *MFHandler) {
+	r.Route("/admin/models/mf", func(r chi.Router) {
+		r.Get("/", h.List)
+		r.Post("/train", h.Train)
+		r.Get("/{id}", h.Get)
+	})
+}
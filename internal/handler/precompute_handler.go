@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PrecomputeHandler job de precálculo de recomendaciones.
+type PrecomputeHandler struct {
+	svc *service.PrecomputeService
+}
+
+// NewPrecomputeHandler crea el handler.
+func NewPrecomputeHandler(svc *service.PrecomputeService) *PrecomputeHandler {
+	return &PrecomputeHandler{svc: svc}
+}
+
+// @Summary Precalcular recomendaciones (ADMIN)
+// @Description Recorre todos los usuarios (o los activos en las últimas N horas), calcula su top-K en el cluster con paralelismo acotado y lo deja en Redis y en el historial. Responde al instante; el avance se consulta en GET /admin/precompute.
+// @Tags admin-precompute
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body models.PrecomputeRequest false "Parámetros (ceros = default)"
+// @Success 202 {object} models.PrecomputeJob
+// @Failure 409 {string} string "ya hay un precálculo en curso"
+// @Router /admin/precompute [post]
+// POST /admin/precompute
+func (h *PrecomputeHandler) Start(w http.ResponseWriter, r *http.Request) {
+	var req models.PrecomputeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "body inválido", http.StatusBadRequest)
+			return
+		}
+	}
+
+	job, err := h.svc.Start(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, service.ErrPrecomputeRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// @Summary Estado del precálculo (ADMIN)
+// @Tags admin-precompute
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.PrecomputeJob
+// @Failure 404 {string} string "nunca se lanzó un precálculo"
+// @Router /admin/precompute [get]
+// GET /admin/precompute
+func (h *PrecomputeHandler) Status(w http.ResponseWriter, r *http.Request) {
+	job := h.svc.Status()
+	if job == nil {
+		http.Error(w, "nunca se lanzó un precálculo", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// @Summary Pausar el precálculo (ADMIN)
+// @Tags admin-precompute
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.PrecomputeJob
+// @Failure 409 {string} string "no hay un precálculo en curso"
+// @Router /admin/precompute/pause [post]
+// POST /admin/precompute/pause
+func (h *PrecomputeHandler) Pause(w http.ResponseWriter, r *http.Request) {
+	h.control(w, h.svc.Pause)
+}
+
+// @Summary Reanudar el precálculo (ADMIN)
+// @Tags admin-precompute
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.PrecomputeJob
+// @Failure 409 {string} string "no hay un precálculo en curso"
+// @Router /admin/precompute/resume [post]
+// POST /admin/precompute/resume
+func (h *PrecomputeHandler) Resume(w http.ResponseWriter, r *http.Request) {
+	h.control(w, h.svc.Resume)
+}
+
+// @Summary Cancelar el precálculo (ADMIN)
+// @Tags admin-precompute
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.PrecomputeJob
+// @Failure 409 {string} string "no hay un precálculo en curso"
+// @Router /admin/precompute/cancel [post]
+// POST /admin/precompute/cancel
+func (h *PrecomputeHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	h.control(w, h.svc.Cancel)
+}
+
+func (h *PrecomputeHandler) control(w http.ResponseWriter, fn func() (*models.PrecomputeJob, error)) {
+	job, err := fn()
+	if err != nil {
+		if errors.Is(err, service.ErrNoPrecomputeJob) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// Helper para montar las rutas admin en main.go
+func MountAdminPrecomputeRoutes(r chi.Router, h *PrecomputeHandler) {
+	r.Route("/admin/precompute", func(r chi.Router) {
+		r.Get("/", h.Status)
+		r.Post("/", h.Start)
+		r.Post("/pause", h.Pause)
+		r.Post("/resume", h.Resume)
+		r.Post("/cancel", h.Cancel)
+	})
+}
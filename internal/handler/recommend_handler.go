@@ -2,11 +2,16 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
 	"nodosml-pc4/internal/service"
 
 	"github.com/go-chi/chi/v5"
@@ -32,25 +37,42 @@ func NewRecommendHandler(s *service.RecommendService) *RecommendHandler {
 // @Param id path int true "userId"
 // @Param k query int false "cantidad de recomendaciones (máx 50)"
 // @Param refresh query bool false "si true, ignora cache Redis"
+// @Param algo query string false "algoritmo: item-knn (default) | mf | content | hybrid"
+// @Param mode query string false "modo de predicción del item-knn: weighted (default) | mean-centered | baseline"
+// @Param weights query string false "pesos del modo hybrid, p.e. item-knn:0.6,content:0.4,mf:0"
+// @Param genres query string false "solo películas con alguno de estos géneros (separados por coma)"
+// @Param excludeGenres query string false "excluye películas con alguno de estos géneros (separados por coma)"
+// @Param yearFrom query int false "año mínimo"
+// @Param yearTo query int false "año máximo"
+// @Param minCount query int false "mínimo de ratings (ratingStats.count)"
+// @Param runtimeMin query int false "duración mínima en minutos"
+// @Param runtimeMax query int false "duración máxima en minutos"
+// @Param lambda query number false "re-ranking MMR: peso de la relevancia frente a la diversidad (0 < lambda <= 1; 1 = sin re-ranking)"
+// @Param metric query string false "métrica de similitud del item-knn: cosine (default) | adjusted-cosine | pearson | jaccard | embedding"
 // @Success 200 {array} models.RecItem
+// @Header 200 {string} X-Recommendations-Partial "true si algún nodo ML no respondió"
+// @Header 200 {string} X-Recommendations-Failed-Shards "shards sin respuesta, separados por coma"
+// @Header 200 {number} X-Recommendations-Diversity "diversidad intra-lista (1 - similitud media entre pares)"
+// @Header 200 {string} X-Experiment "experimento A/B en curso (si el usuario participa)"
+// @Header 200 {string} X-Experiment-Variant "variante del experimento asignada al usuario"
 // @Router /users/{id}/recommendations [get]
 func (h *RecommendHandler) GetRecommendations(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	userID, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	k, _ := strconv.Atoi(r.URL.Query().Get("k"))
-	refresh := r.URL.Query().Get("refresh") == "true"
+	req, err := parseRecRequest(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	items, err := h.svc.Recommend(r.Context(), service.RecRequest{
-		UserID:  userID,
-		K:       k,
-		Refresh: refresh,
-	})
+	res, err := h.svc.Recommend(r.Context(), req)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	_ = json.NewEncoder(w).Encode(items)
+	setRecHeaders(w, res)
+	_ = json.NewEncoder(w).Encode(res.Items)
 }
 
 // upgrader global (no afecta a swagger)
@@ -65,6 +87,18 @@ var upgrader = websocket.Upgrader{
 // @Param id path int true "userId"
 // @Param k query int false "cantidad de recomendaciones (máx 50)"
 // @Param refresh query bool false "si true, ignora cache Redis"
+// @Param algo query string false "algoritmo: item-knn (default) | mf | content | hybrid"
+// @Param mode query string false "modo de predicción del item-knn: weighted (default) | mean-centered | baseline"
+// @Param weights query string false "pesos del modo hybrid, p.e. item-knn:0.6,content:0.4,mf:0"
+// @Param genres query string false "solo películas con alguno de estos géneros (separados por coma)"
+// @Param excludeGenres query string false "excluye películas con alguno de estos géneros (separados por coma)"
+// @Param yearFrom query int false "año mínimo"
+// @Param yearTo query int false "año máximo"
+// @Param minCount query int false "mínimo de ratings (ratingStats.count)"
+// @Param runtimeMin query int false "duración mínima en minutos"
+// @Param runtimeMax query int false "duración máxima en minutos"
+// @Param lambda query number false "re-ranking MMR: peso de la relevancia frente a la diversidad (0 < lambda <= 1; 1 = sin re-ranking)"
+// @Param metric query string false "métrica de similitud del item-knn: cosine (default) | adjusted-cosine | pearson | jaccard | embedding"
 // @Success 200 {object} map[string]interface{}
 // @Router /users/{id}/ws/recommendations [get]
 func (h *RecommendHandler) GetRecommendationsWS(w http.ResponseWriter, r *http.Request) {
@@ -78,8 +112,14 @@ func (h *RecommendHandler) GetRecommendationsWS(w http.ResponseWriter, r *http.R
 	defer conn.Close()
 
 	userID, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	k, _ := strconv.Atoi(r.URL.Query().Get("k"))
-	refresh := r.URL.Query().Get("refresh") == "true"
+	req, err := parseRecRequest(r, userID)
+	if err != nil {
+		conn.WriteJSON(map[string]any{
+			"type":  "error",
+			"error": err.Error(),
+		})
+		return
+	}
 
 	// Mensaje inicial
 	conn.WriteJSON(map[string]any{
@@ -87,22 +127,37 @@ func (h *RecommendHandler) GetRecommendationsWS(w http.ResponseWriter, r *http.R
 		"msg":  "Conexión WS abierta, iniciando cálculo…",
 	})
 
-	// Simular mensajes de progreso (uno por cada shard)
-	for i := 1; i <= 4; i++ {
-		time.Sleep(300 * time.Millisecond)
+	start := time.Now()
+
+	// Un mensaje por cada respuesta real de un nodo (o error de un intento).
+	// El servicio llama a OnProgress desde una sola goroutine, así que no
+	// hay escrituras concurrentes sobre conn.
+	onProgress := func(ev service.ShardProgress) {
+		if ev.Err != nil {
+			conn.WriteJSON(map[string]any{
+				"type":      "shard_error",
+				"shard":     ev.ShardID,
+				"node":      ev.Node,
+				"attempt":   ev.Attempt,
+				"final":     ev.Final,
+				"elapsedMs": ev.Elapsed.Milliseconds(),
+				"error":     ev.Err.Error(),
+			})
+			return
+		}
 		conn.WriteJSON(map[string]any{
-			"type":  "progress",
-			"shard": i,
-			"msg":   fmt.Sprintf("Nodo ML %d completó su parte", i),
+			"type":      "progress",
+			"shard":     ev.ShardID,
+			"node":      ev.Node,
+			"attempt":   ev.Attempt,
+			"partials":  ev.Partials,
+			"elapsedMs": ev.Elapsed.Milliseconds(),
+			"msg":       fmt.Sprintf("Nodo ML %s completó el shard %d", ev.Node, ev.ShardID),
 		})
 	}
 
-	// Calcular recomendaciones reales
-	items, err := h.svc.Recommend(r.Context(), service.RecRequest{
-		UserID:  userID,
-		K:       k,
-		Refresh: refresh,
-	})
+	req.OnProgress = onProgress
+	res, err := h.svc.Recommend(r.Context(), req)
 	if err != nil {
 		conn.WriteJSON(map[string]any{
 			"type":  "error",
@@ -113,10 +168,22 @@ func (h *RecommendHandler) GetRecommendationsWS(w http.ResponseWriter, r *http.R
 
 	// Mensaje final con recomendaciones
 	conn.WriteJSON(map[string]any{
-		"type":        "recommendations",
-		"userId":      userID,
-		"items":       items,
-		"generatedAt": time.Now(),
+		"type":         "recommendations",
+		"userId":       userID,
+		"algo":         res.Algo,
+		"mode":         res.Mode,
+		"metric":       res.Metric,
+		"weights":      res.Weights,
+		"filters":      req.Filter,
+		"lambda":       res.MMRLambda,
+		"experiment":   res.Experiment,
+		"diversity":    res.Diversity,
+		"items":        res.Items,
+		"cached":       res.Cached,
+		"partial":      res.Partial,
+		"failedShards": res.FailedShards,
+		"elapsedMs":    time.Since(start).Milliseconds(),
+		"generatedAt":  time.Now(),
 	})
 }
 
@@ -130,7 +197,24 @@ func (h *RecommendHandler) GetRecommendationsWS(w http.ResponseWriter, r *http.R
 // @Produce json
 // @Param k query int false "cantidad de recomendaciones (máx 50)"
 // @Param refresh query bool false "si true, ignora cache Redis"
+// @Param algo query string false "algoritmo: item-knn (default) | mf | content | hybrid"
+// @Param mode query string false "modo de predicción del item-knn: weighted (default) | mean-centered | baseline"
+// @Param weights query string false "pesos del modo hybrid, p.e. item-knn:0.6,content:0.4,mf:0"
+// @Param genres query string false "solo películas con alguno de estos géneros (separados por coma)"
+// @Param excludeGenres query string false "excluye películas con alguno de estos géneros (separados por coma)"
+// @Param yearFrom query int false "año mínimo"
+// @Param yearTo query int false "año máximo"
+// @Param minCount query int false "mínimo de ratings (ratingStats.count)"
+// @Param runtimeMin query int false "duración mínima en minutos"
+// @Param runtimeMax query int false "duración máxima en minutos"
+// @Param lambda query number false "re-ranking MMR: peso de la relevancia frente a la diversidad (0 < lambda <= 1; 1 = sin re-ranking)"
+// @Param metric query string false "métrica de similitud del item-knn: cosine (default) | adjusted-cosine | pearson | jaccard | embedding"
 // @Success 200 {array} models.RecItem
+// @Header 200 {string} X-Recommendations-Partial "true si algún nodo ML no respondió"
+// @Header 200 {string} X-Recommendations-Failed-Shards "shards sin respuesta, separados por coma"
+// @Header 200 {number} X-Recommendations-Diversity "diversidad intra-lista (1 - similitud media entre pares)"
+// @Header 200 {string} X-Experiment "experimento A/B en curso (si el usuario participa)"
+// @Header 200 {string} X-Experiment-Variant "variante del experimento asignada al usuario"
 // @Router /me/recommendations [get]
 func (h *RecommendHandler) GetMyRecommendations(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -141,17 +225,367 @@ func (h *RecommendHandler) GetMyRecommendations(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	k, _ := strconv.Atoi(r.URL.Query().Get("k"))
-	refresh := r.URL.Query().Get("refresh") == "true"
+	req, err := parseRecRequest(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	items, err := h.svc.Recommend(r.Context(), service.RecRequest{
-		UserID:  userID,
-		K:       k,
-		Refresh: refresh,
-	})
+	res, err := h.svc.Recommend(r.Context(), req)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	_ = json.NewEncoder(w).Encode(items)
+	setRecHeaders(w, res)
+	_ = json.NewEncoder(w).Encode(res.Items)
+}
+
+// =====================
+// Historial de recomendaciones
+// =====================
+
+// @Summary Mi historial de recomendaciones
+// @Tags recommend
+// @Security BearerAuth
+// @Produce json
+// @Param before query string false "createdAt (RFC3339) del último ítem de la página anterior"
+// @Param limit query int false "tamaño de página (default 20, máx 100)"
+// @Success 200 {object} models.RecHistoryPage
+// @Router /me/recommendations/history [get]
+func (h *RecommendHandler) GetMyHistory(w http.ResponseWriter, r *http.Request) {
+	userID := UserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "no user in context", http.StatusUnauthorized)
+		return
+	}
+	h.history(w, r, userID)
+}
+
+// @Summary Historial de recomendaciones de un usuario (ADMIN)
+// @Tags recommend
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "userId"
+// @Param before query string false "createdAt (RFC3339) del último ítem de la página anterior"
+// @Param limit query int false "tamaño de página (default 20, máx 100)"
+// @Success 200 {object} models.RecHistoryPage
+// @Router /users/{id}/recommendations/history [get]
+func (h *RecommendHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	h.history(w, r, userID)
+}
+
+func (h *RecommendHandler) history(w http.ResponseWriter, r *http.Request, userID int) {
+	q := r.URL.Query()
+	var before time.Time
+	if v := q.Get("before"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			http.Error(w, "before inválido (RFC3339)", http.StatusBadRequest)
+			return
+		}
+		before = t
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	page, err := h.svc.History(r.Context(), userID, before, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// @Summary Diferencias entre dos de mis recomendaciones
+// @Description Sin from/to compara las dos más recientes; con solo to, to contra la anterior.
+// @Tags recommend
+// @Security BearerAuth
+// @Produce json
+// @Param from query string false "id de la ejecución más vieja"
+// @Param to query string false "id de la ejecución más nueva"
+// @Success 200 {object} models.RecDiff
+// @Failure 404 {string} string "ejecución no encontrada o historial insuficiente"
+// @Router /me/recommendations/history/diff [get]
+func (h *RecommendHandler) GetMyHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	userID := UserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "no user in context", http.StatusUnauthorized)
+		return
+	}
+	h.historyDiff(w, r, userID)
+}
+
+// @Summary Diferencias entre dos recomendaciones de un usuario (ADMIN)
+// @Description Sin from/to compara las dos más recientes; con solo to, to contra la anterior.
+// @Tags recommend
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "userId"
+// @Param from query string false "id de la ejecución más vieja"
+// @Param to query string false "id de la ejecución más nueva"
+// @Success 200 {object} models.RecDiff
+// @Failure 404 {string} string "ejecución no encontrada o historial insuficiente"
+// @Router /users/{id}/recommendations/history/diff [get]
+func (h *RecommendHandler) GetHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	h.historyDiff(w, r, userID)
+}
+
+func (h *RecommendHandler) historyDiff(w http.ResponseWriter, r *http.Request, userID int) {
+	q := r.URL.Query()
+	diff, err := h.svc.Diff(r.Context(), userID, q.Get("from"), q.Get("to"))
+	switch {
+	case errors.Is(err, service.ErrRecRunNotFound), errors.Is(err, service.ErrNotEnoughRuns):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// =====================
+// Explicación de una recomendación
+// =====================
+
+// @Summary Por qué me recomendaron una película
+// @Tags recommend
+// @Security BearerAuth
+// @Produce json
+// @Param movieId path int true "movieId"
+// @Param metric query string false "métrica de similitud: cosine (default) | adjusted-cosine | pearson | jaccard | embedding"
+// @Success 200 {object} models.Explanation
+// @Failure 404 {string} string "película inexistente o sin datos para explicar"
+// @Router /me/recommendations/{movieId}/explain [get]
+func (h *RecommendHandler) GetMyExplanation(w http.ResponseWriter, r *http.Request) {
+	userID := UserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "no user in context", http.StatusUnauthorized)
+		return
+	}
+	h.explain(w, r, userID)
+}
+
+// @Summary Por qué se le recomendó una película a un usuario (ADMIN)
+// @Tags recommend
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "userId"
+// @Param movieId path int true "movieId"
+// @Param metric query string false "métrica de similitud: cosine (default) | adjusted-cosine | pearson | jaccard | embedding"
+// @Success 200 {object} models.Explanation
+// @Failure 404 {string} string "película inexistente o sin datos para explicar"
+// @Router /users/{id}/recommendations/{movieId}/explain [get]
+func (h *RecommendHandler) GetExplanation(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	h.explain(w, r, userID)
+}
+
+func (h *RecommendHandler) explain(w http.ResponseWriter, r *http.Request, userID int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	movieID, err := strconv.Atoi(chi.URLParam(r, "movieId"))
+	if err != nil {
+		http.Error(w, "movieId inválido", http.StatusBadRequest)
+		return
+	}
+
+	metric, err := ml.ParseMetric(r.URL.Query().Get("metric"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exp, err := h.svc.Explain(r.Context(), service.ExplainRequest{UserID: userID, MovieID: movieID, Metric: metric})
+	switch {
+	case errors.Is(err, service.ErrMovieNotFound), errors.Is(err, service.ErrCannotExplain):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(exp)
+}
+
+// =====================
+// Endpoint público
+// =====================
+
+// @Summary Películas similares ("porque viste X")
+// @Tags movies
+// @Produce json
+// @Param id path int true "movieId"
+// @Param k query int false "cantidad de películas (máx 50)"
+// @Param genres query string false "solo películas con alguno de estos géneros (separados por coma)"
+// @Param excludeGenres query string false "excluye películas con alguno de estos géneros (separados por coma)"
+// @Param yearFrom query int false "año mínimo"
+// @Param yearTo query int false "año máximo"
+// @Param minCount query int false "mínimo de ratings (ratingStats.count)"
+// @Param runtimeMin query int false "duración mínima en minutos"
+// @Param runtimeMax query int false "duración máxima en minutos"
+// @Param metric query string false "métrica de similitud: cosine (default) | adjusted-cosine | pearson | jaccard | embedding"
+// @Success 200 {array} models.SimilarMovie
+// @Failure 404 {string} string "película no encontrada"
+// @Router /movies/{id}/similar [get]
+func (h *RecommendHandler) GetSimilarMovies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	movieID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "movieId inválido", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	k, _ := strconv.Atoi(q.Get("k"))
+	filter, err := parseRecFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metric, err := ml.ParseMetric(q.Get("metric"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	movies, err := h.svc.SimilarMovies(r.Context(), movieID, k, metric, filter)
+	if errors.Is(err, service.ErrMovieNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(movies)
+}
+
+// parseRecRequest lee los parámetros comunes de las rutas de recomendación.
+func parseRecRequest(r *http.Request, userID int) (service.RecRequest, error) {
+	q := r.URL.Query()
+	k, _ := strconv.Atoi(q.Get("k"))
+
+	algo, err := ml.ParseAlgo(q.Get("algo"))
+	if err != nil {
+		return service.RecRequest{}, err
+	}
+	mode, err := ml.ParseMode(q.Get("mode"))
+	if err != nil {
+		return service.RecRequest{}, err
+	}
+	metric, err := ml.ParseMetric(q.Get("metric"))
+	if err != nil {
+		return service.RecRequest{}, err
+	}
+	var weights map[string]float64
+	if algo == ml.AlgoHybrid {
+		if weights, err = ml.ParseWeights(q.Get("weights")); err != nil {
+			return service.RecRequest{}, err
+		}
+	}
+
+	filter, err := parseRecFilter(q)
+	if err != nil {
+		return service.RecRequest{}, err
+	}
+	var lambda float64
+	if v := q.Get("lambda"); v != "" {
+		if lambda, err = ml.ParseMMRLambda(v); err != nil {
+			return service.RecRequest{}, err
+		}
+	}
+
+	// algo/mode/metric/lambda sin indicar quedan vacíos: el servicio usa la
+	// rama del experimento A/B en curso o el default
+	if q.Get("algo") == "" {
+		algo = ""
+	}
+	if q.Get("mode") == "" {
+		mode = ""
+	}
+	if q.Get("metric") == "" {
+		metric = ""
+	}
+
+	return service.RecRequest{
+		UserID:  userID,
+		K:       k,
+		Refresh: q.Get("refresh") == "true",
+		Algo:    algo,
+		Mode:    mode,
+		Metric:  metric,
+		Weights: weights,
+		Filter:  filter,
+
+		MMRLambda: lambda,
+	}, nil
+}
+
+// parseRecFilter lee los filtros opcionales (géneros, año, popularidad,
+// duración) de la query.
+func parseRecFilter(q url.Values) (models.RecFilter, error) {
+	var f models.RecFilter
+	f.Genres = splitList(q.Get("genres"))
+	f.ExcludeGenres = splitList(q.Get("excludeGenres"))
+
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{"yearFrom", &f.YearFrom},
+		{"yearTo", &f.YearTo},
+		{"minCount", &f.MinCount},
+		{"runtimeMin", &f.RuntimeMin},
+		{"runtimeMax", &f.RuntimeMax},
+	}
+	for _, p := range ints {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("%s inválido: %q", p.name, v)
+		}
+		*p.dst = n
+	}
+
+	if f.YearFrom > 0 && f.YearTo > 0 && f.YearFrom > f.YearTo {
+		return f, fmt.Errorf("yearFrom (%d) mayor que yearTo (%d)", f.YearFrom, f.YearTo)
+	}
+	if f.RuntimeMin > 0 && f.RuntimeMax > 0 && f.RuntimeMin > f.RuntimeMax {
+		return f, fmt.Errorf("runtimeMin (%d) mayor que runtimeMax (%d)", f.RuntimeMin, f.RuntimeMax)
+	}
+	return f, nil
+}
+
+// splitList "a, b,,c" -> [a b c]
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// setRecHeaders expone los metadatos del cálculo sin cambiar el body
+// (que sigue siendo el array de RecItem).
+func setRecHeaders(w http.ResponseWriter, res *models.RecResult) {
+	w.Header().Set("X-Recommendations-Partial", strconv.FormatBool(res.Partial))
+	w.Header().Set("X-Recommendations-Diversity", strconv.FormatFloat(res.Diversity, 'f', 4, 64))
+	if len(res.FailedShards) > 0 {
+		ids := make([]string, len(res.FailedShards))
+		for i, id := range res.FailedShards {
+			ids[i] = strconv.Itoa(id)
+		}
+		w.Header().Set("X-Recommendations-Failed-Shards", strings.Join(ids, ","))
+	}
+	if res.Experiment != nil {
+		w.Header().Set("X-Experiment", res.Experiment.ExperimentID)
+		w.Header().Set("X-Experiment-Variant", res.Experiment.Variant)
+	}
 }
@@ -0,0 +1,25 @@
+package ml
+
+import "fmt"
+
+// Algoritmos de recomendación.
+const (
+	AlgoItemKNN = "item-knn"
+	AlgoMF      = "mf"
+	AlgoContent = "content"
+	AlgoHybrid  = "hybrid"
+)
+
+// DefaultAlgo algoritmo cuando la petición no indica ninguno.
+const DefaultAlgo = AlgoItemKNN
+
+// ParseAlgo valida el algoritmo ("" = DefaultAlgo).
+func ParseAlgo(s string) (string, error) {
+	switch s {
+	case "", "knn":
+		return DefaultAlgo, nil
+	case AlgoItemKNN, AlgoMF, AlgoContent, AlgoHybrid:
+		return s, nil
+	}
+	return "", fmt.Errorf("algoritmo desconocido %q (item-knn | mf | content | hybrid)", s)
+}
@@ -0,0 +1,79 @@
+package ml
+
+import (
+	"math"
+	"strings"
+
+	"nodosml-pc4/internal/models"
+)
+
+// BayesianAverage promedio "encogido" hacia priorMean: una película con
+// pocos ratings no le gana a una con miles solo por tener un 5.0.
+func BayesianAverage(avg float64, count int, priorMean, priorCount float64) float64 {
+	return (priorCount*priorMean + float64(count)*avg) / (priorCount + float64(count))
+}
+
+// NormalizeRating lleva un score en escala de rating (0.5..5) a [0,1].
+func NormalizeRating(r float64) float64 {
+	return math.Max(0, math.Min(1, (r-0.5)/4.5))
+}
+
+// GenreAffinity coseno entre los géneros de la película y los preferidos
+// (vectores binarios, sin distinguir mayúsculas).
+func GenreAffinity(genres, preferred []string) float64 {
+	if len(genres) == 0 || len(preferred) == 0 {
+		return 0
+	}
+	pref := make(map[string]bool, len(preferred))
+	for _, g := range preferred {
+		pref[strings.ToLower(strings.TrimSpace(g))] = true
+	}
+	common := 0
+	for _, g := range genres {
+		if pref[strings.ToLower(g)] {
+			common++
+		}
+	}
+	return float64(common) / math.Sqrt(float64(len(genres)*len(pref)))
+}
+
+// TagVector vector disperso tag -> relevancia (genome tags).
+type TagVector map[string]float64
+
+// TagVectorOf arma el vector de una película.
+func TagVectorOf(tags []models.GenomeTag) TagVector {
+	v := make(TagVector, len(tags))
+	for _, t := range tags {
+		v[t.Tag] = t.Relevance
+	}
+	return v
+}
+
+// AddScaled v += w*o.
+func (v TagVector) AddScaled(o TagVector, w float64) {
+	for t, x := range o {
+		v[t] += w * x
+	}
+}
+
+// Cosine similitud coseno entre dos vectores dispersos.
+func (v TagVector) Cosine(o TagVector) float64 {
+	if len(v) == 0 || len(o) == 0 {
+		return 0
+	}
+	if len(o) < len(v) {
+		v, o = o, v
+	}
+	var dot, nv, no float64
+	for t, x := range v {
+		dot += x * o[t]
+		nv += x * x
+	}
+	for _, y := range o {
+		no += y * y
+	}
+	if nv == 0 || no == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(nv*no)
+}
@@ -0,0 +1,325 @@
+package ml
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"nodosml-pc4/internal/models"
+)
+
+// Pesos de cada bloque de atributos en el vector de una película. Cada
+// bloque se normaliza por separado antes de aplicar el peso, así una
+// película con 30 genome tags no ahoga a los géneros o al director.
+const (
+	ContentWeightTags     = 1.0
+	ContentWeightGenres   = 0.6
+	ContentWeightCast     = 0.5
+	ContentWeightDirector = 0.7
+)
+
+const (
+	contentMaxTags = 30 // genome tags más relevantes por película
+	contentMaxCast = 5  // actores principales
+)
+
+// ContentIndex vectores de atributos de las películas (genome tags,
+// géneros, reparto, director) en formato compacto, con índice invertido
+// para puntuar todo el catálogo contra un perfil sin recorrer cada vector.
+type ContentIndex struct {
+	features map[string]int32 // "tag:..." / "genre:..." -> id
+	pos      map[int]int32    // movieId -> fila
+
+	movieIDs []int
+	hasIIdx  []bool // la película tiene iIdx (puede tener similitudes)
+
+	// vector de cada fila: ids de feature ordenados + pesos (norma 1)
+	offsets []int32
+	feat    []int32
+	weight  []float32
+
+	// índice invertido feature -> filas
+	postRows   [][]int32
+	postWeight [][]float32
+}
+
+// NewContentIndex arma el índice. Los pesos de géneros, reparto y director
+// llevan idf para que "Drama" pese menos que un director poco frecuente.
+func NewContentIndex(movies []models.MovieDoc) *ContentIndex {
+	ix := &ContentIndex{
+		features: make(map[string]int32),
+		pos:      make(map[int]int32, len(movies)),
+		offsets:  []int32{0},
+	}
+
+	// document frequency de los atributos categóricos
+	df := make(map[string]int)
+	raw := make([]map[string]float64, len(movies))
+	for i, m := range movies {
+		raw[i] = rawFeatures(m)
+		for f := range raw[i] {
+			if !strings.HasPrefix(f, "tag:") {
+				df[f]++
+			}
+		}
+	}
+	n := float64(len(movies))
+
+	for i, m := range movies {
+		blocks := map[string]map[string]float64{}
+		for f, w := range raw[i] {
+			block := f[:strings.IndexByte(f, ':')]
+			if block != "tag" {
+				w *= math.Log(1 + n/float64(df[f]))
+			}
+			if blocks[block] == nil {
+				blocks[block] = map[string]float64{}
+			}
+			blocks[block][f] = w
+		}
+
+		vec := map[int32]float64{}
+		for block, fs := range blocks {
+			var norm float64
+			for _, w := range fs {
+				norm += w * w
+			}
+			if norm == 0 {
+				continue
+			}
+			scale := blockWeight(block) / math.Sqrt(norm)
+			for f, w := range fs {
+				vec[ix.featureID(f)] = w * scale
+			}
+		}
+
+		ix.pos[m.MovieID] = int32(len(ix.movieIDs))
+		ix.movieIDs = append(ix.movieIDs, m.MovieID)
+		ix.hasIIdx = append(ix.hasIIdx, m.IIdx != nil)
+		ix.appendRow(vec)
+	}
+
+	// índice invertido
+	ix.postRows = make([][]int32, len(ix.features))
+	ix.postWeight = make([][]float32, len(ix.features))
+	for row := 0; row < len(ix.movieIDs); row++ {
+		for j := ix.offsets[row]; j < ix.offsets[row+1]; j++ {
+			f := ix.feat[j]
+			ix.postRows[f] = append(ix.postRows[f], int32(row))
+			ix.postWeight[f] = append(ix.postWeight[f], ix.weight[j])
+		}
+	}
+	return ix
+}
+
+func blockWeight(block string) float64 {
+	switch block {
+	case "tag":
+		return ContentWeightTags
+	case "genre":
+		return ContentWeightGenres
+	case "cast":
+		return ContentWeightCast
+	case "dir":
+		return ContentWeightDirector
+	}
+	return 0
+}
+
+// rawFeatures atributos de una película con su peso sin normalizar.
+func rawFeatures(m models.MovieDoc) map[string]float64 {
+	out := make(map[string]float64)
+
+	tags := append([]models.GenomeTag(nil), m.GenomeTags...)
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Relevance > tags[j].Relevance })
+	if len(tags) > contentMaxTags {
+		tags = tags[:contentMaxTags]
+	}
+	for _, t := range tags {
+		out["tag:"+strings.ToLower(t.Tag)] = t.Relevance
+	}
+
+	for _, g := range m.Genres {
+		if g = strings.ToLower(strings.TrimSpace(g)); g != "" && g != "(no genres listed)" {
+			out["genre:"+g] = 1
+		}
+	}
+
+	if ext := m.ExternalData; ext != nil {
+		for i, c := range ext.Cast {
+			if i >= contentMaxCast {
+				break
+			}
+			if name := strings.ToLower(strings.TrimSpace(c.Name)); name != "" {
+				out["cast:"+name] = 1
+			}
+		}
+		if d := strings.ToLower(strings.TrimSpace(ext.Director)); d != "" {
+			out["dir:"+d] = 1
+		}
+	}
+	return out
+}
+
+func (ix *ContentIndex) featureID(f string) int32 {
+	id, ok := ix.features[f]
+	if !ok {
+		id = int32(len(ix.features))
+		ix.features[f] = id
+	}
+	return id
+}
+
+// appendRow agrega el vector normalizado (norma 1) con las features ordenadas.
+func (ix *ContentIndex) appendRow(vec map[int32]float64) {
+	ids := make([]int32, 0, len(vec))
+	var norm float64
+	for f, w := range vec {
+		ids = append(ids, f)
+		norm += w * w
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	norm = math.Sqrt(norm)
+
+	for _, f := range ids {
+		ix.feat = append(ix.feat, f)
+		ix.weight = append(ix.weight, float32(vec[f]/norm))
+	}
+	ix.offsets = append(ix.offsets, int32(len(ix.feat)))
+}
+
+// Len cantidad de películas indexadas.
+func (ix *ContentIndex) Len() int { return len(ix.movieIDs) }
+
+// Has indica si la película está en el índice con algún atributo.
+func (ix *ContentIndex) Has(movieID int) bool {
+	row, ok := ix.pos[movieID]
+	return ok && ix.offsets[row+1] > ix.offsets[row]
+}
+
+// HasIIdx indica si la película tiene iIdx (y por lo tanto puede tener
+// vecinos precalculados en similarities).
+func (ix *ContentIndex) HasIIdx(movieID int) bool {
+	row, ok := ix.pos[movieID]
+	return ok && ix.hasIIdx[row]
+}
+
+// MoviesWithoutIIdx películas con atributos pero sin iIdx: recién
+// aprobadas o sin ratings, que el item-kNN nunca puede recomendar.
+func (ix *ContentIndex) MoviesWithoutIIdx() []int {
+	var out []int
+	for row, id := range ix.movieIDs {
+		if !ix.hasIIdx[row] && ix.offsets[row+1] > ix.offsets[row] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// Profile vector de usuario: suma de los vectores de lo que valoró,
+// ponderados por rating - media del usuario (lo que no le gustó resta).
+func (ix *ContentIndex) Profile(ratings []models.RatingDoc) map[int32]float64 {
+	mean := UserMean(ratings)
+	profile := make(map[int32]float64)
+	for _, r := range ratings {
+		row, ok := ix.pos[r.MovieID]
+		if !ok {
+			continue
+		}
+		w := r.Rating - mean
+		if w == 0 {
+			// todos iguales: que al menos cuente lo valorado
+			w = r.Rating / 5
+		}
+		for j := ix.offsets[row]; j < ix.offsets[row+1]; j++ {
+			profile[ix.feat[j]] += w * float64(ix.weight[j])
+		}
+	}
+	var norm float64
+	for _, w := range profile {
+		norm += w * w
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for f := range profile {
+			profile[f] /= norm
+		}
+	}
+	return profile
+}
+
+// ContentScore una película con su similitud coseno al perfil/consulta.
+type ContentScore struct {
+	MovieID int
+	Score   float64
+}
+
+// Score puntúa todo el catálogo contra el perfil (coseno, en [-1,1]) y
+// devuelve las mejores n con score > 0, sin las excluidas.
+func (ix *ContentIndex) Score(profile map[int32]float64, exclude map[int]bool, n int) []ContentScore {
+	acc := make(map[int32]float64)
+	for f, pw := range profile {
+		rows, ws := ix.postRows[f], ix.postWeight[f]
+		for k, row := range rows {
+			acc[row] += pw * float64(ws[k])
+		}
+	}
+
+	out := make([]ContentScore, 0, len(acc))
+	for row, sc := range acc {
+		id := ix.movieIDs[row]
+		if sc <= 0 || exclude[id] {
+			continue
+		}
+		out = append(out, ContentScore{MovieID: id, Score: sc})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Similar las k películas más parecidas por contenido (sin ella misma).
+func (ix *ContentIndex) Similar(movieID, k int) []models.Neighbor {
+	row, ok := ix.pos[movieID]
+	if !ok {
+		return nil
+	}
+	query := make(map[int32]float64, ix.offsets[row+1]-ix.offsets[row])
+	for j := ix.offsets[row]; j < ix.offsets[row+1]; j++ {
+		query[ix.feat[j]] = float64(ix.weight[j])
+	}
+
+	scored := ix.Score(query, map[int]bool{movieID: true}, k)
+	out := make([]models.Neighbor, len(scored))
+	for i, s := range scored {
+		out[i] = models.Neighbor{MovieID: s.MovieID, Sim: s.Score}
+	}
+	return out
+}
+
+// Sim similitud coseno por contenido entre dos películas.
+func (ix *ContentIndex) Sim(a, b int) float64 {
+	ra, okA := ix.pos[a]
+	rb, okB := ix.pos[b]
+	if !okA || !okB {
+		return 0
+	}
+	i, iEnd := ix.offsets[ra], ix.offsets[ra+1]
+	j, jEnd := ix.offsets[rb], ix.offsets[rb+1]
+	var dot float64
+	for i < iEnd && j < jEnd {
+		switch {
+		case ix.feat[i] == ix.feat[j]:
+			dot += float64(ix.weight[i]) * float64(ix.weight[j])
+			i++
+			j++
+		case ix.feat[i] < ix.feat[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return dot
+}
@@ -0,0 +1,90 @@
+package ml
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// NoMMR lambda que deja la lista ordenada solo por relevancia.
+const NoMMR = 1.0
+
+// ParseMMRLambda valida lambda en (0,1] ("" = NoMMR, sin re-ranking).
+func ParseMMRLambda(s string) (float64, error) {
+	if s == "" {
+		return NoMMR, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 || v > 1 {
+		return 0, fmt.Errorf("lambda de MMR inválido %q (debe estar en (0,1])", s)
+	}
+	return v, nil
+}
+
+// SimFunc similitud entre dos películas, en [0,1] (0 = nada en común).
+type SimFunc func(a, b int) float64
+
+// MMR re-ordena los candidatos (ordenados por relevancia) con maximal
+// marginal relevance: en cada paso elige el que maximiza
+//
+//	lambda * rel(i) - (1-lambda) * max_{j elegido} sim(i, j)
+//
+// Las relevancias se llevan a [0,1] (min-max) para que lambda signifique
+// lo mismo con cualquier algoritmo. Devuelve los índices de los k elegidos.
+func MMR(ids []int, scores []float64, k int, lambda float64, sim SimFunc) []int {
+	n := len(ids)
+	if k > n {
+		k = n
+	}
+	byID := make(map[int]float64, n)
+	for i, id := range ids {
+		byID[id] = scores[i]
+	}
+	rel := MinMaxNormalize(byID)
+
+	// maxSim[i] = similitud máxima de i con lo ya elegido
+	maxSim := make([]float64, n)
+	picked := make([]bool, n)
+	out := make([]int, 0, k)
+
+	for len(out) < k {
+		best, bestVal := -1, 0.0
+		for i := 0; i < n; i++ {
+			if picked[i] {
+				continue
+			}
+			v := lambda*rel[ids[i]] - (1-lambda)*maxSim[i]
+			if best < 0 || v > bestVal {
+				best, bestVal = i, v
+			}
+		}
+		picked[best] = true
+		out = append(out, best)
+
+		for i := 0; i < n; i++ {
+			if picked[i] {
+				continue
+			}
+			if s := sim(ids[best], ids[i]); s > maxSim[i] {
+				maxSim[i] = s
+			}
+		}
+	}
+	return out
+}
+
+// IntraListDiversity 1 - similitud media entre todos los pares de la lista
+// (1 = nada en común, 0 = todas iguales). Con menos de dos ítems es 0.
+func IntraListDiversity(ids []int, sim SimFunc) float64 {
+	if len(ids) < 2 {
+		return 0
+	}
+	var sum float64
+	var pairs int
+	for i := 0; i < len(ids); i++ {
+		for j := i + 1; j < len(ids); j++ {
+			sum += sim(ids[i], ids[j])
+			pairs++
+		}
+	}
+	return 1 - sum/float64(pairs)
+}
@@ -0,0 +1,61 @@
+package ml
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"nodosml-pc4/internal/models"
+)
+
+// Fuentes de vectores de ítems para el índice de vecinos aproximados.
+const (
+	EmbeddingMF     = "mf"     // factores de ítem del modelo de factorización
+	EmbeddingGenome = "genome" // relevancia de cada genome tag
+
+	DefaultEmbedding = EmbeddingMF
+)
+
+// ParseEmbeddingSource valida la fuente ("" = DefaultEmbedding).
+func ParseEmbeddingSource(s string) (string, error) {
+	switch s {
+	case "":
+		return DefaultEmbedding, nil
+	case EmbeddingMF, EmbeddingGenome:
+		return s, nil
+	}
+	return "", fmt.Errorf("fuente de embeddings desconocida %q (mf | genome)", s)
+}
+
+// GenomeEmbeddings vectores densos de genome tags: una dimensión por tag
+// del vocabulario (orden alfabético) con su relevancia. Las películas sin
+// tags quedan fuera.
+func GenomeEmbeddings(movies []models.MovieDoc) (ids []int, vecs [][]float32) {
+	dimOf := make(map[string]int)
+	for _, m := range movies {
+		for _, t := range m.GenomeTags {
+			dimOf[strings.ToLower(t.Tag)] = 0
+		}
+	}
+	vocab := make([]string, 0, len(dimOf))
+	for tag := range dimOf {
+		vocab = append(vocab, tag)
+	}
+	sort.Strings(vocab)
+	for i, tag := range vocab {
+		dimOf[tag] = i
+	}
+
+	for _, m := range movies {
+		if len(m.GenomeTags) == 0 {
+			continue
+		}
+		v := make([]float32, len(vocab))
+		for _, t := range m.GenomeTags {
+			v[dimOf[strings.ToLower(t.Tag)]] = float32(t.Relevance)
+		}
+		ids = append(ids, m.MovieID)
+		vecs = append(vecs, v)
+	}
+	return ids, vecs
+}
@@ -0,0 +1,511 @@
+package ml
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// HNSWParams parámetros del índice de vecinos aproximados (HNSW).
+type HNSWParams struct {
+	M              int   `json:"m"`              // vecinos por nodo en las capas altas (2M en la capa 0)
+	EfConstruction int   `json:"efConstruction"` // candidatos explorados al insertar
+	EfSearch       int   `json:"efSearch"`       // candidatos explorados al buscar
+	Seed           int64 `json:"seed"`
+}
+
+// DefaultHNSWParams recall@20 > 0.95 con 20k vectores de 32 dimensiones
+// (incluso sin estructura de clusters).
+var DefaultHNSWParams = HNSWParams{
+	M:              16,
+	EfConstruction: 100,
+	EfSearch:       64,
+	Seed:           42,
+}
+
+// WithDefaults completa los campos en cero con DefaultHNSWParams.
+func (p HNSWParams) WithDefaults() HNSWParams {
+	d := DefaultHNSWParams
+	if p.M > 0 {
+		d.M = p.M
+	}
+	if p.EfConstruction > 0 {
+		d.EfConstruction = p.EfConstruction
+	}
+	if p.EfSearch > 0 {
+		d.EfSearch = p.EfSearch
+	}
+	if p.Seed != 0 {
+		d.Seed = p.Seed
+	}
+	return d
+}
+
+// HNSW índice de vecinos aproximados por similitud coseno (Hierarchical
+// Navigable Small World). Los vectores se guardan normalizados, así la
+// distancia es 1 - producto punto. Se arma con Add desde una sola
+// goroutine; ya armado, las búsquedas se pueden hacer en paralelo.
+//
+// Los campos exportados son los que se persisten (Save / LoadHNSW).
+type HNSW struct {
+	Params   HNSWParams
+	Dim      int
+	IDs      []int       // id externo (movieId) de cada nodo
+	Vecs     []float32   // vectores normalizados, len(IDs)*Dim
+	Links    [][][]int32 // nodo -> capa -> vecinos
+	Entry    int32       // punto de entrada (-1 = vacío)
+	MaxLevel int
+
+	pos map[int]int32 // id externo -> nodo
+	rng *rand.Rand
+	// marcas de visitado reutilizables entre búsquedas
+	visited sync.Pool
+}
+
+// visitSet marca de nodos visitados por época: limpiar es subir epoch.
+type visitSet struct {
+	epoch uint32
+	marks []uint32
+}
+
+func (h *HNSW) getVisited() *visitSet {
+	v, _ := h.visited.Get().(*visitSet)
+	if v == nil {
+		v = &visitSet{}
+	}
+	if len(v.marks) < len(h.IDs) {
+		v.marks = make([]uint32, len(h.IDs)+len(h.IDs)/2+64)
+		v.epoch = 0
+	}
+	v.epoch++
+	if v.epoch == 0 { // dio la vuelta
+		clear(v.marks)
+		v.epoch = 1
+	}
+	return v
+}
+
+// visit marca n y devuelve si ya estaba marcado.
+func (v *visitSet) visit(n int32) bool {
+	if v.marks[n] == v.epoch {
+		return true
+	}
+	v.marks[n] = v.epoch
+	return false
+}
+
+// NewHNSW crea un índice vacío para vectores de dim dimensiones.
+func NewHNSW(dim int, p HNSWParams) *HNSW {
+	h := &HNSW{Params: p.WithDefaults(), Dim: dim, Entry: -1}
+	h.init()
+	return h
+}
+
+func (h *HNSW) init() {
+	h.pos = make(map[int]int32, len(h.IDs))
+	for i, id := range h.IDs {
+		h.pos[id] = int32(i)
+	}
+	h.rng = rand.New(rand.NewSource(h.Params.Seed + int64(len(h.IDs))))
+}
+
+// Len cantidad de vectores indexados.
+func (h *HNSW) Len() int { return len(h.IDs) }
+
+// Has indica si el id está indexado.
+func (h *HNSW) Has(id int) bool {
+	_, ok := h.pos[id]
+	return ok
+}
+
+// ApproxBytes estimación de memoria: vectores + listas de vecinos + mapa.
+func (h *HNSW) ApproxBytes() int64 {
+	b := int64(len(h.Vecs))*4 + int64(len(h.IDs))*8 + int64(len(h.pos))*16
+	for _, levels := range h.Links {
+		for _, l := range levels {
+			b += int64(cap(l))*4 + 24
+		}
+	}
+	return b
+}
+
+func (h *HNSW) vec(n int32) []float32 {
+	return h.Vecs[int(n)*h.Dim : (int(n)+1)*h.Dim]
+}
+
+func dot32(a, b []float32) float32 {
+	var s float32
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
+
+// normalized copia de v con norma 1 (nil si es el vector cero).
+func normalized(v []float32) []float32 {
+	var sq float64
+	for _, x := range v {
+		sq += float64(x) * float64(x)
+	}
+	if sq == 0 {
+		return nil
+	}
+	inv := float32(1 / math.Sqrt(sq))
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = x * inv
+	}
+	return out
+}
+
+func (h *HNSW) distTo(q []float32, n int32) float32 {
+	return 1 - dot32(q, h.vec(n))
+}
+
+func (h *HNSW) maxLinks(level int) int {
+	if level == 0 {
+		return 2 * h.Params.M
+	}
+	return h.Params.M
+}
+
+func (h *HNSW) randomLevel() int {
+	mult := 1 / math.Log(float64(h.Params.M))
+	return int(-math.Log(1-h.rng.Float64()) * mult)
+}
+
+// Add indexa el vector de id. Devuelve false si el id ya estaba, si la
+// dimensión no coincide o si el vector es cero (sin dirección no hay coseno).
+func (h *HNSW) Add(id int, v []float32) bool {
+	if len(v) != h.Dim || h.Has(id) {
+		return false
+	}
+	q := normalized(v)
+	if q == nil {
+		return false
+	}
+
+	n := int32(len(h.IDs))
+	level := h.randomLevel()
+	h.IDs = append(h.IDs, id)
+	h.Vecs = append(h.Vecs, q...)
+	h.Links = append(h.Links, make([][]int32, level+1))
+	h.pos[id] = n
+
+	if h.Entry < 0 {
+		h.Entry, h.MaxLevel = n, level
+		return true
+	}
+
+	// bajada golosa por las capas más altas que el nivel del nodo
+	ep := h.Entry
+	for l := h.MaxLevel; l > level; l-- {
+		ep = h.greedy(q, ep, l)
+	}
+
+	eps := []distItem{{node: ep, dist: h.distTo(q, ep)}}
+	for l := min(level, h.MaxLevel); l >= 0; l-- {
+		cands := h.searchLayer(q, eps, h.Params.EfConstruction, l)
+		neighbors := h.selectNeighbors(cands, h.Params.M)
+		h.Links[n][l] = neighbors
+
+		for _, e := range neighbors {
+			links := append(h.Links[e][l], n)
+			if len(links) > h.maxLinks(l) {
+				links = h.shrink(e, links, h.maxLinks(l))
+			}
+			h.Links[e][l] = links
+		}
+		eps = cands
+	}
+
+	if level > h.MaxLevel {
+		h.Entry, h.MaxLevel = n, level
+	}
+	return true
+}
+
+// shrink recorta los vecinos de e a max con la misma heurística de la
+// inserción.
+func (h *HNSW) shrink(e int32, links []int32, max int) []int32 {
+	ve := h.vec(e)
+	cands := make([]distItem, len(links))
+	for i, c := range links {
+		cands[i] = distItem{node: c, dist: 1 - dot32(ve, h.vec(c))}
+	}
+	sort.Slice(cands, func(i, j int) bool { return cands[i].dist < cands[j].dist })
+	return h.selectNeighbors(cands, max)
+}
+
+// selectNeighbors heurística de HNSW: un candidato entra si está más cerca
+// del nodo que de los ya elegidos (así los vecinos cubren direcciones
+// distintas); si faltan, se completa con los descartados más cercanos.
+// cands viene ordenado por distancia ascendente.
+func (h *HNSW) selectNeighbors(cands []distItem, m int) []int32 {
+	out := make([]int32, 0, m)
+	var pruned []int32
+	for _, c := range cands {
+		if len(out) >= m {
+			break
+		}
+		vc := h.vec(c.node)
+		keep := true
+		for _, s := range out {
+			if 1-dot32(vc, h.vec(s)) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			out = append(out, c.node)
+		} else {
+			pruned = append(pruned, c.node)
+		}
+	}
+	for _, p := range pruned {
+		if len(out) >= m {
+			break
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// greedy avanza en la capa l hacia el nodo más cercano a q.
+func (h *HNSW) greedy(q []float32, ep int32, l int) int32 {
+	best := h.distTo(q, ep)
+	for changed := true; changed; {
+		changed = false
+		for _, c := range h.Links[ep][l] {
+			if d := h.distTo(q, c); d < best {
+				best, ep, changed = d, c, true
+			}
+		}
+	}
+	return ep
+}
+
+// searchLayer búsqueda en anchura acotada a ef en la capa l. Devuelve los
+// ef más cercanos ordenados por distancia ascendente.
+func (h *HNSW) searchLayer(q []float32, eps []distItem, ef, l int) []distItem {
+	visited := h.getVisited()
+	defer h.visited.Put(visited)
+
+	cands := distHeap{}            // min-heap: próximos a expandir
+	results := distHeap{max: true} // max-heap: los ef mejores
+	for _, e := range eps {
+		visited.visit(e.node)
+		cands.push(e)
+		results.push(e)
+		if len(results.items) > ef {
+			results.pop()
+		}
+	}
+
+	for len(cands.items) > 0 {
+		c := cands.pop()
+		if len(results.items) >= ef && c.dist > results.items[0].dist {
+			break
+		}
+		if l >= len(h.Links[c.node]) {
+			continue
+		}
+		for _, nb := range h.Links[c.node][l] {
+			if visited.visit(nb) {
+				continue
+			}
+			d := h.distTo(q, nb)
+			if len(results.items) < ef || d < results.items[0].dist {
+				cands.push(distItem{node: nb, dist: d})
+				results.push(distItem{node: nb, dist: d})
+				if len(results.items) > ef {
+					results.pop()
+				}
+			}
+		}
+	}
+
+	out := results.items
+	sort.Slice(out, func(i, j int) bool { return out[i].dist < out[j].dist })
+	return out
+}
+
+// Search los k vectores más similares a v (coseno) explorando ef
+// candidatos (0 = Params.EfSearch).
+func (h *HNSW) Search(v []float32, k, ef int) []ScoredItem {
+	q := normalized(v)
+	if q == nil || h.Entry < 0 || len(v) != h.Dim {
+		return nil
+	}
+	return h.search(q, k, ef, -1)
+}
+
+// SearchID los k vecinos de un id indexado (sin él mismo).
+func (h *HNSW) SearchID(id, k, ef int) []ScoredItem {
+	n, ok := h.pos[id]
+	if !ok {
+		return nil
+	}
+	return h.search(h.vec(n), k, ef, n)
+}
+
+func (h *HNSW) search(q []float32, k, ef int, skip int32) []ScoredItem {
+	if ef <= 0 {
+		ef = h.Params.EfSearch
+	}
+	want := k
+	if skip >= 0 {
+		want++
+	}
+	if ef < want {
+		ef = want
+	}
+
+	ep := h.Entry
+	for l := h.MaxLevel; l > 0; l-- {
+		ep = h.greedy(q, ep, l)
+	}
+	cands := h.searchLayer(q, []distItem{{node: ep, dist: h.distTo(q, ep)}}, ef, 0)
+
+	out := make([]ScoredItem, 0, k)
+	for _, c := range cands {
+		if c.node == skip {
+			continue
+		}
+		if len(out) >= k {
+			break
+		}
+		out = append(out, ScoredItem{MovieID: h.IDs[c.node], Sim: float64(1 - c.dist)})
+	}
+	return out
+}
+
+// ExactID los k vecinos de un id recorriendo todo el índice (fuerza bruta);
+// es la referencia para medir el recall.
+func (h *HNSW) ExactID(id, k int) []ScoredItem {
+	n, ok := h.pos[id]
+	if !ok {
+		return nil
+	}
+	q := h.vec(n)
+	out := make([]ScoredItem, 0, len(h.IDs))
+	for i := range h.IDs {
+		if int32(i) == n {
+			continue
+		}
+		out = append(out, ScoredItem{MovieID: h.IDs[i], Sim: float64(dot32(q, h.vec(int32(i))))})
+	}
+	return TopK(out, k)
+}
+
+// Recall fracción de los k vecinos exactos que devuelve la búsqueda
+// aproximada, promediada sobre una muestra de sample ids.
+func (h *HNSW) Recall(sample, k, ef int, seed int64) float64 {
+	if len(h.IDs) < 2 || k <= 0 {
+		return 0
+	}
+	if sample <= 0 || sample > len(h.IDs) {
+		sample = len(h.IDs)
+	}
+	perm := rand.New(rand.NewSource(seed)).Perm(len(h.IDs))[:sample]
+
+	var total float64
+	for _, n := range perm {
+		id := h.IDs[n]
+		exact := h.ExactID(id, k)
+		if len(exact) == 0 {
+			continue
+		}
+		want := make(map[int]bool, len(exact))
+		for _, s := range exact {
+			want[s.MovieID] = true
+		}
+		hits := 0
+		for _, s := range h.SearchID(id, k, ef) {
+			if want[s.MovieID] {
+				hits++
+			}
+		}
+		total += float64(hits) / float64(len(exact))
+	}
+	return total / float64(sample)
+}
+
+// Save escribe el índice (gob).
+func (h *HNSW) Save(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(h)
+}
+
+// LoadHNSW lee un índice escrito con Save.
+func LoadHNSW(r io.Reader) (*HNSW, error) {
+	var h HNSW
+	if err := gob.NewDecoder(r).Decode(&h); err != nil {
+		return nil, err
+	}
+	if h.Dim <= 0 || len(h.Vecs) != len(h.IDs)*h.Dim || len(h.Links) != len(h.IDs) {
+		return nil, errors.New("índice HNSW corrupto")
+	}
+	if len(h.IDs) == 0 {
+		h.Entry = -1
+	}
+	h.init()
+	return &h, nil
+}
+
+// distItem nodo candidato con su distancia a la consulta.
+type distItem struct {
+	node int32
+	dist float32
+}
+
+// distHeap heap binario de candidatos por distancia (min-heap, o max-heap
+// con max). Tipado a mano: container/heap con interfaces pesa en el armado.
+type distHeap struct {
+	items []distItem
+	max   bool
+}
+
+func (d *distHeap) less(i, j int) bool {
+	if d.max {
+		return d.items[i].dist > d.items[j].dist
+	}
+	return d.items[i].dist < d.items[j].dist
+}
+
+func (d *distHeap) push(x distItem) {
+	d.items = append(d.items, x)
+	for i := len(d.items) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !d.less(i, parent) {
+			break
+		}
+		d.items[i], d.items[parent] = d.items[parent], d.items[i]
+		i = parent
+	}
+}
+
+func (d *distHeap) pop() distItem {
+	top := d.items[0]
+	last := len(d.items) - 1
+	d.items[0] = d.items[last]
+	d.items = d.items[:last]
+	for i := 0; ; {
+		l, r, best := 2*i+1, 2*i+2, i
+		if l < last && d.less(l, best) {
+			best = l
+		}
+		if r < last && d.less(r, best) {
+			best = r
+		}
+		if best == i {
+			break
+		}
+		d.items[i], d.items[best] = d.items[best], d.items[i]
+		i = best
+	}
+	return top
+}
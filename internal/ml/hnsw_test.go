@@ -0,0 +1,80 @@
+package ml
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// randomHNSW índice sobre n vectores gaussianos de dim dimensiones (sin
+// estructura de clusters, el caso difícil para el recall).
+func randomHNSW(n, dim int, seed int64) *HNSW {
+	rng := rand.New(rand.NewSource(seed))
+	h := NewHNSW(dim, HNSWParams{Seed: seed})
+	v := make([]float32, dim)
+	for i := 0; i < n; i++ {
+		for k := range v {
+			v[k] = float32(rng.NormFloat64())
+		}
+		h.Add(1000+i, v)
+	}
+	return h
+}
+
+func TestHNSWRecall(t *testing.T) {
+	h := randomHNSW(3000, 16, 5)
+	if h.Len() != 3000 {
+		t.Fatalf("Len = %d, want 3000", h.Len())
+	}
+
+	// la referencia exacta viene ordenada y sin el propio id
+	exact := h.ExactID(1000, 10)
+	if len(exact) != 10 {
+		t.Fatalf("ExactID devolvió %d vecinos, want 10", len(exact))
+	}
+	for i, s := range exact {
+		if s.MovieID == 1000 {
+			t.Fatal("ExactID incluye al propio id")
+		}
+		if i > 0 && s.Sim > exact[i-1].Sim {
+			t.Fatalf("ExactID desordenado en la posición %d", i)
+		}
+	}
+
+	if r := h.Recall(200, 10, 0, 1); r < 0.9 {
+		t.Fatalf("recall@10 = %.3f, want >= 0.9", r)
+	}
+}
+
+func TestHNSWSaveLoad(t *testing.T) {
+	h := randomHNSW(500, 8, 9)
+
+	var buf bytes.Buffer
+	if err := h.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadHNSW(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Len() != h.Len() || !got.Has(1000) || got.Has(1) {
+		t.Fatalf("índice cargado con %d vectores, want %d", got.Len(), h.Len())
+	}
+
+	// mismas búsquedas, mismos resultados
+	for _, id := range []int{1000, 1123, 1499} {
+		want, have := h.SearchID(id, 10, 0), got.SearchID(id, 10, 0)
+		if len(want) != len(have) {
+			t.Fatalf("id %d: %d vecinos tras cargar, want %d", id, len(have), len(want))
+		}
+		for i := range want {
+			if want[i] != have[i] {
+				t.Fatalf("id %d posición %d: %+v, want %+v", id, i, have[i], want[i])
+			}
+		}
+	}
+
+	if _, err := LoadHNSW(bytes.NewReader([]byte("basura"))); err == nil {
+		t.Fatal("LoadHNSW aceptó datos inválidos")
+	}
+}
@@ -0,0 +1,142 @@
+package ml
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultHybridWeights pesos del modo híbrido si la petición no trae.
+var DefaultHybridWeights = map[string]float64{
+	AlgoItemKNN: 0.6,
+	AlgoContent: 0.4,
+}
+
+// ParseWeights lee pesos "item-knn:0.6,content:0.4,mf:0.2" ("" = default).
+// Solo se aceptan fuentes conocidas y pesos >= 0 con alguno positivo.
+func ParseWeights(s string) (map[string]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		out := make(map[string]float64, len(DefaultHybridWeights))
+		for k, v := range DefaultHybridWeights {
+			out[k] = v
+		}
+		return out, nil
+	}
+
+	out := make(map[string]float64)
+	var total float64
+	for _, part := range strings.Split(s, ",") {
+		name, val, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("peso inválido %q (se espera fuente:peso)", part)
+		}
+		src, err := ParseAlgo(strings.TrimSpace(name))
+		if err != nil || src == AlgoHybrid {
+			return nil, fmt.Errorf("fuente desconocida %q (item-knn | mf | content)", name)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("peso inválido para %s: %q", src, val)
+		}
+		out[src] = w
+		total += w
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("al menos un peso debe ser positivo")
+	}
+	return out, nil
+}
+
+// FormatWeights representación canónica (ordenada) de los pesos, para
+// claves de caché y logs.
+func FormatWeights(w map[string]float64) string {
+	keys := make([]string, 0, len(w))
+	for k := range w {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + ":" + strconv.FormatFloat(w[k], 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// MinMaxNormalize lleva los scores de una fuente a [0,1]. Si todos son
+// iguales valen 1 (la fuente no distingue entre ellos).
+func MinMaxNormalize(scores map[int]float64) map[int]float64 {
+	out := make(map[int]float64, len(scores))
+	if len(scores) == 0 {
+		return out
+	}
+	lo, hi := 0.0, 0.0
+	first := true
+	for _, s := range scores {
+		if first || s < lo {
+			lo = s
+		}
+		if first || s > hi {
+			hi = s
+		}
+		first = false
+	}
+	for id, s := range scores {
+		if hi > lo {
+			out[id] = (s - lo) / (hi - lo)
+		} else {
+			out[id] = 1
+		}
+	}
+	return out
+}
+
+// Blended ítem del ranking híbrido con el aporte de cada fuente
+// (peso * score normalizado).
+type Blended struct {
+	MovieID int
+	Score   float64
+	Sources map[string]float64
+}
+
+// Blend normaliza cada fuente con min-max y suma los aportes ponderados,
+// dividiendo por la suma de pesos de las fuentes presentes. Un ítem que
+// una fuente no devolvió aporta 0 desde esa fuente.
+func Blend(sources map[string]map[int]float64, weights map[string]float64) []Blended {
+	var total float64
+	for src := range sources {
+		total += weights[src]
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	byID := make(map[int]*Blended)
+	for src, scores := range sources {
+		w := weights[src] / total
+		if w <= 0 {
+			continue
+		}
+		for id, s := range MinMaxNormalize(scores) {
+			b := byID[id]
+			if b == nil {
+				b = &Blended{MovieID: id, Sources: make(map[string]float64, len(sources))}
+				byID[id] = b
+			}
+			b.Sources[src] = w * s
+			b.Score += w * s
+		}
+	}
+
+	out := make([]Blended, 0, len(byID))
+	for _, b := range byID {
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].MovieID < out[j].MovieID
+	})
+	return out
+}
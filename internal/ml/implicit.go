@@ -0,0 +1,82 @@
+package ml
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"nodosml-pc4/internal/models"
+)
+
+// DefaultImplicitWeights rating equivalente (escala 0.5-5) de cada tipo de
+// evento cuando la película no tiene rating explícito. 0 = no se usa.
+var DefaultImplicitWeights = map[string]float64{
+	models.EventView:      3.0,
+	models.EventClick:     3.5,
+	models.EventWatchlist: 4.0,
+}
+
+// ParseImplicitWeights "view:3,click:3.5,watchlist:4" ("" = default; los
+// tipos que no aparecen valen 0). dismiss no es configurable: siempre
+// excluye.
+func ParseImplicitWeights(s string) (map[string]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		out := make(map[string]float64, len(DefaultImplicitWeights))
+		for t, w := range DefaultImplicitWeights {
+			out[t] = w
+		}
+		return out, nil
+	}
+
+	out := make(map[string]float64)
+	for _, part := range strings.Split(s, ",") {
+		typ, val, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("peso implícito inválido %q (formato tipo:peso)", part)
+		}
+		typ = strings.TrimSpace(typ)
+		if _, known := DefaultImplicitWeights[typ]; !known {
+			return nil, fmt.Errorf("tipo de evento desconocido %q (view | click | watchlist)", typ)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil || w < 0 || w > 5 {
+			return nil, fmt.Errorf("peso de %s inválido %q (0..5)", typ, val)
+		}
+		out[typ] = w
+	}
+	return out, nil
+}
+
+// ImplicitRatings convierte eventos en pseudo-ratings para las películas
+// sin rating explícito: cada una vale el mayor peso entre sus eventos. Las
+// descartadas no cuentan. El resultado sale ordenado por movieId.
+func ImplicitRatings(events []models.EventDoc, explicit []models.RatingDoc, dismissed map[int]bool, weights map[string]float64) []models.RatingDoc {
+	rated := make(map[int]bool, len(explicit))
+	for _, r := range explicit {
+		rated[r.MovieID] = true
+	}
+
+	best := make(map[int]models.RatingDoc)
+	for _, ev := range events {
+		w := weights[ev.Type]
+		if w <= 0 || rated[ev.MovieID] || dismissed[ev.MovieID] {
+			continue
+		}
+		if cur, ok := best[ev.MovieID]; !ok || w > cur.Rating {
+			best[ev.MovieID] = models.RatingDoc{
+				UserID:    ev.UserID,
+				MovieID:   ev.MovieID,
+				Rating:    w,
+				Timestamp: ev.CreatedAt.Unix(),
+			}
+		}
+	}
+
+	out := make([]models.RatingDoc, 0, len(best))
+	for _, r := range best {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].MovieID < out[j].MovieID })
+	return out
+}
@@ -0,0 +1,127 @@
+package ml
+
+import "math"
+
+// Métricas de evaluación offline. En las de ranking, recs es la lista
+// recomendada (en orden) y relevant las películas relevantes del conjunto
+// de test del usuario.
+
+// ErrorStats acumula errores de predicción para RMSE y MAE.
+type ErrorStats struct {
+	sse, sae float64
+	n        int
+}
+
+func (e *ErrorStats) Add(pred, actual float64) {
+	d := pred - actual
+	e.sse += d * d
+	e.sae += math.Abs(d)
+	e.n++
+}
+
+func (e *ErrorStats) N() int { return e.n }
+
+func (e *ErrorStats) RMSE() float64 {
+	if e.n == 0 {
+		return 0
+	}
+	return math.Sqrt(e.sse / float64(e.n))
+}
+
+func (e *ErrorStats) MAE() float64 {
+	if e.n == 0 {
+		return 0
+	}
+	return e.sae / float64(e.n)
+}
+
+// PrecisionAtK fracción de las primeras k recomendaciones que son relevantes.
+func PrecisionAtK(recs []int, relevant map[int]bool, k int) float64 {
+	if k <= 0 {
+		return 0
+	}
+	return float64(hitsAtK(recs, relevant, k)) / float64(k)
+}
+
+// RecallAtK fracción de las relevantes que aparecen en las primeras k.
+func RecallAtK(recs []int, relevant map[int]bool, k int) float64 {
+	if len(relevant) == 0 {
+		return 0
+	}
+	return float64(hitsAtK(recs, relevant, k)) / float64(len(relevant))
+}
+
+// NDCGAtK ganancia acumulada descontada (relevancia binaria) normalizada
+// por la del ranking ideal.
+func NDCGAtK(recs []int, relevant map[int]bool, k int) float64 {
+	var dcg float64
+	for i, id := range firstK(recs, k) {
+		if relevant[id] {
+			dcg += 1 / math.Log2(float64(i+2))
+		}
+	}
+	var idcg float64
+	for i := 0; i < k && i < len(relevant); i++ {
+		idcg += 1 / math.Log2(float64(i+2))
+	}
+	if idcg == 0 {
+		return 0
+	}
+	return dcg / idcg
+}
+
+// AveragePrecisionAtK promedio de la precisión en cada acierto (el MAP es
+// el promedio de esto entre usuarios).
+func AveragePrecisionAtK(recs []int, relevant map[int]bool, k int) float64 {
+	var hits int
+	var sum float64
+	for i, id := range firstK(recs, k) {
+		if relevant[id] {
+			hits++
+			sum += float64(hits) / float64(i+1)
+		}
+	}
+	denom := len(relevant)
+	if denom > k {
+		denom = k
+	}
+	if denom == 0 {
+		return 0
+	}
+	return sum / float64(denom)
+}
+
+// Novelty auto-información media de la lista: -log2(popularidad), con
+// popularidad = fracción de usuarios que valoraron la película. Películas
+// sin datos cuentan como vistas por un solo usuario.
+func Novelty(recs []int, raters map[int]int, users int) float64 {
+	if len(recs) == 0 || users == 0 {
+		return 0
+	}
+	var sum float64
+	for _, id := range recs {
+		n := raters[id]
+		if n <= 0 {
+			n = 1
+		}
+		sum += -math.Log2(float64(n) / float64(users))
+	}
+	return sum / float64(len(recs))
+}
+
+func firstK(recs []int, k int) []int {
+	if k >= 0 && len(recs) > k {
+		return recs[:k]
+	}
+	return recs
+}
+
+func hitsAtK(recs []int, relevant map[int]bool, k int) int {
+	var hits int
+	for _, id := range firstK(recs, k) {
+		if relevant[id] {
+			hits++
+		}
+	}
+	return hits
+}
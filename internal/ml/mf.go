@@ -0,0 +1,259 @@
+package ml
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+)
+
+// MFParams hiperparámetros de la factorización (SGD sobre el modelo con
+// sesgos: r_ui ~ mu + b_u + b_i + p_u·q_i).
+type MFParams struct {
+	Factors      int     `json:"factors"`
+	Epochs       int     `json:"epochs"`
+	LearningRate float64 `json:"learningRate"`
+	Reg          float64 `json:"reg"`
+	Seed         int64   `json:"seed"`
+}
+
+// DefaultMFParams valores razonables para MovieLens.
+var DefaultMFParams = MFParams{
+	Factors:      32,
+	Epochs:       20,
+	LearningRate: 0.007,
+	Reg:          0.02,
+	Seed:         42,
+}
+
+// WithDefaults completa los campos en cero con DefaultMFParams.
+func (p MFParams) WithDefaults() MFParams {
+	d := DefaultMFParams
+	if p.Factors > 0 {
+		d.Factors = p.Factors
+	}
+	if p.Epochs > 0 {
+		d.Epochs = p.Epochs
+	}
+	if p.LearningRate > 0 {
+		d.LearningRate = p.LearningRate
+	}
+	if p.Reg > 0 {
+		d.Reg = p.Reg
+	}
+	if p.Seed != 0 {
+		d.Seed = p.Seed
+	}
+	return d
+}
+
+// Triplet un rating con índices densos (uIdx, iIdx). float32/int32 para
+// que 25M ratings entren en ~300MB.
+type Triplet struct {
+	U, I int32
+	R    float32
+}
+
+// ItemFactors la parte del modelo que necesita quien sirve
+// recomendaciones: sesgos y factores de ítem. Las filas son iIdx.
+type ItemFactors struct {
+	GlobalMean float64
+	Factors    int
+	Bias       []float32 // len = filas
+	Q          []float32 // filas*Factors
+}
+
+// Rows cantidad de filas (ítems) del modelo.
+func (f *ItemFactors) Rows() int { return len(f.Bias) }
+
+// Vec factores del ítem de la fila i.
+func (f *ItemFactors) Vec(i int) []float32 {
+	return f.Q[i*f.Factors : (i+1)*f.Factors]
+}
+
+// Score predicción para el ítem i con el usuario (bu, pu).
+func (f *ItemFactors) Score(i int, bu float64, pu []float64) float64 {
+	s := f.GlobalMean + bu + float64(f.Bias[i])
+	q := f.Vec(i)
+	for k := range pu {
+		s += pu[k] * float64(q[k])
+	}
+	return s
+}
+
+// FoldIn calcula el sesgo y los factores de un usuario a partir de sus
+// ratings (fila del ítem -> rating) con los factores de ítem fijos. Es un
+// paso de ALS: ridge sobre z_i = [1, q_i] con objetivo r - mu - b_i. Así
+// el usuario refleja sus ratings actuales aunque sean posteriores al
+// entrenamiento.
+func (f *ItemFactors) FoldIn(rated map[int]float64, reg float64) (float64, []float64, error) {
+	d := f.Factors + 1
+	a := make([]float64, d*d)
+	b := make([]float64, d)
+	z := make([]float64, d)
+
+	for i, r := range rated {
+		if i < 0 || i >= f.Rows() {
+			continue
+		}
+		z[0] = 1
+		for k, v := range f.Vec(i) {
+			z[k+1] = float64(v)
+		}
+		y := r - f.GlobalMean - float64(f.Bias[i])
+		for p := 0; p < d; p++ {
+			b[p] += z[p] * y
+			for q := 0; q <= p; q++ {
+				a[p*d+q] += z[p] * z[q]
+			}
+		}
+	}
+	// regularización proporcional a la cantidad de ratings, como en SGD
+	lambda := reg * float64(len(rated))
+	if lambda <= 0 {
+		lambda = reg
+	}
+	for p := 0; p < d; p++ {
+		a[p*d+p] += lambda
+		for q := p + 1; q < d; q++ {
+			a[p*d+q] = a[q*d+p]
+		}
+	}
+
+	x, err := solveSPD(a, b, d)
+	if err != nil {
+		return 0, nil, err
+	}
+	return x[0], x[1:], nil
+}
+
+// MF modelo completo (usuarios + ítems) que produce el entrenamiento.
+type MF struct {
+	ItemFactors
+	UserBias []float32 // filas = uIdx
+	P        []float32 // usuarios*Factors
+}
+
+// UserVec factores del usuario de la fila u.
+func (m *MF) UserVec(u int) []float32 {
+	return m.P[u*m.Factors : (u+1)*m.Factors]
+}
+
+// Predict predicción del modelo entrenado para (uIdx, iIdx).
+func (m *MF) Predict(u, i int) float64 {
+	s := m.GlobalMean + float64(m.UserBias[u]) + float64(m.Bias[i])
+	pu, qi := m.UserVec(u), m.Vec(i)
+	for k := range pu {
+		s += float64(pu[k] * qi[k])
+	}
+	return s
+}
+
+// TrainSGD entrena el modelo. data se baraja en cada época (in place).
+// onEpoch (opcional) recibe el RMSE de entrenamiento de cada época.
+func TrainSGD(data []Triplet, nUsers, nItems int, p MFParams, onEpoch func(epoch int, rmse float64)) *MF {
+	p = p.WithDefaults()
+	rng := rand.New(rand.NewSource(p.Seed))
+	F := p.Factors
+
+	m := &MF{
+		ItemFactors: ItemFactors{
+			Factors: F,
+			Bias:    make([]float32, nItems),
+			Q:       make([]float32, nItems*F),
+		},
+		UserBias: make([]float32, nUsers),
+		P:        make([]float32, nUsers*F),
+	}
+	for i := range m.Q {
+		m.Q[i] = float32(rng.NormFloat64() * 0.1)
+	}
+	for i := range m.P {
+		m.P[i] = float32(rng.NormFloat64() * 0.1)
+	}
+
+	var sum float64
+	for _, t := range data {
+		sum += float64(t.R)
+	}
+	if len(data) > 0 {
+		m.GlobalMean = sum / float64(len(data))
+	}
+
+	lr, reg := float32(p.LearningRate), float32(p.Reg)
+	mu := float32(m.GlobalMean)
+
+	for epoch := 1; epoch <= p.Epochs; epoch++ {
+		rng.Shuffle(len(data), func(i, j int) { data[i], data[j] = data[j], data[i] })
+
+		var sse float64
+		for _, t := range data {
+			u, i := int(t.U), int(t.I)
+			pu := m.P[u*F : (u+1)*F]
+			qi := m.Q[i*F : (i+1)*F]
+
+			pred := mu + m.UserBias[u] + m.Bias[i]
+			for k := 0; k < F; k++ {
+				pred += pu[k] * qi[k]
+			}
+			e := t.R - pred
+			sse += float64(e * e)
+
+			m.UserBias[u] += lr * (e - reg*m.UserBias[u])
+			m.Bias[i] += lr * (e - reg*m.Bias[i])
+			for k := 0; k < F; k++ {
+				puk, qik := pu[k], qi[k]
+				pu[k] += lr * (e*qik - reg*puk)
+				qi[k] += lr * (e*puk - reg*qik)
+			}
+		}
+
+		if onEpoch != nil && len(data) > 0 {
+			onEpoch(epoch, math.Sqrt(sse/float64(len(data))))
+		}
+		lr *= 0.95
+	}
+	return m
+}
+
+var errNotSPD = errors.New("matriz no definida positiva")
+
+// solveSPD resuelve A x = b con Cholesky (A simétrica definida positiva,
+// d x d en orden de filas). A se sobreescribe.
+func solveSPD(a, b []float64, d int) ([]float64, error) {
+	for j := 0; j < d; j++ {
+		s := a[j*d+j]
+		for k := 0; k < j; k++ {
+			s -= a[j*d+k] * a[j*d+k]
+		}
+		if s <= 0 {
+			return nil, errNotSPD
+		}
+		a[j*d+j] = math.Sqrt(s)
+		for i := j + 1; i < d; i++ {
+			s := a[i*d+j]
+			for k := 0; k < j; k++ {
+				s -= a[i*d+k] * a[j*d+k]
+			}
+			a[i*d+j] = s / a[j*d+j]
+		}
+	}
+	// L y = b
+	y := make([]float64, d)
+	for i := 0; i < d; i++ {
+		s := b[i]
+		for k := 0; k < i; k++ {
+			s -= a[i*d+k] * y[k]
+		}
+		y[i] = s / a[i*d+i]
+	}
+	// L^T x = y
+	x := make([]float64, d)
+	for i := d - 1; i >= 0; i-- {
+		s := y[i]
+		for k := i + 1; k < d; k++ {
+			s -= a[k*d+i] * x[k]
+		}
+		x[i] = s / a[i*d+i]
+	}
+	return x, nil
+}
@@ -0,0 +1,130 @@
+package ml
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSolveSPD(t *testing.T) {
+	a := []float64{
+		4, 2, 0.6,
+		2, 5, 1,
+		0.6, 1, 3,
+	}
+	b := []float64{1, -2, 0.5}
+	orig := append([]float64(nil), a...)
+
+	x, err := solveSPD(a, b, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		var got float64
+		for j := 0; j < 3; j++ {
+			got += orig[i*3+j] * x[j]
+		}
+		if math.Abs(got-b[i]) > 1e-9 {
+			t.Fatalf("fila %d: A·x = %v, want %v", i, got, b[i])
+		}
+	}
+
+	notSPD := []float64{1, 2, 2, 1}
+	if _, err := solveSPD(notSPD, []float64{1, 1}, 2); err != errNotSPD {
+		t.Fatalf("err = %v, want errNotSPD", err)
+	}
+}
+
+// lowRankRatings ratings generados por un modelo con sesgos de rango f.
+func lowRankRatings(nUsers, nItems, f int, density float64, seed int64) []Triplet {
+	rng := rand.New(rand.NewSource(seed))
+	vec := func() []float64 {
+		v := make([]float64, f)
+		for k := range v {
+			v[k] = rng.NormFloat64() * 0.5
+		}
+		return v
+	}
+	users, items := make([][]float64, nUsers), make([][]float64, nItems)
+	for u := range users {
+		users[u] = vec()
+	}
+	for i := range items {
+		items[i] = vec()
+	}
+
+	var data []Triplet
+	for u := 0; u < nUsers; u++ {
+		for i := 0; i < nItems; i++ {
+			if rng.Float64() > density {
+				continue
+			}
+			r := 3.5
+			for k := 0; k < f; k++ {
+				r += users[u][k] * items[i][k]
+			}
+			data = append(data, Triplet{U: int32(u), I: int32(i), R: float32(r)})
+		}
+	}
+	return data
+}
+
+func TestTrainSGDLossDecreases(t *testing.T) {
+	data := lowRankRatings(200, 150, 4, 0.2, 7)
+
+	var rmse []float64
+	p := MFParams{Factors: 8, Epochs: 40, LearningRate: 0.03, Reg: 0.02, Seed: 1}
+	TrainSGD(data, 200, 150, p, func(_ int, e float64) { rmse = append(rmse, e) })
+
+	if len(rmse) != p.Epochs {
+		t.Fatalf("épocas reportadas = %d, want %d", len(rmse), p.Epochs)
+	}
+	first, last := rmse[0], rmse[len(rmse)-1]
+	if last >= first*0.6 {
+		t.Fatalf("RMSE no baja: primera época %.4f, última %.4f", first, last)
+	}
+
+	// misma semilla, mismo resultado
+	var again []float64
+	TrainSGD(lowRankRatings(200, 150, 4, 0.2, 7), 200, 150, p, func(_ int, e float64) { again = append(again, e) })
+	if again[len(again)-1] != last {
+		t.Fatalf("entrenamiento no determinista: %.6f vs %.6f", again[len(again)-1], last)
+	}
+}
+
+func TestFoldInRecoversUser(t *testing.T) {
+	const f = 3
+	rng := rand.New(rand.NewSource(3))
+	items := &ItemFactors{
+		GlobalMean: 3.5,
+		Factors:    f,
+		Bias:       make([]float32, 40),
+		Q:          make([]float32, 40*f),
+	}
+	for i := range items.Bias {
+		items.Bias[i] = float32(rng.NormFloat64() * 0.3)
+	}
+	for i := range items.Q {
+		items.Q[i] = float32(rng.NormFloat64())
+	}
+
+	// ratings exactos de un usuario conocido
+	bu, pu := 0.4, []float64{0.5, -0.3, 0.2}
+	rated := make(map[int]float64)
+	for i := 0; i < items.Rows(); i++ {
+		rated[i] = items.Score(i, bu, pu)
+	}
+
+	gotBu, gotPu, err := items.FoldIn(rated, 1e-6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(gotBu-bu) > 1e-3 {
+		t.Fatalf("sesgo = %.5f, want %.5f", gotBu, bu)
+	}
+	for k := range pu {
+		if math.Abs(gotPu[k]-pu[k]) > 1e-3 {
+			t.Fatalf("factor %d = %.5f, want %.5f", k, gotPu[k], pu[k])
+		}
+	}
+}
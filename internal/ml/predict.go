@@ -0,0 +1,198 @@
+package ml
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/models"
+)
+
+// Modos de predicción del item-kNN.
+const (
+	// ModeWeighted promedio ponderado simple: sum(sim*r) / sum(sim).
+	ModeWeighted = "weighted"
+	// ModeMeanCentered media del usuario + desvíos ponderados:
+	// mu_u + sum(sim*(r - mu_u)) / sum(sim).
+	ModeMeanCentered = "mean-centered"
+	// ModeBaseline predictor base (mu + b_u + b_i) + desvíos ponderados
+	// respecto al predictor base de cada vecino.
+	ModeBaseline = "baseline"
+)
+
+// DefaultMode modo cuando la petición no indica ninguno.
+const DefaultMode = ModeWeighted
+
+// ParseMode valida el modo ("" = DefaultMode).
+func ParseMode(s string) (string, error) {
+	switch s {
+	case "":
+		return DefaultMode, nil
+	case ModeWeighted, ModeMeanCentered, ModeBaseline:
+		return s, nil
+	}
+	return "", fmt.Errorf("modo de predicción desconocido %q (weighted | mean-centered | baseline)", s)
+}
+
+// Regularización de los sesgos (ver Koren, "Factor in the neighbors").
+const (
+	ItemBiasLambda = 25.0
+	UserBiasLambda = 10.0
+)
+
+// ItemStat suma y cantidad de ratings de una película.
+type ItemStat struct {
+	Sum   float64
+	Count int
+}
+
+// Baselines media global y sesgos de ítem del predictor base
+// b_ui = mu + b_u + b_i.
+type Baselines struct {
+	GlobalMean float64
+	ItemBias   map[int]float64
+}
+
+// NewBaselines calcula mu y b_i = sum(r - mu) / (lambda + n) a partir de
+// las sumas por película.
+func NewBaselines(stats map[int]ItemStat) *Baselines {
+	var sum float64
+	var n int
+	for _, st := range stats {
+		sum += st.Sum
+		n += st.Count
+	}
+	b := &Baselines{ItemBias: make(map[int]float64, len(stats))}
+	if n == 0 {
+		return b
+	}
+	b.GlobalMean = sum / float64(n)
+	for movieID, st := range stats {
+		b.ItemBias[movieID] = (st.Sum - b.GlobalMean*float64(st.Count)) / (ItemBiasLambda + float64(st.Count))
+	}
+	return b
+}
+
+// UserBias b_u = sum(r - mu - b_i) / (lambda + n) sobre todos los ratings
+// del usuario.
+func (b *Baselines) UserBias(ratings []models.RatingDoc) float64 {
+	var sum float64
+	for _, r := range ratings {
+		sum += r.Rating - b.GlobalMean - b.ItemBias[r.MovieID]
+	}
+	return sum / (UserBiasLambda + float64(len(ratings)))
+}
+
+// UserMean media simple de los ratings del usuario.
+func UserMean(ratings []models.RatingDoc) float64 {
+	if len(ratings) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, r := range ratings {
+		sum += r.Rating
+	}
+	return sum / float64(len(ratings))
+}
+
+// PredictParams lo que necesita KNNAccumulator para el modo elegido. Los
+// datos del usuario se calculan sobre TODOS sus ratings (el coordinador),
+// porque cada shard solo ve una parte.
+type PredictParams struct {
+	Mode      string
+	UserMean  float64    // mean-centered
+	UserBias  float64    // baseline
+	Baselines *Baselines // baseline: mu y b_i
+}
+
+// base predicción "a priori" de un ítem según el modo.
+func (p PredictParams) base(movieID int) float64 {
+	switch p.Mode {
+	case ModeMeanCentered:
+		return p.UserMean
+	case ModeBaseline:
+		if p.Baselines == nil {
+			return 0
+		}
+		return p.Baselines.GlobalMean + p.UserBias + p.Baselines.ItemBias[movieID]
+	}
+	return 0
+}
+
+// Partial numerador/denominador acumulados de un candidato. La predicción
+// es Base + Num/Den; Num y Den se pueden sumar entre shards, Base es la
+// misma en todos.
+type Partial struct {
+	Num, Den, Base float64
+}
+
+// Score predicción final del candidato.
+func (p Partial) Score() float64 {
+	if p.Den <= 0 {
+		return p.Base
+	}
+	return p.Base + p.Num/p.Den
+}
+
+// KNNAccumulator acumula las contribuciones de los vecinos de cada ítem
+// valorado hacia los candidatos.
+type KNNAccumulator struct {
+	params   PredictParams
+	exclude  map[int]bool
+	Partials map[int]*Partial
+}
+
+// NewKNNAccumulator exclude son los ítems que no deben salir como candidatos
+// (normalmente los que el usuario ya valoró).
+func NewKNNAccumulator(p PredictParams, exclude map[int]bool) *KNNAccumulator {
+	return &KNNAccumulator{params: p, exclude: exclude, Partials: make(map[int]*Partial)}
+}
+
+// Add suma los vecinos de un ítem valorado con su rating.
+func (a *KNNAccumulator) Add(rated models.RatingDoc, neighbors []models.Neighbor) {
+	dev := rated.Rating - a.params.base(rated.MovieID)
+
+	for _, n := range neighbors {
+		if n.Sim <= 0 || a.exclude[n.MovieID] {
+			continue
+		}
+		p := a.Partials[n.MovieID]
+		if p == nil {
+			p = &Partial{Base: a.params.base(n.MovieID)}
+			a.Partials[n.MovieID] = p
+		}
+		p.Num += n.Sim * dev
+		p.Den += n.Sim
+	}
+}
+
+// BaselinesCache recalcula los Baselines como mucho una vez cada ttl
+// (requiere recorrer toda la colección ratings).
+type BaselinesCache struct {
+	load func(ctx context.Context) (map[int]ItemStat, error)
+	ttl  time.Duration
+
+	mu       sync.Mutex
+	b        *Baselines
+	loadedAt time.Time
+}
+
+func NewBaselinesCache(load func(ctx context.Context) (map[int]ItemStat, error), ttl time.Duration) *BaselinesCache {
+	return &BaselinesCache{load: load, ttl: ttl}
+}
+
+func (c *BaselinesCache) Get(ctx context.Context) (*Baselines, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.b != nil && time.Since(c.loadedAt) < c.ttl {
+		return c.b, nil
+	}
+	stats, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.b, c.loadedAt = NewBaselines(stats), time.Now()
+	return c.b, nil
+}
@@ -0,0 +1,299 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"nodosml-pc4/internal/models"
+)
+
+// Métricas de similitud item-item. Los vecinos de cada métrica se guardan
+// lado a lado en similarities (un documento por película y métrica).
+const (
+	MetricCosine         = "cosine"          // ratings crudos
+	MetricAdjustedCosine = "adjusted-cosine" // ratings centrados en la media del usuario
+	MetricPearson        = "pearson"         // ratings centrados en la media de la película, sobre los usuarios en común
+	MetricJaccard        = "jaccard"         // |U_i ∩ U_j| / |U_i ∪ U_j| (ignora el valor del rating)
+	// coseno entre vectores de ítems (factores MF o genome tags) buscado en
+	// un índice HNSW: no sale de los co-ratings (ver HNSW)
+	MetricEmbedding = "embedding"
+
+	DefaultMetric = MetricCosine
+)
+
+// Metrics todas las métricas soportadas.
+var Metrics = []string{MetricCosine, MetricAdjustedCosine, MetricPearson, MetricJaccard, MetricEmbedding}
+
+// ParseMetric valida la métrica ("" = DefaultMetric).
+func ParseMetric(s string) (string, error) {
+	switch s {
+	case "":
+		return DefaultMetric, nil
+	case MetricCosine, MetricAdjustedCosine, MetricPearson, MetricJaccard, MetricEmbedding:
+		return s, nil
+	}
+	return "", fmt.Errorf("métrica desconocida %q (cosine | adjusted-cosine | pearson | jaccard | embedding)", s)
+}
+
+// SimilarityParams parámetros del cálculo de vecinos item-item.
+type SimilarityParams struct {
+	K              int // vecinos a guardar por ítem
+	MinCommonUsers int // mínimo de usuarios en común para aceptar un par
+	Shrink         int // shrinkage: sim * n / (n + shrink)
+}
+
+// ScoredItem vecino candidato con su similitud y soporte (usuarios en común).
+type ScoredItem struct {
+	MovieID int
+	Sim     float64
+	Common  int
+}
+
+// CosineNeighbors calcula los K vecinos más similares (coseno) de un ítem.
+//
+//   - targetRatings: userId -> rating que cada usuario dio al ítem objetivo.
+//   - userRatings:   userId -> todos los ratings de ese usuario (co-raters).
+//   - norms:         movieId -> norma L2 del vector de ratings del ítem.
+//
+// Solo se consideran como vecinos los ítems presentes en norms, así el
+// llamador decide el universo (p.e. películas con iIdx asignado).
+func CosineNeighbors(
+	targetID int,
+	targetRatings map[int]float64,
+	userRatings map[int][]models.RatingDoc,
+	norms map[int]float64,
+	p SimilarityParams,
+) []ScoredItem {
+
+	if norms[targetID] <= 0 {
+		return nil
+	}
+	dots, common := CoRatingStats(targetID, targetRatings, userRatings, norms)
+	return CosineFromStats(targetID, dots, common, norms, p)
+}
+
+// CoRatingStats estadísticas suficientes del coseno de un ítem con cada
+// ítem del universo (norms) con el que comparte usuarios: producto punto
+// y cantidad de usuarios en común.
+func CoRatingStats(
+	targetID int,
+	targetRatings map[int]float64,
+	userRatings map[int][]models.RatingDoc,
+	norms map[int]float64,
+) (map[int]float64, map[int]int) {
+
+	dots := make(map[int]float64)
+	common := make(map[int]int)
+
+	for userID, rTarget := range targetRatings {
+		for _, r := range userRatings[userID] {
+			if r.MovieID == targetID {
+				continue
+			}
+			if _, ok := norms[r.MovieID]; !ok {
+				continue
+			}
+			dots[r.MovieID] += rTarget * r.Rating
+			common[r.MovieID]++
+		}
+	}
+	return dots, common
+}
+
+// CosineFromStats arma los K vecinos a partir de los productos punto y
+// usuarios en común (ver CoRatingStats) y las normas de los ítems.
+func CosineFromStats(
+	targetID int,
+	dots map[int]float64,
+	common map[int]int,
+	norms map[int]float64,
+	p SimilarityParams,
+) []ScoredItem {
+
+	targetNorm := norms[targetID]
+	if targetNorm <= 0 {
+		return nil
+	}
+
+	out := make([]ScoredItem, 0, len(dots))
+	for movieID, dot := range dots {
+		n := common[movieID]
+		if n < p.MinCommonUsers {
+			continue
+		}
+		norm := norms[movieID]
+		if norm <= 0 {
+			continue
+		}
+
+		sim := dot / (targetNorm * norm)
+		if p.Shrink > 0 {
+			sim *= float64(n) / float64(n+p.Shrink)
+		}
+		if sim <= 0 {
+			continue
+		}
+		out = append(out, ScoredItem{MovieID: movieID, Sim: sim, Common: n})
+	}
+
+	return TopK(out, p.K)
+}
+
+// TopK ordena por similitud descendente (desempate por movieId) y trunca a k.
+func TopK(items []ScoredItem, k int) []ScoredItem {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Sim != items[j].Sim {
+			return items[i].Sim > items[j].Sim
+		}
+		return items[i].MovieID < items[j].MovieID
+	})
+	if k > 0 && len(items) > k {
+		items = items[:k]
+	}
+	return items
+}
+
+// ItemUniverse datos por película que necesitan las métricas. Las claves
+// de Norms definen el universo de vecinos; el resto solo hace falta para
+// la métrica que lo usa.
+type ItemUniverse struct {
+	Norms         map[int]float64 // cosine: norma L2 de los ratings
+	CenteredNorms map[int]float64 // adjusted-cosine: norma L2 de r_ui - media de u
+	UserMeans     map[int]float64 // adjusted-cosine: media de cada usuario
+	Means         map[int]float64 // pearson: media de la película
+	Counts        map[int]int     // jaccard: usuarios que la valoraron
+}
+
+// UniverseFromRatings arma el universo completo a partir de todos los
+// ratings por usuario. Con keep != nil solo entran esas películas.
+func UniverseFromRatings(byUser map[int][]models.RatingDoc, keep func(movieID int) bool) *ItemUniverse {
+	u := &ItemUniverse{
+		Norms:         make(map[int]float64),
+		CenteredNorms: make(map[int]float64),
+		UserMeans:     make(map[int]float64, len(byUser)),
+		Means:         make(map[int]float64),
+		Counts:        make(map[int]int),
+	}
+	sums := make(map[int]float64)
+	for userID, rs := range byUser {
+		if len(rs) == 0 {
+			continue
+		}
+		var sum float64
+		for _, r := range rs {
+			sum += r.Rating
+		}
+		mean := sum / float64(len(rs))
+		u.UserMeans[userID] = mean
+
+		for _, r := range rs {
+			if keep != nil && !keep(r.MovieID) {
+				continue
+			}
+			c := r.Rating - mean
+			u.Norms[r.MovieID] += r.Rating * r.Rating
+			u.CenteredNorms[r.MovieID] += c * c
+			sums[r.MovieID] += r.Rating
+			u.Counts[r.MovieID]++
+		}
+	}
+	for movieID, sq := range u.Norms {
+		u.Norms[movieID] = math.Sqrt(sq)
+		u.CenteredNorms[movieID] = math.Sqrt(u.CenteredNorms[movieID])
+		u.Means[movieID] = sums[movieID] / float64(u.Counts[movieID])
+	}
+	return u
+}
+
+// ItemNeighbors K vecinos de un ítem según una métrica de co-ratings (no
+// embedding). Los argumentos son los de CosineNeighbors; u.Norms define el
+// universo de vecinos.
+func ItemNeighbors(
+	metric string,
+	targetID int,
+	targetRatings map[int]float64,
+	userRatings map[int][]models.RatingDoc,
+	u *ItemUniverse,
+	p SimilarityParams,
+) []ScoredItem {
+
+	if metric == MetricCosine || metric == "" {
+		return CosineNeighbors(targetID, targetRatings, userRatings, u.Norms, p)
+	}
+	if _, ok := u.Norms[targetID]; !ok || metric == MetricEmbedding {
+		return nil
+	}
+
+	type acc struct {
+		xy, xx, yy float64
+		n          int
+	}
+	pairs := make(map[int]*acc)
+	for userID, rTarget := range targetRatings {
+		for _, r := range userRatings[userID] {
+			if r.MovieID == targetID {
+				continue
+			}
+			if _, ok := u.Norms[r.MovieID]; !ok {
+				continue
+			}
+			a := pairs[r.MovieID]
+			if a == nil {
+				a = &acc{}
+				pairs[r.MovieID] = a
+			}
+			a.n++
+
+			var x, y float64
+			switch metric {
+			case MetricAdjustedCosine:
+				mean := u.UserMeans[userID]
+				x, y = rTarget-mean, r.Rating-mean
+			case MetricPearson:
+				x, y = rTarget-u.Means[targetID], r.Rating-u.Means[r.MovieID]
+			default:
+				continue // jaccard solo cuenta usuarios
+			}
+			a.xy += x * y
+			a.xx += x * x
+			a.yy += y * y
+		}
+	}
+
+	out := make([]ScoredItem, 0, len(pairs))
+	for movieID, a := range pairs {
+		if a.n < p.MinCommonUsers {
+			continue
+		}
+
+		var sim float64
+		switch metric {
+		case MetricAdjustedCosine:
+			den := u.CenteredNorms[targetID] * u.CenteredNorms[movieID]
+			if den > 0 {
+				sim = a.xy / den
+			}
+		case MetricPearson:
+			den := math.Sqrt(a.xx * a.yy)
+			if den > 0 {
+				sim = a.xy / den
+			}
+		case MetricJaccard:
+			union := u.Counts[targetID] + u.Counts[movieID] - a.n
+			if union > 0 {
+				sim = float64(a.n) / float64(union)
+			}
+		}
+
+		if p.Shrink > 0 {
+			sim *= float64(a.n) / float64(a.n+p.Shrink)
+		}
+		if sim <= 0 {
+			continue
+		}
+		out = append(out, ScoredItem{MovieID: movieID, Sim: sim, Common: a.n})
+	}
+
+	return TopK(out, p.K)
+}
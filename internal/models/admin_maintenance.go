@@ -1,15 +1,18 @@
 package models
 
+import "time"
+
 // ----- SUMMARY -----
 
 // AdminSimilaritySummary representa el resumen general de mapeos y similitudes.
 type AdminSimilaritySummary struct {
-	TotalMovies               int64 `json:"totalMovies"`
-	MoviesWithIdx             int64 `json:"moviesWithIdx"`
-	MoviesWithoutIdx          int64 `json:"moviesWithoutIdx"`
-	MoviesWithSimilarities    int64 `json:"moviesWithSimilarities"`
-	MoviesWithoutSimilarities int64 `json:"moviesWithoutSimilarities"`
-	MinRatings                int64 `json:"minRatings"`
+	TotalMovies               int64  `json:"totalMovies"`
+	MoviesWithIdx             int64  `json:"moviesWithIdx"`
+	MoviesWithoutIdx          int64  `json:"moviesWithoutIdx"`
+	MoviesWithSimilarities    int64  `json:"moviesWithSimilarities"`
+	MoviesWithoutSimilarities int64  `json:"moviesWithoutSimilarities"`
+	MinRatings                int64  `json:"minRatings"`
+	Metric                    string `json:"metric"` // métrica de similitud contada
 }
 
 // ----- PENDING -----
@@ -32,6 +35,7 @@ type PendingMovieWithoutSims struct {
 // AdminPendingSimilarities respuesta de /pending.
 type AdminPendingSimilarities struct {
 	MinRatings          int64                     `json:"minRatings"`
+	Metric              string                    `json:"metric"`
 	WithoutIdx          []PendingMovieWithoutIdx  `json:"withoutIdx"`
 	WithoutSimilarities []PendingMovieWithoutSims `json:"withoutSimilarities"`
 }
@@ -61,13 +65,53 @@ type RebuildSimilaritiesRequest struct {
 	K              int   `json:"k"`
 	MinCommonUsers int   `json:"minCommonUsers"`
 	Shrink         int   `json:"shrink"`
+	// métricas a calcular (default ["cosine"]); se recalculan las películas
+	// a las que les falta alguna
+	Metrics []string `json:"metrics"`
+	// índice ANN para la métrica embedding (default: factores del modelo MF activo)
+	ANN *ANNIndexRequest `json:"ann,omitempty"`
 }
 
 // RebuildSimilaritiesResult resultado de /rebuild.
 type RebuildSimilaritiesResult struct {
-	ProcessedMovies int `json:"processedMovies"`
-	Batches         int `json:"batches"`
-	K               int `json:"k"`
-	MinCommonUsers  int `json:"minCommonUsers"`
-	Shrink          int `json:"shrink"`
+	ProcessedMovies int      `json:"processedMovies"`
+	Batches         int      `json:"batches"`
+	Upserted        int      `json:"upserted"` // documentos escritos en similarities
+	K               int      `json:"k"`
+	MinCommonUsers  int      `json:"minCommonUsers"`
+	Shrink          int      `json:"shrink"`
+	Metrics         []string `json:"metrics"`
+	// recall@k más bajo entre nodos del índice ANN (métrica embedding)
+	ANNRecall float64 `json:"annRecall,omitempty"`
+}
+
+// ----- ANN -----
+
+// ANNIndexRequest body de /similarities/ann: índice HNSW sobre vectores de
+// ítems. Los parámetros en 0 toman los defaults de ml.DefaultHNSWParams.
+type ANNIndexRequest struct {
+	Source         string `json:"source"`  // mf (default) | genome
+	ModelID        string `json:"modelId"` // modelo MF (default: el activo)
+	M              int    `json:"m"`
+	EfConstruction int    `json:"efConstruction"`
+	EfSearch       int    `json:"efSearch"`
+	Rebuild        bool   `json:"rebuild"`      // rearmar aunque esté en disco
+	RecallSample   int    `json:"recallSample"` // ítems para medir recall (default 200)
+	RecallK        int    `json:"recallK"`      // default 20
+}
+
+// ----- INCREMENTAL -----
+
+// IncrementalSimilarityStatus estado de la actualización incremental de
+// similitudes (cambios de ratings aplicados desde que arrancó la API).
+type IncrementalSimilarityStatus struct {
+	Pending        int64      `json:"pending"`   // cambios de ratings en cola
+	Applied        int64      `json:"applied"`   // cambios aplicados
+	Seeded         int64      `json:"seeded"`    // películas con estadísticas sembradas desde ratings
+	Refreshed      int64      `json:"refreshed"` // listas de vecinos reescritas
+	LastRunAt      *time.Time `json:"lastRunAt,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	K              int        `json:"k"`
+	MinCommonUsers int        `json:"minCommonUsers"`
+	Shrink         int        `json:"shrink"`
 }
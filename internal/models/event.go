@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Tipos de evento de interacción (feedback implícito).
+const (
+	EventView      = "view"      // abrió el detalle de la película
+	EventClick     = "click"     // hizo clic en una recomendación
+	EventWatchlist = "watchlist" // la agregó a su lista
+	EventDismiss   = "dismiss"   // "no me interesa": no se le vuelve a recomendar
+)
+
+// EventSourceRecommendations source de los eventos hechos sobre una lista
+// de recomendaciones.
+const EventSourceRecommendations = "recommendations"
+
+// ValidEventType indica si t es uno de los tipos de evento conocidos.
+func ValidEventType(t string) bool {
+	switch t {
+	case EventView, EventClick, EventWatchlist, EventDismiss:
+		return true
+	}
+	return false
+}
+
+// EventDoc lo que se guarda en la colección events.
+type EventDoc struct {
+	ID      string `bson:"_id,omitempty" json:"id"`
+	UserID  int    `bson:"userId"        json:"userId"`
+	MovieID int    `bson:"movieId"       json:"movieId"`
+	Type    string `bson:"type"          json:"type"`
+	// de dónde vino (p.e. "recommendations", "similar", "search")
+	Source string `bson:"source,omitempty" json:"source,omitempty"`
+	// ejecución del historial que mostró la película (clics en recomendaciones)
+	RecommendationID string    `bson:"recommendationId,omitempty" json:"recommendationId,omitempty"`
+	CreatedAt        time.Time `bson:"createdAt"        json:"createdAt"`
+}
+
+// EventRequest body de POST /me/events.
+type EventRequest struct {
+	MovieID          int    `json:"movieId"`
+	Type             string `json:"type"` // view | click | watchlist | dismiss
+	Source           string `json:"source,omitempty"`
+	RecommendationID string `json:"recommendationId,omitempty"`
+}
@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// Estados de un experimento A/B.
+const (
+	ExperimentStatusDraft   = "draft"
+	ExperimentStatusRunning = "running"
+	ExperimentStatusStopped = "stopped"
+)
+
+// ExperimentVariant configuración de recomendación de una rama. Los campos
+// vacíos usan el default del servicio.
+type ExperimentVariant struct {
+	Name   string `json:"name" bson:"name"`
+	Weight int    `json:"weight" bson:"weight"` // peso relativo en el reparto (default 1)
+
+	Algo      string  `json:"algo,omitempty" bson:"algo,omitempty"`
+	Mode      string  `json:"mode,omitempty" bson:"mode,omitempty"`
+	Neighbors int     `json:"neighbors,omitempty" bson:"neighbors,omitempty"` // vecinos por ítem valorado (item-kNN)
+	MMRLambda float64 `json:"mmrLambda,omitempty" bson:"mmrLambda,omitempty"` // diversidad (0 = sin re-ranking)
+	Weights   string  `json:"weights,omitempty" bson:"weights,omitempty"`     // pesos del modo hybrid
+}
+
+// Experiment experimento A/B sobre las recomendaciones. Los usuarios se
+// reparten de forma determinística por userId.
+type Experiment struct {
+	ID          string              `json:"id" bson:"_id"`
+	Name        string              `json:"name" bson:"name"`
+	Description string              `json:"description,omitempty" bson:"description,omitempty"`
+	Status      string              `json:"status" bson:"status"`
+	Traffic     int                 `json:"traffic" bson:"traffic"` // % de usuarios que entran (1-100)
+	Variants    []ExperimentVariant `json:"variants" bson:"variants"`
+	CreatedAt   time.Time           `json:"createdAt" bson:"createdAt"`
+	StartedAt   *time.Time          `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	StoppedAt   *time.Time          `json:"stoppedAt,omitempty" bson:"stoppedAt,omitempty"`
+}
+
+// ExperimentCreateRequest body de POST /admin/experiments.
+type ExperimentCreateRequest struct {
+	ID          string              `json:"id"` // slug; se usa también para el reparto
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Traffic     int                 `json:"traffic"` // default 100
+	Variants    []ExperimentVariant `json:"variants"`
+}
+
+// ExperimentAssignment rama que le tocó a un usuario.
+type ExperimentAssignment struct {
+	ExperimentID string `json:"experimentId" bson:"experimentId"`
+	Variant      string `json:"variant" bson:"variant"`
+}
+
+// ExperimentExposure contador de respuestas servidas a un usuario en una
+// rama (colección experiment_exposures).
+type ExperimentExposure struct {
+	ExperimentID string    `bson:"experimentId"`
+	Variant      string    `bson:"variant"`
+	UserID       int       `bson:"userId"`
+	Count        int64     `bson:"count"`
+	FirstAt      time.Time `bson:"firstAt"`
+	LastAt       time.Time `bson:"lastAt"`
+}
+
+// VariantReport métricas agregadas de una rama.
+type VariantReport struct {
+	Variant   string  `json:"variant"`
+	Users     int     `json:"users"`     // usuarios expuestos
+	Exposures int64   `json:"exposures"` // respuestas servidas
+	Clicks    int64   `json:"clicks"`    // clics en recomendaciones desde la primera exposición
+	CTR       float64 `json:"ctr"`       // clicks / exposures
+}
+
+// ExperimentReport GET /admin/experiments/{id}/report.
+type ExperimentReport struct {
+	Experiment  Experiment      `json:"experiment"`
+	Variants    []VariantReport `json:"variants"`
+	GeneratedAt time.Time       `json:"generatedAt"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// Estados de un modelo de factorización.
+const (
+	MFStatusTraining = "training"
+	MFStatusReady    = "ready"
+	MFStatusFailed   = "failed"
+)
+
+// MFModel metadatos de un modelo de factorización (colección mf_models).
+// Los factores van aparte en mf_factors, un documento por usuario/ítem.
+type MFModel struct {
+	ID     string `json:"id" bson:"_id"`
+	Status string `json:"status" bson:"status"`
+	Node   string `json:"node,omitempty" bson:"node,omitempty"` // nodo ML que entrenó
+
+	Factors      int     `json:"factors" bson:"factors"`
+	Epochs       int     `json:"epochs" bson:"epochs"`
+	LearningRate float64 `json:"learningRate" bson:"learningRate"`
+	Reg          float64 `json:"reg" bson:"reg"`
+
+	GlobalMean float64 `json:"globalMean" bson:"globalMean"`
+	Users      int     `json:"users" bson:"users"`
+	Items      int     `json:"items" bson:"items"`
+	Ratings    int     `json:"ratings" bson:"ratings"`
+	TrainRMSE  float64 `json:"trainRmse" bson:"trainRmse"`
+	Error      string  `json:"error,omitempty" bson:"error,omitempty"`
+
+	StartedAt  time.Time  `json:"startedAt" bson:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+}
+
+// Tipos de fila en mf_factors.
+const (
+	MFKindUser = "user"
+	MFKindItem = "item"
+)
+
+// MFFactorDoc factores de un usuario (Idx = uIdx) o de un ítem (Idx = iIdx).
+type MFFactorDoc struct {
+	ModelID string    `json:"modelId" bson:"modelId"`
+	Kind    string    `json:"kind" bson:"kind"`
+	Idx     int       `json:"idx" bson:"idx"`
+	ID      int       `json:"id" bson:"id"` // userId / movieId
+	Bias    float64   `json:"bias" bson:"bias"`
+	Vec     []float64 `json:"vec" bson:"vec"`
+}
+
+// MFTrainRequest body de POST /admin/models/mf/train (ceros = default).
+type MFTrainRequest struct {
+	Factors      int     `json:"factors" example:"32"`
+	Epochs       int     `json:"epochs" example:"20"`
+	LearningRate float64 `json:"learningRate" example:"0.007"`
+	Reg          float64 `json:"reg" example:"0.02"`
+}
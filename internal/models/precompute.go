@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Estados de un job de precálculo de recomendaciones.
+const (
+	PrecomputeStatusRunning   = "running"
+	PrecomputeStatusPaused    = "paused"
+	PrecomputeStatusDone      = "done"
+	PrecomputeStatusCancelled = "cancelled"
+	PrecomputeStatusFailed    = "failed"
+)
+
+// PrecomputeRequest body de POST /admin/precompute. Ceros = default.
+type PrecomputeRequest struct {
+	// solo usuarios con ratings en las últimas N horas (0 = todos)
+	ActiveWithinHours int    `json:"activeWithinHours"`
+	K                 int    `json:"k"`             // default 20 (lo que piden los clientes por defecto)
+	Algo              string `json:"algo"`          // "" = el de cada usuario (variante del experimento o item-knn)
+	Concurrency       int    `json:"concurrency"`   // usuarios en paralelo (default 4, máx 16)
+	CacheTTLHours     int    `json:"cacheTTLHours"` // default 12
+}
+
+// PrecomputeJob estado de un job de precálculo.
+type PrecomputeJob struct {
+	ID         string            `json:"id"`
+	Status     string            `json:"status"`
+	Request    PrecomputeRequest `json:"request"`
+	Total      int               `json:"total"`
+	Done       int               `json:"done"`
+	Failed     int               `json:"failed"`
+	Partial    int               `json:"partial"` // resultados con algún shard sin respuesta
+	LastError  string            `json:"lastError,omitempty"`
+	StartedAt  time.Time         `json:"startedAt"`
+	FinishedAt *time.Time        `json:"finishedAt,omitempty"`
+	// usuarios por segundo (sin contar el tiempo en pausa)
+	Rate float64 `json:"rate"`
+}
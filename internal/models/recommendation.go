@@ -1,10 +1,80 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
 
 type RecItem struct {
 	MovieID int     `bson:"movieId" json:"movieId"`
 	Score   float64 `bson:"score"  json:"score"`
+	// aporte de cada fuente al score (solo en el modo híbrido)
+	Sources map[string]float64 `bson:"sources,omitempty" json:"sources,omitempty"`
+}
+
+// RecResult resultado de Recommend con metadatos del cálculo distribuido.
+type RecResult struct {
+	Items        []RecItem `json:"items"`
+	Cached       bool      `json:"cached"`
+	Partial      bool      `json:"partial"`                // algún shard no respondió
+	FailedShards []int     `json:"failedShards,omitempty"` // shards sin respuesta
+	Diversity    float64   `json:"diversity"`              // 1 - similitud media entre pares
+
+	// parámetros efectivos (los de la petición o los de la rama del experimento)
+	Algo       string                `json:"algo"`
+	Mode       string                `json:"mode,omitempty"`
+	Metric     string                `json:"metric,omitempty"`
+	Weights    map[string]float64    `json:"weights,omitempty"`
+	MMRLambda  float64               `json:"lambda"`
+	Experiment *ExperimentAssignment `json:"experiment,omitempty"`
+}
+
+// RecFilter restricciones sobre las películas recomendadas. Los campos en
+// cero no restringen; con filtro de año/duración las películas sin ese
+// dato quedan fuera.
+type RecFilter struct {
+	Genres        []string `json:"genres,omitempty" bson:"genres,omitempty"`               // al menos uno de estos
+	ExcludeGenres []string `json:"excludeGenres,omitempty" bson:"excludeGenres,omitempty"` // ninguno de estos
+	YearFrom      int      `json:"yearFrom,omitempty" bson:"yearFrom,omitempty"`
+	YearTo        int      `json:"yearTo,omitempty" bson:"yearTo,omitempty"`
+	MinCount      int      `json:"minCount,omitempty" bson:"minCount,omitempty"` // ratingStats.count mínimo
+	RuntimeMin    int      `json:"runtimeMin,omitempty" bson:"runtimeMin,omitempty"`
+	RuntimeMax    int      `json:"runtimeMax,omitempty" bson:"runtimeMax,omitempty"`
+}
+
+// IsZero indica que el filtro no restringe nada.
+func (f RecFilter) IsZero() bool {
+	return len(f.Genres) == 0 && len(f.ExcludeGenres) == 0 &&
+		f.YearFrom == 0 && f.YearTo == 0 && f.MinCount == 0 &&
+		f.RuntimeMin == 0 && f.RuntimeMax == 0
+}
+
+// Key representación canónica del filtro (para la clave de caché).
+func (f RecFilter) Key() string {
+	norm := func(gs []string) string {
+		out := make([]string, len(gs))
+		for i, g := range gs {
+			out[i] = strings.ToLower(strings.TrimSpace(g))
+		}
+		sort.Strings(out)
+		return strings.Join(out, "|")
+	}
+	return fmt.Sprintf("g=%s;xg=%s;y=%d-%d;c=%d;rt=%d-%d",
+		norm(f.Genres), norm(f.ExcludeGenres), f.YearFrom, f.YearTo,
+		f.MinCount, f.RuntimeMin, f.RuntimeMax)
+}
+
+// SimilarMovie película vecina para "porque viste X" (/movies/{id}/similar).
+type SimilarMovie struct {
+	MovieID   int      `json:"movieId"`
+	Title     string   `json:"title"`
+	Year      *int     `json:"year,omitempty"`
+	Genres    []string `json:"genres"`
+	PosterURL string   `json:"posterUrl,omitempty"`
+	Score     float64  `json:"score"`
+	Source    string   `json:"source"` // "similarities" | "content"
 }
 
 type Recommendation struct {
@@ -17,17 +87,60 @@ type Recommendation struct {
 	CreatedAt        time.Time `bson:"createdAt"            json:"createdAt"`
 }
 
+// RecHistoryPage página del historial de recomendaciones de un usuario.
+type RecHistoryPage struct {
+	Items []Recommendation `json:"items"`
+	// createdAt del último ítem: pasarlo como before para la página siguiente
+	NextBefore *time.Time `json:"nextBefore,omitempty"`
+}
+
+// RecRunSummary ejecución comparada en un RecDiff.
+type RecRunSummary struct {
+	ID        string    `json:"id"`
+	Algo      string    `json:"algo"`
+	Params    any       `json:"params"`
+	CreatedAt time.Time `json:"createdAt"`
+	Count     int       `json:"count"`
+}
+
+// RecDiffItem película que entró, salió o cambió de posición entre dos
+// ejecuciones. Las posiciones empiezan en 1; 0 = no estaba en esa lista.
+type RecDiffItem struct {
+	MovieID   int     `json:"movieId"`
+	FromRank  int     `json:"fromRank,omitempty"`
+	ToRank    int     `json:"toRank,omitempty"`
+	FromScore float64 `json:"fromScore,omitempty"`
+	ToScore   float64 `json:"toScore,omitempty"`
+}
+
+// RecDiff diferencias entre dos ejecuciones (from = la más vieja).
+type RecDiff struct {
+	From    RecRunSummary `json:"from"`
+	To      RecRunSummary `json:"to"`
+	Entered []RecDiffItem `json:"entered"` // en to pero no en from
+	Left    []RecDiffItem `json:"left"`    // en from pero no en to
+	Moved   []RecDiffItem `json:"moved"`   // en ambas con otra posición
+	Kept    int           `json:"kept"`    // en ambas (incluye las que se movieron)
+}
+
 // ====== Explicación de una recomendación (para /recommendations/explain) ======
 
 type NeighborContribution struct {
 	NeighborMovieID int     `json:"neighbor_movie_id" bson:"neighbor_movie_id"`
+	Title           string  `json:"title,omitempty"      bson:"title,omitempty"`
+	PosterURL       string  `json:"poster_url,omitempty" bson:"poster_url,omitempty"`
 	Sim             float64 `json:"sim"               bson:"sim"`
 	UserRating      float64 `json:"user_rating"       bson:"user_rating"`
+	Implicit        bool    `json:"implicit,omitempty" bson:"implicit,omitempty"` // rating implícito (vista, clic, watchlist)
 	Contribution    float64 `json:"contribution"      bson:"contribution"`
 }
 
 type Explanation struct {
 	MovieID   int                    `json:"movie_id" bson:"movie_id"`
+	Title     string                 `json:"title,omitempty"      bson:"title,omitempty"`
+	PosterURL string                 `json:"poster_url,omitempty" bson:"poster_url,omitempty"`
 	Score     float64                `json:"score"    bson:"score"`
-	Neighbors []NeighborContribution `json:"neighbors" bson:"neighbors"`
+	Reason    string                 `json:"reason"   bson:"reason"`     // p.e. "Porque valoraste The Matrix con 5★"
+	Metric    string                 `json:"metric"   bson:"metric"`     // métrica de similitud de los vecinos
+	Neighbors []NeighborContribution `json:"neighbors" bson:"neighbors"` // de mayor a menor aporte
 }
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Neighbor struct {
 	MovieID int     `json:"movieId" bson:"movieId"`
 	IIdx    int     `json:"iIdx" bson:"iIdx"`
@@ -15,3 +17,41 @@ type SimilarityDoc struct {
 	Neighbors []Neighbor `json:"neighbors" bson:"neighbors"`
 	UpdatedAt string     `json:"updatedAt" bson:"updatedAt"`
 }
+
+// RatingChange cambio de un rating pendiente de aplicar a las estadísticas
+// de co-ratings (colección similarity_updates).
+type RatingChange struct {
+	ID      string  `bson:"_id,omitempty"`
+	UserID  int     `bson:"userId"`
+	MovieID int     `bson:"movieId"`
+	Old     float64 `bson:"old"`    // rating anterior (si HadOld)
+	HadOld  bool    `bson:"hadOld"` // false = rating nuevo
+	New     float64 `bson:"new"`
+	// momento previo a escribir el rating: lo que se sembró después ya lo incluye
+	At time.Time `bson:"at"`
+}
+
+// PairStat estadísticas suficientes del coseno entre dos películas.
+type PairStat struct {
+	Dot    float64 `bson:"dot"` // suma de r_ui * r_uj
+	Common int     `bson:"n"`   // usuarios que valoraron ambas
+}
+
+// ItemPairStats co-ratings de una película con todas las que comparte
+// usuarios (colección similarity_stats). Se siembran desde ratings la
+// primera vez que la película recibe un rating y después se actualizan
+// con deltas.
+type ItemPairStats struct {
+	MovieID   int              `bson:"_id"`
+	Pairs     map[int]PairStat `bson:"pairs"`
+	SeededAt  time.Time        `bson:"seededAt"`
+	UpdatedAt time.Time        `bson:"updatedAt"`
+}
+
+// PairDelta incremento a aplicar a Pairs[Other] del documento de MovieID.
+type PairDelta struct {
+	MovieID int
+	Other   int
+	Dot     float64
+	Common  int
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"nodosml-pc4/internal/db"
+	"nodosml-pc4/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type EventRepository struct {
+	col *mongo.Collection
+}
+
+func NewEventRepository() *EventRepository {
+	return &EventRepository{col: db.DB().Collection("events")}
+}
+
+func (r *EventRepository) Insert(ctx context.Context, ev *models.EventDoc) error {
+	if ev.CreatedAt.IsZero() {
+		ev.CreatedAt = time.Now()
+	}
+	_, err := r.col.InsertOne(ctx, ev)
+	return err
+}
+
+// RecentByUser últimos limit eventos del usuario de los tipos pedidos (sin
+// source/recommendationId), del más reciente al más viejo.
+func (r *EventRepository) RecentByUser(ctx context.Context, userID int, types []string, limit int64) ([]models.EventDoc, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetLimit(limit).
+		SetProjection(bson.M{"userId": 1, "movieId": 1, "type": 1, "createdAt": 1})
+
+	cur, err := r.col.Find(ctx, bson.M{"userId": userID, "type": bson.M{"$in": types}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var out []models.EventDoc
+	for cur.Next(ctx) {
+		var ev models.EventDoc
+		if err := cur.Decode(&ev); err != nil {
+			return nil, err
+		}
+		out = append(out, ev)
+	}
+	return out, cur.Err()
+}
+
+// DismissedByUser películas que el usuario descartó.
+func (r *EventRepository) DismissedByUser(ctx context.Context, userID int) (map[int]bool, error) {
+	vals, err := r.col.Distinct(ctx, "movieId", bson.M{"userId": userID, "type": models.EventDismiss})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int]bool, len(vals))
+	for _, v := range vals {
+		out[asInt(v)] = true
+	}
+	return out, nil
+}
+
+// ForEachRecClick recorre los clics hechos en recomendaciones (source
+// "recommendations" o con recommendationId) en [from, to) (to cero = hasta
+// ahora).
+func (r *EventRepository) ForEachRecClick(ctx context.Context, from, to time.Time, fn func(userID int, at time.Time)) error {
+	created := bson.M{"$gte": from}
+	if !to.IsZero() {
+		created["$lt"] = to
+	}
+	filter := bson.M{
+		"type":      models.EventClick,
+		"createdAt": created,
+		"$or": bson.A{
+			bson.M{"source": models.EventSourceRecommendations},
+			bson.M{"recommendationId": bson.M{"$exists": true, "$ne": ""}},
+		},
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 0, "userId": 1, "createdAt": 1}).
+		SetBatchSize(10000)
+
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var ev models.EventDoc
+		if err := cur.Decode(&ev); err != nil {
+			return err
+		}
+		fn(ev.UserID, ev.CreatedAt)
+	}
+	return cur.Err()
+}
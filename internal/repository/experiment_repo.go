@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"nodosml-pc4/internal/db"
+	"nodosml-pc4/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExperimentRepository experimentos A/B (experiments) y exposiciones de
+// cada usuario a su rama (experiment_exposures).
+type ExperimentRepository struct {
+	experiments *mongo.Collection
+	exposures   *mongo.Collection
+}
+
+func NewExperimentRepository() *ExperimentRepository {
+	return &ExperimentRepository{
+		experiments: db.DB().Collection("experiments"),
+		exposures:   db.DB().Collection("experiment_exposures"),
+	}
+}
+
+// EnsureIndexes crea el índice único parcial sobre los experimentos en
+// curso: así Mongo mismo impide que haya dos a la vez.
+func (r *ExperimentRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.experiments.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}},
+		Options: options.Index().
+			SetName("one_running").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"status": models.ExperimentStatusRunning}),
+	})
+	return err
+}
+
+// Create inserta el experimento; si el id ya existe devuelve un error de
+// clave duplicada (mongo.IsDuplicateKeyError).
+func (r *ExperimentRepository) Create(ctx context.Context, e *models.Experiment) error {
+	_, err := r.experiments.InsertOne(ctx, e)
+	return err
+}
+
+func (r *ExperimentRepository) Get(ctx context.Context, id string) (*models.Experiment, error) {
+	var e models.Experiment
+	err := r.experiments.FindOne(ctx, bson.M{"_id": id}).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &e, err
+}
+
+// List experimentos, del más nuevo al más viejo.
+func (r *ExperimentRepository) List(ctx context.Context) ([]models.Experiment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cur, err := r.experiments.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Experiment{}
+	for cur.Next(ctx) {
+		var e models.Experiment
+		if err := cur.Decode(&e); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, cur.Err()
+}
+
+// Running experimento en curso (nil si no hay).
+func (r *ExperimentRepository) Running(ctx context.Context) (*models.Experiment, error) {
+	var e models.Experiment
+	err := r.experiments.FindOne(ctx, bson.M{"status": models.ExperimentStatusRunning}).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &e, err
+}
+
+// Start pasa un borrador a running. Devuelve false si no estaba en draft y
+// un error de clave duplicada (mongo.IsDuplicateKeyError) si ya hay otro en
+// curso (índice de EnsureIndexes).
+func (r *ExperimentRepository) Start(ctx context.Context, id string, at time.Time) (bool, error) {
+	res, err := r.experiments.UpdateOne(ctx,
+		bson.M{"_id": id, "status": models.ExperimentStatusDraft},
+		bson.M{"$set": bson.M{"status": models.ExperimentStatusRunning, "startedAt": at}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// Stop detiene un experimento en curso. Devuelve false si no estaba running.
+func (r *ExperimentRepository) Stop(ctx context.Context, id string, at time.Time) (bool, error) {
+	res, err := r.experiments.UpdateOne(ctx,
+		bson.M{"_id": id, "status": models.ExperimentStatusRunning},
+		bson.M{"$set": bson.M{"status": models.ExperimentStatusStopped, "stoppedAt": at}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// RecordExposure suma una respuesta servida al usuario en su rama (un
+// documento por experimento + rama + usuario).
+func (r *ExperimentRepository) RecordExposure(ctx context.Context, a models.ExperimentAssignment, userID int) error {
+	now := time.Now()
+	_, err := r.exposures.UpdateOne(ctx,
+		bson.M{"experimentId": a.ExperimentID, "variant": a.Variant, "userId": userID},
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$set":         bson.M{"lastAt": now},
+			"$setOnInsert": bson.M{"firstAt": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Exposures recorre las exposiciones de un experimento.
+func (r *ExperimentRepository) Exposures(ctx context.Context, experimentID string, fn func(e *models.ExperimentExposure)) error {
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 0}).
+		SetBatchSize(10000)
+
+	cur, err := r.exposures.Find(ctx, bson.M{"experimentId": experimentID}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var e models.ExperimentExposure
+		if err := cur.Decode(&e); err != nil {
+			return err
+		}
+		fn(&e)
+	}
+	return cur.Err()
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"nodosml-pc4/internal/db"
+	"nodosml-pc4/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MFRepository modelos de factorización (mf_models) y sus factores (mf_factors).
+type MFRepository struct {
+	models  *mongo.Collection
+	factors *mongo.Collection
+}
+
+func NewMFRepository() *MFRepository {
+	return &MFRepository{
+		models:  db.DB().Collection("mf_models"),
+		factors: db.DB().Collection("mf_factors"),
+	}
+}
+
+func (r *MFRepository) CreateModel(ctx context.Context, m *models.MFModel) error {
+	_, err := r.models.InsertOne(ctx, m)
+	return err
+}
+
+// UpdateModel reemplaza los metadatos del modelo.
+func (r *MFRepository) UpdateModel(ctx context.Context, m *models.MFModel) error {
+	_, err := r.models.ReplaceOne(ctx, bson.M{"_id": m.ID}, m)
+	return err
+}
+
+// MarkFailed marca un modelo como fallido si sigue entrenando.
+func (r *MFRepository) MarkFailed(ctx context.Context, id, msg string) error {
+	now := time.Now()
+	_, err := r.models.UpdateOne(ctx,
+		bson.M{"_id": id, "status": models.MFStatusTraining},
+		bson.M{"$set": bson.M{"status": models.MFStatusFailed, "error": msg, "finishedAt": now}},
+	)
+	return err
+}
+
+func (r *MFRepository) GetModel(ctx context.Context, id string) (*models.MFModel, error) {
+	var m models.MFModel
+	err := r.models.FindOne(ctx, bson.M{"_id": id}).Decode(&m)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &m, err
+}
+
+// LatestReady devuelve el último modelo terminado (nil si no hay).
+func (r *MFRepository) LatestReady(ctx context.Context) (*models.MFModel, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "finishedAt", Value: -1}})
+	var m models.MFModel
+	err := r.models.FindOne(ctx, bson.M{"status": models.MFStatusReady}, opts).Decode(&m)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &m, err
+}
+
+// ListModels últimos modelos, del más nuevo al más viejo.
+func (r *MFRepository) ListModels(ctx context.Context, limit int64) ([]models.MFModel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "startedAt", Value: -1}}).SetLimit(limit)
+	cur, err := r.models.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.MFModel{}
+	for cur.Next(ctx) {
+		var m models.MFModel
+		if err := cur.Decode(&m); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, cur.Err()
+}
+
+// InsertFactors guarda un lote de factores.
+func (r *MFRepository) InsertFactors(ctx context.Context, docs []models.MFFactorDoc) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	batch := make([]any, len(docs))
+	for i := range docs {
+		batch[i] = docs[i]
+	}
+	_, err := r.factors.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+// DeleteFactors borra los factores de un modelo (p.e. si el entrenamiento
+// falló a mitad de la escritura).
+func (r *MFRepository) DeleteFactors(ctx context.Context, modelID string) error {
+	_, err := r.factors.DeleteMany(ctx, bson.M{"modelId": modelID})
+	return err
+}
+
+// ForEachFactor recorre los factores de un tipo (usuario/ítem) de un modelo.
+func (r *MFRepository) ForEachFactor(ctx context.Context, modelID, kind string, fn func(doc *models.MFFactorDoc)) error {
+	cur, err := r.factors.Find(ctx, bson.M{"modelId": modelID, "kind": kind})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var doc models.MFFactorDoc
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		fn(&doc)
+	}
+	return cur.Err()
+}
@@ -3,11 +3,14 @@ package repository
 
 import (
 	"context"
+	"regexp"
+	"strings"
 
 	"nodosml-pc4/internal/db"
 	"nodosml-pc4/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -135,6 +138,120 @@ func (r *MovieRepository) Top(ctx context.Context, metric string, limit int) ([]
 	return out, cur.Err()
 }
 
+// Popular películas con al menos minCount ratings, de más a menos
+// valoradas, con lo necesario para puntuar sin usuario (géneros, genome
+// tags, ratingStats, año).
+func (r *MovieRepository) Popular(ctx context.Context, minCount, limit int64) ([]models.MovieDoc, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "ratingStats.count", Value: -1}}).
+		SetLimit(limit).
+		SetProjection(bson.M{
+			"movieId": 1, "iIdx": 1, "title": 1, "year": 1,
+			"genres": 1, "genomeTags": 1, "ratingStats": 1,
+		})
+
+	cur, err := r.col.Find(ctx, bson.M{"ratingStats.count": bson.M{"$gte": minCount}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var out []models.MovieDoc
+	for cur.Next(ctx) {
+		var m models.MovieDoc
+		if err := cur.Decode(&m); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, cur.Err()
+}
+
+// ContentFeatures todas las películas con los atributos de contenido
+// (géneros, genome tags, reparto, director) y el iIdx.
+func (r *MovieRepository) ContentFeatures(ctx context.Context) ([]models.MovieDoc, error) {
+	opts := options.Find().SetProjection(bson.M{
+		"movieId": 1, "iIdx": 1, "genres": 1, "genomeTags": 1,
+		"externalData.cast": 1, "externalData.director": 1,
+	})
+
+	cur, err := r.col.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var out []models.MovieDoc
+	for cur.Next(ctx) {
+		var m models.MovieDoc
+		if err := cur.Decode(&m); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, cur.Err()
+}
+
+// FilterIDs devuelve cuáles de ids cumplen el filtro (los géneros se
+// comparan sin distinguir mayúsculas).
+func (r *MovieRepository) FilterIDs(ctx context.Context, ids []int, f models.RecFilter) (map[int]bool, error) {
+	q := bson.M{"movieId": bson.M{"$in": ids}}
+
+	genreRegex := func(gs []string) bson.A {
+		out := bson.A{}
+		for _, g := range gs {
+			out = append(out, primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.TrimSpace(g)) + "$", Options: "i"})
+		}
+		return out
+	}
+	genres := bson.M{}
+	if len(f.Genres) > 0 {
+		genres["$in"] = genreRegex(f.Genres)
+	}
+	if len(f.ExcludeGenres) > 0 {
+		genres["$nin"] = genreRegex(f.ExcludeGenres)
+	}
+	if len(genres) > 0 {
+		q["genres"] = genres
+	}
+
+	rangeOf := func(lo, hi int) bson.M {
+		m := bson.M{}
+		if lo > 0 {
+			m["$gte"] = lo
+		}
+		if hi > 0 {
+			m["$lte"] = hi
+		}
+		return m
+	}
+	if m := rangeOf(f.YearFrom, f.YearTo); len(m) > 0 {
+		q["year"] = m
+	}
+	if f.MinCount > 0 {
+		q["ratingStats.count"] = bson.M{"$gte": f.MinCount}
+	}
+	if m := rangeOf(f.RuntimeMin, f.RuntimeMax); len(m) > 0 {
+		q["externalData.runtime"] = m
+	}
+
+	cur, err := r.col.Find(ctx, q, options.Find().SetProjection(bson.M{"movieId": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make(map[int]bool, len(ids))
+	for cur.Next(ctx) {
+		var m models.MovieDoc
+		if err := cur.Decode(&m); err != nil {
+			return nil, err
+		}
+		out[m.MovieID] = true
+	}
+	return out, cur.Err()
+}
+
 // ExistsByTitleYear indica si ya existe una película con ese título y año.
 // Si year es nil, solo valida por título.
 func (r *MovieRepository) ExistsByTitleYear(ctx context.Context, title string, year *int) (bool, error) {
@@ -152,3 +269,107 @@ func (r *MovieRepository) ExistsByTitleYear(ctx context.Context, title string, y
 	}
 	return n > 0, nil
 }
+
+// IIdxMapping devuelve movieId -> iIdx de todas las películas mapeadas.
+func (r *MovieRepository) IIdxMapping(ctx context.Context) (map[int]int, error) {
+	opts := options.Find().SetProjection(bson.M{"movieId": 1, "iIdx": 1})
+
+	cur, err := r.col.Find(ctx, bson.M{"iIdx": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make(map[int]int)
+	for cur.Next(ctx) {
+		var m models.MovieDoc
+		if err := cur.Decode(&m); err != nil {
+			return nil, err
+		}
+		if m.IIdx != nil {
+			out[m.MovieID] = *m.IIdx
+		}
+	}
+	return out, cur.Err()
+}
+
+// GetByIIdxs devuelve las películas (solo movieId/iIdx) con esos iIdx.
+func (r *MovieRepository) GetByIIdxs(ctx context.Context, iIdxs []int) ([]models.MovieDoc, error) {
+	opts := options.Find().SetProjection(bson.M{"movieId": 1, "iIdx": 1})
+
+	cur, err := r.col.Find(ctx, bson.M{"iIdx": bson.M{"$in": iIdxs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var out []models.MovieDoc
+	for cur.Next(ctx) {
+		var m models.MovieDoc
+		if err := cur.Decode(&m); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, cur.Err()
+}
+
+// GetCardsByIDs devuelve movieId -> datos mínimos para mostrar una
+// tarjeta (título, año, géneros, póster) de las películas pedidas.
+func (r *MovieRepository) GetCardsByIDs(ctx context.Context, movieIDs []int) (map[int]models.MovieDoc, error) {
+	out := make(map[int]models.MovieDoc, len(movieIDs))
+	if len(movieIDs) == 0 {
+		return out, nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{
+		"movieId": 1, "title": 1, "year": 1, "genres": 1,
+		"externalData.posterUrl": 1,
+	})
+	cur, err := r.col.Find(ctx, bson.M{"movieId": bson.M{"$in": movieIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var m models.MovieDoc
+		if err := cur.Decode(&m); err != nil {
+			return nil, err
+		}
+		out[m.MovieID] = m
+	}
+	return out, cur.Err()
+}
+
+// IIdxByMovieIDs devuelve movieId -> iIdx para las películas pedidas que
+// tengan iIdx asignado.
+func (r *MovieRepository) IIdxByMovieIDs(ctx context.Context, movieIDs []int) (map[int]int, error) {
+	out := make(map[int]int, len(movieIDs))
+	if len(movieIDs) == 0 {
+		return out, nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"movieId": 1, "iIdx": 1})
+	filter := bson.M{
+		"movieId": bson.M{"$in": movieIDs},
+		"iIdx":    bson.M{"$exists": true},
+	}
+
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var m models.MovieDoc
+		if err := cur.Decode(&m); err != nil {
+			return nil, err
+		}
+		if m.IIdx != nil {
+			out[m.MovieID] = *m.IIdx
+		}
+	}
+	return out, cur.Err()
+}
@@ -2,9 +2,12 @@ package repository
 
 import (
 	"context"
+	"math"
+	"sort"
 	"time"
 
 	"nodosml-pc4/internal/db"
+	"nodosml-pc4/internal/ml"
 	"nodosml-pc4/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -81,6 +84,128 @@ func (r *RatingRepository) GetByUser(ctx context.Context, userID, limit, offset
 	if err != nil {
 		return nil, err
 	}
+	return decodeRatings(ctx, cur)
+}
+
+// GetByMovie devuelve todos los ratings de una película.
+func (r *RatingRepository) GetByMovie(ctx context.Context, movieID int) ([]models.RatingDoc, error) {
+	cur, err := r.col.Find(ctx, bson.M{"movieId": movieID})
+	if err != nil {
+		return nil, err
+	}
+	return decodeRatings(ctx, cur)
+}
+
+// GetByUsers devuelve todos los ratings de un conjunto de usuarios
+// (en bloques para no armar un $in gigante).
+func (r *RatingRepository) GetByUsers(ctx context.Context, userIDs []int) ([]models.RatingDoc, error) {
+	const chunk = 500
+
+	var out []models.RatingDoc
+	for i := 0; i < len(userIDs); i += chunk {
+		j := i + chunk
+		if j > len(userIDs) {
+			j = len(userIDs)
+		}
+
+		cur, err := r.col.Find(ctx, bson.M{"userId": bson.M{"$in": userIDs[i:j]}})
+		if err != nil {
+			return nil, err
+		}
+		part, err := decodeRatings(ctx, cur)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, part...)
+	}
+	return out, nil
+}
+
+// ItemNorms devuelve movieId -> norma L2 del vector de ratings de la película.
+func (r *RatingRepository) ItemNorms(ctx context.Context) (map[int]float64, error) {
+	pipeline := bson.A{
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$movieId"},
+			{Key: "sumSq", Value: bson.D{{Key: "$sum", Value: bson.D{
+				{Key: "$multiply", Value: bson.A{"$rating", "$rating"}},
+			}}}},
+		}}},
+	}
+
+	cur, err := r.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	norms := make(map[int]float64)
+	for cur.Next(ctx) {
+		var raw bson.M
+		if err := cur.Decode(&raw); err != nil {
+			return nil, err
+		}
+		norms[asInt(raw["_id"])] = math.Sqrt(asFloat64(raw["sumSq"]))
+	}
+	return norms, cur.Err()
+}
+
+// ItemStats devuelve movieId -> suma y cantidad de ratings (para los
+// sesgos del predictor base).
+func (r *RatingRepository) ItemStats(ctx context.Context) (map[int]ml.ItemStat, error) {
+	pipeline := bson.A{
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$movieId"},
+			{Key: "sum", Value: bson.D{{Key: "$sum", Value: "$rating"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+
+	cur, err := r.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	stats := make(map[int]ml.ItemStat)
+	for cur.Next(ctx) {
+		var raw bson.M
+		if err := cur.Decode(&raw); err != nil {
+			return nil, err
+		}
+		stats[asInt(raw["_id"])] = ml.ItemStat{
+			Sum:   asFloat64(raw["sum"]),
+			Count: asInt(raw["count"]),
+		}
+	}
+	return stats, cur.Err()
+}
+
+// ForEach recorre toda la colección ratings (userId/movieId/rating/timestamp).
+// Se usa para entrenar y evaluar modelos sobre el dataset completo.
+func (r *RatingRepository) ForEach(ctx context.Context, fn func(rd *models.RatingDoc)) error {
+	opts := options.Find().
+		SetProjection(bson.M{"userId": 1, "movieId": 1, "rating": 1, "timestamp": 1}).
+		SetBatchSize(10000)
+
+	cur, err := r.col.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var rd models.RatingDoc
+		if err := cur.Decode(&rd); err != nil {
+			return err
+		}
+		fn(&rd)
+	}
+	return cur.Err()
+}
+
+// decodeRatings recorre el cursor casteando con cuidado (en el NDJSON
+// original hay ratings guardados como int32 y como double).
+func decodeRatings(ctx context.Context, cur *mongo.Cursor) ([]models.RatingDoc, error) {
 	defer cur.Close(ctx)
 
 	var out []models.RatingDoc
@@ -101,6 +226,20 @@ func (r *RatingRepository) GetByUser(ctx context.Context, userID, limit, offset
 	return out, cur.Err()
 }
 
+// ActiveUsers devuelve (ordenados) los usuarios que valoraron algo desde since.
+func (r *RatingRepository) ActiveUsers(ctx context.Context, since time.Time) ([]int, error) {
+	vals, err := r.col.Distinct(ctx, "userId", bson.M{"timestamp": bson.M{"$gte": since.Unix()}})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]int, 0, len(vals))
+	for _, v := range vals {
+		out = append(out, asInt(v))
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
 func (r *RatingRepository) GetAllByUser(ctx context.Context, userID int) ([]models.RatingDoc, error) {
 	return r.GetByUser(ctx, userID, 10000, 0)
 }
@@ -120,3 +259,29 @@ func (r *RatingRepository) GetOne(ctx context.Context, userID, movieID int) (*mo
 	}
 	return &rd, nil
 }
+
+// UserMeans devuelve userId -> rating promedio del usuario.
+func (r *RatingRepository) UserMeans(ctx context.Context) (map[int]float64, error) {
+	pipeline := bson.A{
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$userId"},
+			{Key: "avg", Value: bson.D{{Key: "$avg", Value: "$rating"}}},
+		}}},
+	}
+
+	cur, err := r.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	means := make(map[int]float64)
+	for cur.Next(ctx) {
+		var raw bson.M
+		if err := cur.Decode(&raw); err != nil {
+			return nil, err
+		}
+		means[asInt(raw["_id"])] = asFloat64(raw["avg"])
+	}
+	return means, cur.Err()
+}
@@ -7,7 +7,10 @@ import (
 	"nodosml-pc4/internal/db"
 	"nodosml-pc4/internal/models"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type RecommendationRepository struct {
@@ -16,7 +19,10 @@ type RecommendationRepository struct {
 
 func NewRecommendationRepository() *RecommendationRepository {
 	return &RecommendationRepository{
-		col: db.DB().Collection("recommendations"),
+		// params es libre (any): que se lea como mapa y no como bson.D, así
+		// el historial sale por la API como JSON normal
+		col: db.DB().Collection("recommendations",
+			options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true})),
 	}
 }
 
@@ -28,15 +34,25 @@ func (r *RecommendationRepository) Insert(ctx context.Context, rec *models.Recom
 	return err
 }
 
-// opcional para futuro: listar historial por usuario
-func (r *RecommendationRepository) FindByUser(ctx context.Context, userID int, limit int64) ([]models.Recommendation, error) {
-	cur, err := r.col.Find(ctx, map[string]any{"userId": userID})
+// FindByUser historial de un usuario, del más reciente al más viejo.
+// Paginado por createdAt: si before no es cero devuelve solo los
+// anteriores a before (el createdAt del último de la página previa).
+func (r *RecommendationRepository) FindByUser(ctx context.Context, userID int, before time.Time, limit int64) ([]models.Recommendation, error) {
+	filter := bson.M{"userId": userID}
+	if !before.IsZero() {
+		filter["createdAt"] = bson.M{"$lt": before}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetLimit(limit)
+
+	cur, err := r.col.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(ctx)
 
-	var out []models.Recommendation
+	out := []models.Recommendation{}
 	for cur.Next(ctx) {
 		var rec models.Recommendation
 		if err := cur.Decode(&rec); err != nil {
@@ -46,3 +62,21 @@ func (r *RecommendationRepository) FindByUser(ctx context.Context, userID int, l
 	}
 	return out, cur.Err()
 }
+
+// FindOne una ejecución del historial de un usuario; nil si no existe (o
+// es de otro usuario).
+func (r *RecommendationRepository) FindOne(ctx context.Context, userID int, id string) (*models.Recommendation, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil
+	}
+	var rec models.Recommendation
+	err = r.col.FindOne(ctx, bson.M{"_id": oid, "userId": userID}).Decode(&rec)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
@@ -2,12 +2,14 @@ package repository
 
 import (
 	"context"
+	"fmt"
 
 	"nodosml-pc4/internal/db"
 	"nodosml-pc4/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type SimilarityRepository struct {
@@ -18,10 +20,11 @@ func NewSimilarityRepository() *SimilarityRepository {
 	return &SimilarityRepository{col: db.DB().Collection("similarities")}
 }
 
-// Devuelve los vecinos (Neighbor) de una película por movieId, truncando a k.
-func (r *SimilarityRepository) GetNeighbors(ctx context.Context, movieID, k int) ([]models.Neighbor, error) {
+// Devuelve los vecinos (Neighbor) de una película por movieId en la
+// métrica pedida (ml.Metric*), truncando a k.
+func (r *SimilarityRepository) GetNeighbors(ctx context.Context, movieID int, metric string, k int) ([]models.Neighbor, error) {
 	var doc models.SimilarityDoc
-	err := r.col.FindOne(ctx, bson.M{"movieId": movieID}).Decode(&doc)
+	err := r.col.FindOne(ctx, bson.M{"movieId": movieID, "metric": metric}).Decode(&doc)
 	if err == mongo.ErrNoDocuments {
 		return []models.Neighbor{}, nil
 	}
@@ -35,3 +38,78 @@ func (r *SimilarityRepository) GetNeighbors(ctx context.Context, movieID, k int)
 	}
 	return neighbors, nil
 }
+
+// Upsert guarda (o reemplaza) la lista de vecinos de una película para una
+// métrica dada. El _id solo se fija al insertar para no chocar con docs
+// importados que usen otro formato.
+func (r *SimilarityRepository) Upsert(ctx context.Context, doc *models.SimilarityDoc) error {
+	if doc.ID == "" {
+		doc.ID = fmt.Sprintf("%s:%d", doc.Metric, doc.MovieID)
+	}
+
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{"movieId": doc.MovieID, "metric": doc.Metric},
+		bson.M{
+			"$set": bson.M{
+				"iIdx":      doc.IIdx,
+				"k":         doc.K,
+				"neighbors": doc.Neighbors,
+				"updatedAt": doc.UpdatedAt,
+			},
+			"$setOnInsert": bson.M{"_id": doc.ID},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// ForEach recorre todos los documentos de similarities llamando a fn.
+// Se usa para cargar en memoria el grafo completo.
+func (r *SimilarityRepository) ForEach(ctx context.Context, fn func(doc *models.SimilarityDoc)) error {
+	return r.forEach(ctx, bson.M{}, fn)
+}
+
+// IIdxs devuelve los iIdx distintos que tienen documentos en similarities.
+func (r *SimilarityRepository) IIdxs(ctx context.Context) ([]int, error) {
+	vals, err := r.col.Distinct(ctx, "iIdx", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]int, 0, len(vals))
+	for _, v := range vals {
+		out = append(out, asInt(v))
+	}
+	return out, nil
+}
+
+// máximo de iIdxs por consulta $in
+const iIdxBatch = 5000
+
+// ForEachIIdx recorre solo los documentos de los iIdxs dados (la partición
+// de un nodo): el filtro lo hace Mongo y no viaja el resto del grafo.
+func (r *SimilarityRepository) ForEachIIdx(ctx context.Context, iIdxs []int, fn func(doc *models.SimilarityDoc)) error {
+	for i := 0; i < len(iIdxs); i += iIdxBatch {
+		j := min(i+iIdxBatch, len(iIdxs))
+		if err := r.forEach(ctx, bson.M{"iIdx": bson.M{"$in": iIdxs[i:j]}}, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SimilarityRepository) forEach(ctx context.Context, filter bson.M, fn func(doc *models.SimilarityDoc)) error {
+	cur, err := r.col.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var doc models.SimilarityDoc
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		fn(&doc)
+	}
+	return cur.Err()
+}
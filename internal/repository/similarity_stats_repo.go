@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"nodosml-pc4/internal/db"
+	"nodosml-pc4/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SimilarityStatsRepository cola de cambios de ratings (similarity_updates)
+// y estadísticas de co-ratings por película (similarity_stats) para la
+// actualización incremental de similitudes.
+type SimilarityStatsRepository struct {
+	updates *mongo.Collection
+	stats   *mongo.Collection
+}
+
+func NewSimilarityStatsRepository() *SimilarityStatsRepository {
+	return &SimilarityStatsRepository{
+		updates: db.DB().Collection("similarity_updates"),
+		stats:   db.DB().Collection("similarity_stats"),
+	}
+}
+
+// Enqueue encola un cambio de rating.
+func (r *SimilarityStatsRepository) Enqueue(ctx context.Context, c *models.RatingChange) error {
+	_, err := r.updates.InsertOne(ctx, c)
+	return err
+}
+
+// Pending primeros limit cambios encolados, en orden de llegada.
+func (r *SimilarityStatsRepository) Pending(ctx context.Context, limit int64) ([]models.RatingChange, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit)
+	cur, err := r.updates.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var out []models.RatingChange
+	for cur.Next(ctx) {
+		var c models.RatingChange
+		if err := cur.Decode(&c); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, cur.Err()
+}
+
+// PendingCount cambios que quedan en la cola.
+func (r *SimilarityStatsRepository) PendingCount(ctx context.Context) (int64, error) {
+	return r.updates.EstimatedDocumentCount(ctx)
+}
+
+// Ack saca de la cola un cambio ya aplicado.
+func (r *SimilarityStatsRepository) Ack(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = r.updates.DeleteOne(ctx, bson.M{"_id": oid})
+	return err
+}
+
+// SeededAt movieId -> momento de siembra de las películas que ya tienen
+// estadísticas (las que no aparecen no tienen).
+func (r *SimilarityStatsRepository) SeededAt(ctx context.Context, movieIDs []int) (map[int]time.Time, error) {
+	opts := options.Find().SetProjection(bson.M{"seededAt": 1})
+	cur, err := r.stats.Find(ctx, bson.M{"_id": bson.M{"$in": movieIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make(map[int]time.Time)
+	for cur.Next(ctx) {
+		var doc struct {
+			MovieID  int       `bson:"_id"`
+			SeededAt time.Time `bson:"seededAt"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		out[doc.MovieID] = doc.SeededAt
+	}
+	return out, cur.Err()
+}
+
+// Get estadísticas de una película (nil si no tiene).
+func (r *SimilarityStatsRepository) Get(ctx context.Context, movieID int) (*models.ItemPairStats, error) {
+	var s models.ItemPairStats
+	err := r.stats.FindOne(ctx, bson.M{"_id": movieID}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &s, err
+}
+
+// Replace guarda (o reemplaza) las estadísticas sembradas de una película.
+func (r *SimilarityStatsRepository) Replace(ctx context.Context, s *models.ItemPairStats) error {
+	_, err := r.stats.ReplaceOne(ctx, bson.M{"_id": s.MovieID}, s, options.Replace().SetUpsert(true))
+	return err
+}
+
+// ApplyDeltas suma los incrementos con $inc (un update por par). Solo
+// toca documentos existentes: no siembra.
+func (r *SimilarityStatsRepository) ApplyDeltas(ctx context.Context, deltas []models.PairDelta) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, len(deltas))
+	for _, d := range deltas {
+		path := "pairs." + strconv.Itoa(d.Other)
+		inc := bson.M{path + ".dot": d.Dot}
+		if d.Common != 0 {
+			inc[path+".n"] = d.Common
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": d.MovieID}).
+			SetUpdate(bson.M{"$inc": inc, "$set": bson.M{"updatedAt": now}}))
+	}
+	_, err := r.stats.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
@@ -136,3 +136,49 @@ func (r *UserRepository) GetNextUIdx(ctx context.Context) (*int, error) {
 	next := *u.UIdx + 1
 	return &next, nil
 }
+
+// UIdxMapping devuelve userId -> uIdx de los usuarios que tienen índice.
+func (r *UserRepository) UIdxMapping(ctx context.Context) (map[int]int, error) {
+	opts := options.Find().SetProjection(bson.M{"userId": 1, "uIdx": 1})
+
+	cur, err := r.col.Find(ctx, bson.M{"uIdx": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make(map[int]int)
+	for cur.Next(ctx) {
+		var u models.UserDoc
+		if err := cur.Decode(&u); err != nil {
+			return nil, err
+		}
+		if u.UIdx != nil {
+			out[u.UserID] = *u.UIdx
+		}
+	}
+	return out, cur.Err()
+}
+
+// AllIDs devuelve el userId de todos los usuarios, ordenados.
+func (r *UserRepository) AllIDs(ctx context.Context) ([]int, error) {
+	opts := options.Find().
+		SetProjection(bson.M{"userId": 1}).
+		SetSort(bson.D{{Key: "userId", Value: 1}})
+
+	cur, err := r.col.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var out []int
+	for cur.Next(ctx) {
+		var u models.UserDoc
+		if err := cur.Decode(&u); err != nil {
+			return nil, err
+		}
+		out = append(out, u.UserID)
+	}
+	return out, cur.Err()
+}
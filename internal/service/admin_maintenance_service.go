@@ -3,12 +3,18 @@ package service
 import (
 	"context"
 	"errors"
-	"net/http"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"nodosml-pc4/internal/cluster"
 	"nodosml-pc4/internal/config"
 	"nodosml-pc4/internal/db"
+	"nodosml-pc4/internal/ml"
 	"nodosml-pc4/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -23,24 +29,36 @@ type maxIdxDoc struct {
 
 // AdminMaintenanceService orquesta el mantenimiento de mapeos/similitudes.
 type AdminMaintenanceService struct {
-	cfg     *config.Config
-	mlNodes []string
+	cfg   *config.Config
+	nodes *cluster.Registry
+	// actualización incremental de similitudes entre rebuilds
+	simUpdates *SimilarityUpdater
+	// modelo MF activo: sus factores alimentan el índice ANN
+	mf *MFService
 }
 
 // NewAdminMaintenanceService crea el servicio.
-func NewAdminMaintenanceService(cfg *config.Config, mlNodes []string) *AdminMaintenanceService {
+func NewAdminMaintenanceService(cfg *config.Config, nodes *cluster.Registry, simUpdates *SimilarityUpdater, mf *MFService) *AdminMaintenanceService {
 	return &AdminMaintenanceService{
-		cfg:     cfg,
-		mlNodes: mlNodes,
+		cfg:        cfg,
+		nodes:      nodes,
+		simUpdates: simUpdates,
+		mf:         mf,
 	}
 }
 
+// IncrementalStatus estado de la actualización incremental de similitudes.
+func (s *AdminMaintenanceService) IncrementalStatus(ctx context.Context) (*models.IncrementalSimilarityStatus, error) {
+	return s.simUpdates.Status(ctx)
+}
+
 // ---------------------- SUMMARY / PENDING ----------------------
 
-// GetSimilaritySummary devuelve el resumen global.
+// GetSimilaritySummary devuelve el resumen global para una métrica.
 func (s *AdminMaintenanceService) GetSimilaritySummary(
 	ctx context.Context,
 	minRatings int64,
+	metric string,
 ) (*models.AdminSimilaritySummary, error) {
 
 	mdb := db.DB()
@@ -72,10 +90,9 @@ func (s *AdminMaintenanceService) GetSimilaritySummary(
 		moviesWithoutIdx = 0
 	}
 
-	// documentos de similitudes (coseno, k=20)
+	// documentos de similitudes de la métrica (uno por película)
 	simsFilter := bson.M{
-		"metric": "cosine",
-		"k":      20,
+		"metric": metric,
 	}
 	moviesWithSims, err := simsColl.CountDocuments(ctx, simsFilter)
 	if err != nil {
@@ -93,14 +110,17 @@ func (s *AdminMaintenanceService) GetSimilaritySummary(
 		MoviesWithSimilarities:    moviesWithSims,
 		MoviesWithoutSimilarities: moviesWithoutSims,
 		MinRatings:                minRatings,
+		Metric:                    metric,
 	}
 	return summary, nil
 }
 
-// GetPendingSimilarities lista películas pendientes de mapeo / similitudes.
+// GetPendingSimilarities lista películas pendientes de mapeo / similitudes
+// en una métrica.
 func (s *AdminMaintenanceService) GetPendingSimilarities(
 	ctx context.Context,
 	minRatings, limitWithoutIdx, limitWithoutSims int64,
+	metric string,
 ) (*models.AdminPendingSimilarities, error) {
 
 	mdb := db.DB()
@@ -150,7 +170,7 @@ func (s *AdminMaintenanceService) GetPendingSimilarities(
 		return nil, err
 	}
 
-	// ---------- 2) Películas con iIdx pero sin documento en similarities ----------
+	// ---------- 2) Películas con iIdx pero sin documento de la métrica en similarities ----------
 	var withoutSims []models.PendingMovieWithoutSims
 
 	pipeline := bson.A{
@@ -158,12 +178,7 @@ func (s *AdminMaintenanceService) GetPendingSimilarities(
 			{Key: "iIdx", Value: bson.D{{Key: "$exists", Value: true}}},
 			{Key: "ratingStats.count", Value: bson.D{{Key: "$gte", Value: minRatings}}},
 		}}},
-		bson.D{{Key: "$lookup", Value: bson.D{
-			{Key: "from", Value: "similarities"},
-			{Key: "localField", Value: "iIdx"},
-			{Key: "foreignField", Value: "iIdx"},
-			{Key: "as", Value: "sims"},
-		}}},
+		similaritiesLookup([]string{metric}),
 		bson.D{{Key: "$match", Value: bson.D{
 			{Key: "sims", Value: bson.D{{Key: "$size", Value: 0}}},
 		}}},
@@ -208,6 +223,7 @@ func (s *AdminMaintenanceService) GetPendingSimilarities(
 
 	result := &models.AdminPendingSimilarities{
 		MinRatings:          minRatings,
+		Metric:              metric,
 		WithoutIdx:          withoutIdx,
 		WithoutSimilarities: withoutSims,
 	}
@@ -314,7 +330,9 @@ func (s *AdminMaintenanceService) RemapMissingMovies(
 
 // ---------------------- REBUILD SIMILARITIES ----------------------
 
-// RebuildSimilarities recalcula similitudes para películas sin doc en similarities.
+// RebuildSimilarities recalcula similitudes para películas sin doc en
+// similarities en alguna de las métricas pedidas. Cada película solo se
+// recalcula en las métricas que le faltan.
 func (s *AdminMaintenanceService) RebuildSimilarities(
 	ctx context.Context,
 	req *models.RebuildSimilaritiesRequest,
@@ -326,32 +344,60 @@ func (s *AdminMaintenanceService) RebuildSimilarities(
 	if req.Parallelism <= 0 {
 		req.Parallelism = 4
 	}
-	if len(s.mlNodes) == 0 {
-		return nil, errors.New("no hay nodos ML configurados")
+	if len(req.Metrics) == 0 {
+		req.Metrics = []string{ml.DefaultMetric}
+	}
+	if len(s.nodes.Healthy()) == 0 {
+		return nil, ErrNoHealthyNodes
+	}
+
+	// 0) La métrica embedding sale del índice ANN: todos los nodos lo dejan
+	// listo antes de repartir batches, así ninguno lo arma a mitad de uno.
+	var (
+		ann       *cluster.ANNSpec
+		annRecall float64
+	)
+	if slices.Contains(req.Metrics, ml.MetricEmbedding) {
+		annReq := req.ANN
+		if annReq == nil {
+			annReq = &models.ANNIndexRequest{}
+		}
+		results, err := s.BuildANN(ctx, annReq)
+		if err != nil {
+			return nil, err
+		}
+		for i, r := range results {
+			if r.Error != "" {
+				return nil, fmt.Errorf("índice ANN en nodo %s: %s", r.Addr, r.Error)
+			}
+			if i == 0 || r.Index.Recall < annRecall {
+				annRecall = r.Index.Recall
+			}
+			ann = &r.Index.ANNSpec
+		}
 	}
 
 	mdb := db.DB()
 	moviesColl := mdb.Collection("movies")
 
-	// 1) Buscar películas con iIdx pero sin similarities y con minRatings.
+	// 1) Buscar películas con iIdx, con minRatings y sin similarities en
+	// alguna de las métricas; se agrupan por las métricas que les faltan.
 	var pendingIIdxs []int
+	missingOf := make(map[string][]int) // métricas faltantes -> iIdxs
+	var groups [][]string
 
 	pipeline := bson.A{
 		bson.D{{Key: "$match", Value: bson.D{
 			{Key: "iIdx", Value: bson.D{{Key: "$exists", Value: true}}},
 			{Key: "ratingStats.count", Value: bson.D{{Key: "$gte", Value: req.MinRatings}}},
 		}}},
-		bson.D{{Key: "$lookup", Value: bson.D{
-			{Key: "from", Value: "similarities"},
-			{Key: "localField", Value: "iIdx"},
-			{Key: "foreignField", Value: "iIdx"},
-			{Key: "as", Value: "sims"},
-		}}},
+		similaritiesLookup(req.Metrics),
 		bson.D{{Key: "$match", Value: bson.D{
-			{Key: "sims", Value: bson.D{{Key: "$size", Value: 0}}},
+			{Key: "sims.metric", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$all", Value: req.Metrics}}}}},
 		}}},
 		bson.D{{Key: "$project", Value: bson.D{
 			{Key: "iIdx", Value: 1},
+			{Key: "sims.metric", Value: 1},
 		}}},
 	}
 
@@ -364,10 +410,28 @@ func (s *AdminMaintenanceService) RebuildSimilarities(
 	for cur.Next(ctx) {
 		var doc struct {
 			IIdx int `bson:"iIdx"`
+			Sims []struct {
+				Metric string `bson:"metric"`
+			} `bson:"sims"`
 		}
 		if err := cur.Decode(&doc); err != nil {
 			return nil, err
 		}
+		have := make(map[string]bool, len(doc.Sims))
+		for _, sim := range doc.Sims {
+			have[sim.Metric] = true
+		}
+		var missing []string
+		for _, m := range req.Metrics {
+			if !have[m] {
+				missing = append(missing, m)
+			}
+		}
+		key := strings.Join(missing, ",")
+		if _, ok := missingOf[key]; !ok {
+			groups = append(groups, missing)
+		}
+		missingOf[key] = append(missingOf[key], doc.IIdx)
 		pendingIIdxs = append(pendingIIdxs, doc.IIdx)
 	}
 	if err := cur.Err(); err != nil {
@@ -381,36 +445,44 @@ func (s *AdminMaintenanceService) RebuildSimilarities(
 			K:               req.K,
 			MinCommonUsers:  req.MinCommonUsers,
 			Shrink:          req.Shrink,
+			Metrics:         req.Metrics,
+			ANNRecall:       annRecall,
 		}, nil
 	}
 
-	// 2) Particionar en batches.
-	var batches [][]int
-	for i := 0; i < len(pendingIIdxs); i += req.BatchSize {
-		j := i + req.BatchSize
-		if j > len(pendingIIdxs) {
-			j = len(pendingIIdxs)
+	// 2) Particionar en batches (cada batch con las mismas métricas faltantes).
+	var batches []simBatch
+	for _, metrics := range groups {
+		iIdxs := missingOf[strings.Join(metrics, ",")]
+		for i := 0; i < len(iIdxs); i += req.BatchSize {
+			j := i + req.BatchSize
+			if j > len(iIdxs) {
+				j = len(iIdxs)
+			}
+			batches = append(batches, simBatch{iIdxs: iIdxs[i:j], metrics: metrics})
 		}
-		batches = append(batches, pendingIIdxs[i:j])
 	}
 
 	// 3) Ejecutar batches en paralelo contra los nodos ML.
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, req.Parallelism)
 	errCh := make(chan error, len(batches))
+	var upserted int64
 
 	for idx, batch := range batches {
 		sem <- struct{}{}
 		wg.Add(1)
 
-		go func(batchNum int, b []int) {
+		go func(batchNum int, b simBatch) {
 			defer wg.Done()
 			defer func() { <-sem }()
 
-			if err := s.callMLNodeForBatch(ctx, batchNum, b, req); err != nil {
+			n, err := s.callMLNodeForBatch(ctx, batchNum, b, req, ann)
+			if err != nil {
 				errCh <- err
 				return
 			}
+			atomic.AddInt64(&upserted, int64(n))
 		}(idx, batch)
 	}
 
@@ -425,29 +497,178 @@ func (s *AdminMaintenanceService) RebuildSimilarities(
 	result := &models.RebuildSimilaritiesResult{
 		ProcessedMovies: len(pendingIIdxs),
 		Batches:         len(batches),
+		Upserted:        int(upserted),
 		K:               req.K,
 		MinCommonUsers:  req.MinCommonUsers,
 		Shrink:          req.Shrink,
+		Metrics:         req.Metrics,
+		ANNRecall:       annRecall,
 	}
+
+	// los nodos tienen el grafo en memoria: que recarguen con lo nuevo
+	go func() {
+		for _, r := range reloadIndexes(context.Background(), s.nodes.Healthy()) {
+			if r.Error != "" {
+				log.Printf("[rebuild] recarga de índice en %s falló: %s", r.Addr, r.Error)
+			}
+		}
+	}()
+
 	return result, nil
 }
 
-// callMLNodeForBatch manda un batch de iIdxs a cualquier nodo ML (round-robin simple).
+// simBatch iIdxs de un batch del rebuild y las métricas a calcularles.
+type simBatch struct {
+	iIdxs   []int
+	metrics []string
+}
+
+// similaritiesLookup etapa $lookup que trae a "sims" los documentos de
+// similarities de la película en las métricas dadas.
+func similaritiesLookup(metrics []string) bson.D {
+	return bson.D{{Key: "$lookup", Value: bson.D{
+		{Key: "from", Value: "similarities"},
+		{Key: "let", Value: bson.D{{Key: "iIdx", Value: "$iIdx"}}},
+		{Key: "pipeline", Value: bson.A{
+			bson.D{{Key: "$match", Value: bson.D{
+				{Key: "metric", Value: bson.D{{Key: "$in", Value: metrics}}},
+				{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$iIdx", "$$iIdx"}}}},
+			}}},
+			bson.D{{Key: "$project", Value: bson.D{{Key: "metric", Value: 1}}}},
+		}},
+		{Key: "as", Value: "sims"},
+	}}}
+}
+
+// callMLNodeForBatch manda un batch de iIdxs a un nodo ML sano (round-robin simple)
+// y devuelve cuántos documentos de similarities escribió el nodo.
 func (s *AdminMaintenanceService) callMLNodeForBatch(
 	ctx context.Context,
 	batchNum int,
-	iIdxs []int,
+	batch simBatch,
 	req *models.RebuildSimilaritiesRequest,
-) error {
+	ann *cluster.ANNSpec,
+) (int, error) {
+
+	healthy := s.nodes.Healthy()
+	if len(healthy) == 0 {
+		return 0, ErrNoHealthyNodes
+	}
+	node := healthy[batchNum%len(healthy)]
+
+	// un batch implica leer muchos ratings: damos margen amplio por batch
+	ctxTimeout, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	task := &cluster.SimTask{
+		BatchID:        batchNum,
+		IIdxs:          batch.iIdxs,
+		K:              req.K,
+		MinCommonUsers: req.MinCommonUsers,
+		Shrink:         req.Shrink,
+		Metrics:        batch.metrics,
+	}
+	if slices.Contains(batch.metrics, ml.MetricEmbedding) {
+		task.ANN = ann
+	}
+
+	resp, err := cluster.SendSimTask(ctxTimeout, node, task)
+	if err != nil {
+		var remote *cluster.RemoteError
+		if !errors.As(err, &remote) && ctxTimeout.Err() == nil {
+			s.nodes.MarkDown(node, err)
+		}
+		return 0, fmt.Errorf("batch %d en nodo %s: %w", batchNum, node, err)
+	}
+	return resp.Upserted, nil
+}
+
+// ---------------------- ANN ----------------------
+
+// ANNBuildResult resultado del índice ANN en un nodo.
+type ANNBuildResult struct {
+	Addr  string                    `json:"addr"`
+	Index *cluster.ANNBuildResponse `json:"index,omitempty"`
+	Error string                    `json:"error,omitempty"`
+}
 
-	// De momento lo dejamos como stub: solo selecciona el nodo, arma la URL
-	// y no hace el POST real (para no romper mientras montas el endpoint).
-	_ = ctx
-	node := s.mlNodes[batchNum%len(s.mlNodes)]
-	_ = node
+// armar el grafo de todo el catálogo con efConstruction alto lleva minutos
+const annBuildTimeout = 30 * time.Minute
 
-	// Aquí luego implementarás el POST real al nodo ML.
+// BuildANN pide a todos los nodos sanos, en paralelo, tener listo el índice
+// ANN (de memoria, de disco o armándolo) y medir su recall.
+func (s *AdminMaintenanceService) BuildANN(ctx context.Context, req *models.ANNIndexRequest) ([]ANNBuildResult, error) {
+	spec, err := s.annSpec(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	addrs := s.nodes.Healthy()
+	if len(addrs) == 0 {
+		return nil, ErrNoHealthyNodes
+	}
+
+	task := &cluster.ANNBuildTask{
+		ANNSpec:      spec,
+		Rebuild:      req.Rebuild,
+		RecallSample: req.RecallSample,
+		RecallK:      req.RecallK,
+	}
+	if task.RecallSample <= 0 {
+		task.RecallSample = 200
+	}
+	if task.RecallK <= 0 {
+		task.RecallK = 20
+	}
 
-	_ = (&http.Client{Timeout: 120 * time.Second})
-	return nil
+	ctx, cancel := context.WithTimeout(ctx, annBuildTimeout)
+	defer cancel()
+
+	out := make([]ANNBuildResult, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			out[i].Addr = addr
+			resp, err := cluster.BuildANN(ctx, addr, task)
+			if err != nil {
+				out[i].Error = err.Error()
+				return
+			}
+			out[i].Index = resp
+		}(i, addr)
+	}
+	wg.Wait()
+	return out, nil
+}
+
+// annSpec valida la fuente de vectores y resuelve el modelo MF (el activo
+// si no se indica uno).
+func (s *AdminMaintenanceService) annSpec(ctx context.Context, req *models.ANNIndexRequest) (cluster.ANNSpec, error) {
+	source, err := ml.ParseEmbeddingSource(req.Source)
+	if err != nil {
+		return cluster.ANNSpec{}, err
+	}
+	spec := cluster.ANNSpec{
+		Source:         source,
+		M:              req.M,
+		EfConstruction: req.EfConstruction,
+		EfSearch:       req.EfSearch,
+	}
+	if source == ml.EmbeddingMF {
+		var m *models.MFModel
+		if req.ModelID != "" {
+			m, err = s.mf.Get(ctx, req.ModelID)
+		} else {
+			m, err = s.mf.Active(ctx)
+		}
+		if err != nil {
+			return spec, err
+		}
+		if m.Status != models.MFStatusReady {
+			return spec, fmt.Errorf("%w: el modelo %s está en estado %s", ErrMFModelNotReady, m.ID, m.Status)
+		}
+		spec.ModelID = m.ID
+	}
+	return spec, nil
 }
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/cluster"
+)
+
+var (
+	ErrInvalidNodeAddr = errors.New("dirección de nodo inválida (se espera host:puerto)")
+	ErrNodeNotFound    = errors.New("nodo no registrado")
+)
+
+// ClusterService expone el registro de nodos ML (estado, alta y baja).
+type ClusterService struct {
+	nodes *cluster.Registry
+}
+
+func NewClusterService(nodes *cluster.Registry) *ClusterService {
+	return &ClusterService{nodes: nodes}
+}
+
+// ListNodes devuelve el estado de todos los nodos conocidos.
+func (s *ClusterService) ListNodes() []cluster.NodeStatus {
+	return s.nodes.All()
+}
+
+// RegisterNode da de alta un nodo y devuelve su estado tras el primer ping.
+func (s *ClusterService) RegisterNode(ctx context.Context, addr string) (*cluster.NodeStatus, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, ErrInvalidNodeAddr
+	}
+	st := s.nodes.Register(ctx, addr)
+	return &st, nil
+}
+
+// DeregisterNode da de baja un nodo.
+func (s *ClusterService) DeregisterNode(addr string) error {
+	if !s.nodes.Deregister(addr) {
+		return ErrNodeNotFound
+	}
+	return nil
+}
+
+// NodeStats pide las estadísticas internas de un nodo.
+func (s *ClusterService) NodeStats(ctx context.Context, addr string) (*cluster.NodeStats, error) {
+	if _, ok := s.nodes.Get(addr); !ok {
+		return nil, ErrNodeNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return cluster.Stats(ctx, addr)
+}
+
+// IndexReloadResult resultado de pedir la recarga del índice a un nodo.
+type IndexReloadResult struct {
+	Addr  string              `json:"addr"`
+	Index *cluster.IndexStats `json:"index,omitempty"`
+	Error string              `json:"error,omitempty"`
+}
+
+// ReloadIndex pide a un nodo que recargue su índice de vecinos desde Mongo.
+func (s *ClusterService) ReloadIndex(ctx context.Context, addr string) (*cluster.IndexStats, error) {
+	if _, ok := s.nodes.Get(addr); !ok {
+		return nil, ErrNodeNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, indexReloadTimeout)
+	defer cancel()
+	return cluster.ReloadIndex(ctx, addr)
+}
+
+// ReloadAllIndexes pide la recarga a todos los nodos sanos en paralelo.
+func (s *ClusterService) ReloadAllIndexes(ctx context.Context) []IndexReloadResult {
+	return reloadIndexes(ctx, s.nodes.Healthy())
+}
+
+// la carga lee de similarities toda la partición del nodo
+const indexReloadTimeout = 5 * time.Minute
+
+func reloadIndexes(ctx context.Context, addrs []string) []IndexReloadResult {
+	ctx, cancel := context.WithTimeout(ctx, indexReloadTimeout)
+	defer cancel()
+
+	out := make([]IndexReloadResult, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			out[i].Addr = addr
+			st, err := cluster.ReloadIndex(ctx, addr)
+			if err != nil {
+				out[i].Error = err.Error()
+				return
+			}
+			out[i].Index = st
+		}(i, addr)
+	}
+	wg.Wait()
+	return out
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+)
+
+// AlgoColdStart se registra en el historial cuando el usuario no tiene ratings.
+const AlgoColdStart = "cold-start"
+
+// ColdStartThreshold cantidad de ratings a partir de la cual la lista es
+// 100% colaborativa. Por debajo se mezcla con el arranque en frío con
+// peso alpha = ratings / ColdStartThreshold para el colaborativo.
+const ColdStartThreshold = 20
+
+const (
+	coldCandidates = 3000 // películas más populares que entran al ranking
+	coldMinCount   = 20   // mínimo de ratings para ser candidata
+	coldPriorCount = 50.0 // peso del prior del promedio bayesiano
+	coldSeedMovies = 30   // populares por género preferido para el perfil de tags
+	coldLikedMin   = 4.0  // ratings >= a esto suman al perfil de tags
+)
+
+// pesos del score de arranque en frío (sin géneros preferidos solo cuenta popularidad)
+const (
+	coldWeightPop   = 0.4
+	coldWeightGenre = 0.35
+	coldWeightTags  = 0.25
+)
+
+// coldStart puntúa sin historial: popularidad (promedio bayesiano de
+// ratingStats), afinidad con los géneros preferidos del usuario y
+// parecido de genome tags con un perfil armado con esos géneros (y con lo
+// que ya le gustó, si tiene algún rating).
+type coldStart struct {
+	movies *repository.MovieRepository
+	users  *repository.UserRepository
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	cands     []models.MovieDoc
+	tags      []ml.TagVector // mismo orden que cands
+	pop       []float64      // popularidad normalizada [0,1]
+	byMovie   map[int]int    // movieId -> posición en cands
+	loadedAt  time.Time
+	priorMean float64
+}
+
+func newColdStart(movies *repository.MovieRepository, users *repository.UserRepository, ttl time.Duration) *coldStart {
+	return &coldStart{movies: movies, users: users, ttl: ttl}
+}
+
+// load trae (o reutiliza) el universo de candidatas.
+func (c *coldStart) load(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cands != nil && time.Since(c.loadedAt) < c.ttl {
+		return nil
+	}
+	cands, err := c.movies.Popular(ctx, coldMinCount, coldCandidates)
+	if err != nil {
+		return err
+	}
+
+	// prior = promedio de los promedios, ponderado por cantidad
+	var sum, n float64
+	for _, m := range cands {
+		sum += m.RatingStats.Average * float64(m.RatingStats.Count)
+		n += float64(m.RatingStats.Count)
+	}
+	prior := 3.5
+	if n > 0 {
+		prior = sum / n
+	}
+
+	tags := make([]ml.TagVector, len(cands))
+	pop := make([]float64, len(cands))
+	byMovie := make(map[int]int, len(cands))
+	for i, m := range cands {
+		tags[i] = ml.TagVectorOf(m.GenomeTags)
+		pop[i] = ml.NormalizeRating(ml.BayesianAverage(m.RatingStats.Average, m.RatingStats.Count, prior, coldPriorCount))
+		byMovie[m.MovieID] = i
+	}
+
+	c.cands, c.tags, c.pop, c.byMovie = cands, tags, pop, byMovie
+	c.priorMean, c.loadedAt = prior, time.Now()
+	return nil
+}
+
+// scores devuelve movieId -> score de arranque en frío en [0,1].
+func (c *coldStart) scores(ctx context.Context, userID int, ratings []models.RatingDoc) (map[int]float64, error) {
+	if err := c.load(ctx); err != nil {
+		return nil, err
+	}
+	u, err := c.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var preferred []string
+	if u != nil {
+		preferred = u.PreferredGenres
+	}
+
+	c.mu.Lock()
+	cands, tags, pop, byMovie := c.cands, c.tags, c.pop, c.byMovie
+	c.mu.Unlock()
+
+	// perfil de tags: populares de los géneros preferidos + lo que le gustó
+	profile := ml.TagVector{}
+	if len(preferred) > 0 {
+		seeds := 0
+		for i, m := range cands {
+			if seeds >= coldSeedMovies*len(preferred) {
+				break
+			}
+			if ml.GenreAffinity(m.Genres, preferred) > 0 {
+				profile.AddScaled(tags[i], 1)
+				seeds++
+			}
+		}
+	}
+	for _, r := range ratings {
+		if i, ok := byMovie[r.MovieID]; ok && r.Rating >= coldLikedMin {
+			profile.AddScaled(tags[i], r.Rating-coldLikedMin+1)
+		}
+	}
+
+	out := make(map[int]float64, len(cands))
+	for i, m := range cands {
+		score := pop[i]
+		if len(preferred) > 0 || len(profile) > 0 {
+			score = coldWeightPop*pop[i] +
+				coldWeightGenre*ml.GenreAffinity(m.Genres, preferred) +
+				coldWeightTags*tags[i].Cosine(profile)
+		}
+		out[m.MovieID] = score
+	}
+	return out, nil
+}
+
+// blend mezcla la lista colaborativa con el arranque en frío:
+// alpha*colaborativo + (1-alpha)*frío, ambos en [0,1] (norm lleva el score
+// colaborativo a [0,1]; nil = escala de rating). Lo que el usuario ya
+// valoró no entra. Devuelve la lista ordenada, sin cortar.
+func (c *coldStart) blend(
+	ctx context.Context,
+	userID int,
+	ratings []models.RatingDoc,
+	collab []models.RecItem,
+	alpha float64,
+	norm func(float64) float64,
+) ([]models.RecItem, error) {
+
+	if norm == nil {
+		norm = ml.NormalizeRating
+	}
+
+	cold, err := c.scores(ctx, userID, ratings)
+	if err != nil {
+		return nil, err
+	}
+
+	rated := make(map[int]bool, len(ratings))
+	for _, r := range ratings {
+		rated[r.MovieID] = true
+	}
+
+	blended := make(map[int]*models.RecItem, len(cold)+len(collab))
+	for id, sc := range cold {
+		if !rated[id] {
+			blended[id] = &models.RecItem{MovieID: id, Score: (1 - alpha) * sc}
+		}
+	}
+	for _, it := range collab {
+		b := blended[it.MovieID]
+		if b == nil {
+			b = &models.RecItem{MovieID: it.MovieID}
+			blended[it.MovieID] = b
+		}
+		b.Score += alpha * norm(it.Score)
+		// en el híbrido se conserva el aporte de cada fuente, escalado
+		if it.Sources != nil {
+			b.Sources = make(map[string]float64, len(it.Sources)+1)
+			for src, v := range it.Sources {
+				b.Sources[src] = alpha * v
+			}
+			b.Sources[AlgoColdStart] = b.Score - alpha*norm(it.Score)
+		}
+	}
+
+	items := make([]models.RecItem, 0, len(blended))
+	for _, b := range blended {
+		items = append(items, *b)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Score > items[j].Score })
+	return items, nil
+}
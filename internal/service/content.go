@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+)
+
+const (
+	// mínimo de candidatas que devuelve algo=content (la lista se corta a
+	// K después)
+	contentPool = 500
+	// vecinos por contenido de un ítem valorado sin similitudes
+	contentFallbackK = 50
+)
+
+// contentIndexCache índice de contenido del catálogo, recargado cada ttl
+// (así las películas recién aprobadas entran sin reiniciar la API).
+type contentIndexCache struct {
+	movies *repository.MovieRepository
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	ix       *ml.ContentIndex
+	loadedAt time.Time
+}
+
+func newContentIndexCache(movies *repository.MovieRepository, ttl time.Duration) *contentIndexCache {
+	return &contentIndexCache{movies: movies, ttl: ttl}
+}
+
+func (c *contentIndexCache) get(ctx context.Context) (*ml.ContentIndex, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ix != nil && time.Since(c.loadedAt) < c.ttl {
+		return c.ix, nil
+	}
+	start := time.Now()
+	movies, err := c.movies.ContentFeatures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.ix, c.loadedAt = ml.NewContentIndex(movies), time.Now()
+	log.Printf("[content] índice cargado: películas=%d tiempo=%s", c.ix.Len(), time.Since(start))
+	return c.ix, nil
+}
+
+// contentRecommend algo=content: perfil del usuario (promedio de los
+// vectores de lo que valoró, ponderado por rating - media) contra el
+// catálogo. El score es el coseno, en [-1,1].
+func (s *RecommendService) contentRecommend(ctx context.Context, ratings []models.RatingDoc, pool int) (*collabResult, error) {
+	ix, err := s.content.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rated := make(map[int]bool, len(ratings))
+	for _, r := range ratings {
+		rated[r.MovieID] = true
+	}
+
+	if pool < contentPool {
+		pool = contentPool
+	}
+	scored := ix.Score(ix.Profile(ratings), rated, pool)
+	items := make([]models.RecItem, len(scored))
+	for i, sc := range scored {
+		items[i] = models.RecItem{MovieID: sc.MovieID, Score: sc.Score}
+	}
+	return &collabResult{items: items, norm: normalizeCosine}, nil
+}
+
+// normalizeCosine lleva un coseno a [0,1].
+func normalizeCosine(c float64) float64 { return (c + 1) / 2 }
+
+// contentFallback completa el item-kNN con similitud por contenido donde
+// no hay vecinos precalculados:
+//   - ítems valorados sin documento en similarities (misses de los nodos o
+//     sin iIdx): sus vecinos por contenido entran como vecinos normales.
+//   - películas sin iIdx (recién aprobadas, sin ratings): se puntúan con su
+//     similitud por contenido a lo que el usuario valoró.
+//
+// Devuelve los parciales a sumar y cuántos ítems valorados se cubrieron.
+func (s *RecommendService) contentFallback(
+	ctx context.Context,
+	params ml.PredictParams,
+	ratings []models.RatingDoc,
+	misses map[int]bool,
+) (map[int]*ml.Partial, int, error) {
+
+	ix, err := s.content.get(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rated := make(map[int]bool, len(ratings))
+	for _, r := range ratings {
+		rated[r.MovieID] = true
+	}
+	acc := ml.NewKNNAccumulator(params, rated)
+
+	covered := 0
+	for _, r := range ratings {
+		if !misses[r.MovieID] && ix.HasIIdx(r.MovieID) {
+			continue
+		}
+		if neighs := ix.Similar(r.MovieID, contentFallbackK); len(neighs) > 0 {
+			acc.Add(r, neighs)
+			covered++
+		}
+	}
+
+	for _, target := range ix.MoviesWithoutIIdx() {
+		if rated[target] {
+			continue
+		}
+		for _, r := range ratings {
+			if sim := ix.Sim(target, r.MovieID); sim > 0 {
+				acc.Add(r, []models.Neighbor{{MovieID: target, Sim: sim}})
+			}
+		}
+	}
+
+	return acc.Partials, covered, nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+)
+
+// mmrPoolFactor MMR elige entre las mejores K*mmrPoolFactor candidatas: más
+// lejos el score ya no compensa y el costo es O(K * pool).
+const mmrPoolFactor = 5
+
+// itemSim similitud entre películas para la diversidad: coseno sobre el
+// índice de contenido (genome tags, géneros, reparto, director).
+func (s *RecommendService) itemSim(ctx context.Context) (ml.SimFunc, error) {
+	ix, err := s.content.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ix.Sim, nil
+}
+
+// rerankMMR re-ordena la lista (ya filtrada, ordenada por score) con MMR y
+// la corta a k. Los scores no cambian, solo el orden.
+func rerankMMR(items []models.RecItem, k int, lambda float64, sim ml.SimFunc) []models.RecItem {
+	pool := items
+	if len(pool) > k*mmrPoolFactor {
+		pool = pool[:k*mmrPoolFactor]
+	}
+	ids := make([]int, len(pool))
+	scores := make([]float64, len(pool))
+	for i, it := range pool {
+		ids[i], scores[i] = it.MovieID, it.Score
+	}
+
+	order := ml.MMR(ids, scores, k, lambda, sim)
+	out := make([]models.RecItem, len(order))
+	for i, idx := range order {
+		out[i] = pool[idx]
+	}
+	return out
+}
+
+// listDiversity diversidad intra-lista de la respuesta.
+func listDiversity(items []models.RecItem, sim ml.SimFunc) float64 {
+	ids := make([]int, len(items))
+	for i, it := range items {
+		ids[i] = it.MovieID
+	}
+	return ml.IntraListDiversity(ids, sim)
+}
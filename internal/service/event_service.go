@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+)
+
+// ErrInvalidEvent el evento no tiene un tipo conocido o le falta la película.
+var ErrInvalidEvent = errors.New("evento inválido")
+
+// EventService registra eventos de interacción de los usuarios.
+type EventService struct {
+	events *repository.EventRepository
+	movies *repository.MovieRepository
+}
+
+func NewEventService(events *repository.EventRepository, movies *repository.MovieRepository) *EventService {
+	return &EventService{events: events, movies: movies}
+}
+
+// Record valida y guarda un evento del usuario.
+func (s *EventService) Record(ctx context.Context, userID int, req models.EventRequest) (*models.EventDoc, error) {
+	if !models.ValidEventType(req.Type) {
+		return nil, fmt.Errorf("%w: tipo %q (view | click | watchlist | dismiss)", ErrInvalidEvent, req.Type)
+	}
+	if req.MovieID <= 0 {
+		return nil, fmt.Errorf("%w: falta movieId", ErrInvalidEvent)
+	}
+	movie, err := s.movies.GetByID(ctx, req.MovieID)
+	if err != nil {
+		return nil, err
+	}
+	if movie == nil {
+		return nil, ErrMovieNotFound
+	}
+
+	ev := &models.EventDoc{
+		UserID:           userID,
+		MovieID:          req.MovieID,
+		Type:             req.Type,
+		Source:           req.Source,
+		RecommendationID: req.RecommendationID,
+		CreatedAt:        time.Now(),
+	}
+	if err := s.events.Insert(ctx, ev); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+)
+
+// experimentVariant completa req con la rama del experimento en curso que
+// le toca al usuario. Una petición que ya fija algoritmo, modo, diversidad,
+// vecinos o métrica queda fuera del experimento (no mezclamos configuraciones).
+// Si no se puede leer el experimento se recomienda sin él.
+func (s *RecommendService) experimentVariant(ctx context.Context, req *RecRequest) (*models.ExperimentAssignment, error) {
+	if s.experiments == nil {
+		return nil, nil
+	}
+	if req.Algo != "" || req.Mode != "" || req.MMRLambda != 0 || req.Neighbors != 0 || req.Metric != "" {
+		return nil, nil
+	}
+	a, v, err := s.experiments.Assign(ctx, req.UserID)
+	if err != nil {
+		log.Printf("[experiments] sin experimento para user=%d: %v", req.UserID, err)
+		return nil, nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	req.Algo = v.Algo
+	req.Mode = v.Mode
+	req.MMRLambda = v.MMRLambda
+	req.Neighbors = v.Neighbors
+	if v.Weights != "" {
+		w, err := ml.ParseWeights(v.Weights)
+		if err != nil {
+			return nil, err
+		}
+		req.Weights = w
+	}
+	return a, nil
+}
+
+// expose completa los parámetros efectivos del resultado y cuenta la
+// exposición de la rama (salvo en el precálculo).
+func (s *RecommendService) expose(ctx context.Context, req RecRequest, a *models.ExperimentAssignment, res *models.RecResult) {
+	res.Algo = req.Algo
+	res.Mode = req.Mode
+	res.Metric = req.Metric
+	res.Weights = req.Weights
+	res.MMRLambda = req.MMRLambda
+	res.Experiment = a
+
+	if a == nil || req.background {
+		return
+	}
+	if err := s.experiments.RecordExposure(ctx, *a, req.UserID); err != nil {
+		log.Printf("[experiments] error registrando exposición %s/%s user=%d: %v", a.ExperimentID, a.Variant, req.UserID, err)
+	}
+}
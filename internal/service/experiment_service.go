@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrInvalidExperiment  = errors.New("experimento inválido")
+	ErrExperimentNotFound = errors.New("experimento no encontrado")
+	ErrExperimentExists   = errors.New("ya existe un experimento con ese id")
+	// solo puede haber un experimento en curso a la vez
+	ErrExperimentRunning = errors.New("ya hay un experimento en curso")
+	// start sobre algo que no es borrador / stop sobre algo que no está en curso
+	ErrExperimentState = errors.New("el experimento no está en un estado válido para la operación")
+)
+
+// MaxExperimentNeighbors tope de vecinos por ítem que puede pedir una rama.
+const MaxExperimentNeighbors = 500
+
+var experimentIDRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ExperimentService experimentos A/B sobre las recomendaciones: los
+// usuarios se reparten de forma determinística por userId entre las ramas
+// del experimento en curso.
+type ExperimentService struct {
+	repo   *repository.ExperimentRepository
+	events *repository.EventRepository
+
+	mu        sync.Mutex
+	running   *models.Experiment
+	runningAt time.Time
+}
+
+func NewExperimentService(repo *repository.ExperimentRepository, events *repository.EventRepository) *ExperimentService {
+	return &ExperimentService{repo: repo, events: events}
+}
+
+// Create valida y guarda un experimento en borrador.
+func (s *ExperimentService) Create(ctx context.Context, req models.ExperimentCreateRequest) (*models.Experiment, error) {
+	if req.ID == "" {
+		req.ID = "exp-" + time.Now().UTC().Format("20060102-150405")
+	}
+	if !experimentIDRe.MatchString(req.ID) {
+		return nil, fmt.Errorf("%w: id %q (minúsculas, dígitos, '-' o '_')", ErrInvalidExperiment, req.ID)
+	}
+	if req.Name == "" {
+		req.Name = req.ID
+	}
+	if req.Traffic == 0 {
+		req.Traffic = 100
+	}
+	if req.Traffic < 1 || req.Traffic > 100 {
+		return nil, fmt.Errorf("%w: traffic debe estar entre 1 y 100", ErrInvalidExperiment)
+	}
+	variants, err := validateVariants(req.Variants)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &models.Experiment{
+		ID:          req.ID,
+		Name:        req.Name,
+		Description: req.Description,
+		Status:      models.ExperimentStatusDraft,
+		Traffic:     req.Traffic,
+		Variants:    variants,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.repo.Create(ctx, e); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrExperimentExists
+		}
+		return nil, err
+	}
+	return e, nil
+}
+
+// validateVariants exige al menos dos ramas con nombre único y parámetros
+// que el servicio de recomendaciones entienda.
+func validateVariants(in []models.ExperimentVariant) ([]models.ExperimentVariant, error) {
+	if len(in) < 2 {
+		return nil, fmt.Errorf("%w: se necesitan al menos 2 variantes", ErrInvalidExperiment)
+	}
+	out := make([]models.ExperimentVariant, len(in))
+	seen := make(map[string]bool, len(in))
+	for i, v := range in {
+		if v.Name == "" {
+			return nil, fmt.Errorf("%w: variante %d sin nombre", ErrInvalidExperiment, i)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("%w: variante %q repetida", ErrInvalidExperiment, v.Name)
+		}
+		seen[v.Name] = true
+
+		if v.Weight == 0 {
+			v.Weight = 1
+		}
+		if v.Weight < 0 {
+			return nil, fmt.Errorf("%w: variante %q con peso negativo", ErrInvalidExperiment, v.Name)
+		}
+		if v.Algo != "" {
+			algo, err := ml.ParseAlgo(v.Algo)
+			if err != nil {
+				return nil, fmt.Errorf("%w: variante %q: %v", ErrInvalidExperiment, v.Name, err)
+			}
+			v.Algo = algo
+		}
+		if v.Mode != "" {
+			if _, err := ml.ParseMode(v.Mode); err != nil {
+				return nil, fmt.Errorf("%w: variante %q: %v", ErrInvalidExperiment, v.Name, err)
+			}
+		}
+		if v.Neighbors < 0 || v.Neighbors > MaxExperimentNeighbors {
+			return nil, fmt.Errorf("%w: variante %q: neighbors debe estar entre 0 y %d", ErrInvalidExperiment, v.Name, MaxExperimentNeighbors)
+		}
+		if v.MMRLambda < 0 || v.MMRLambda > ml.NoMMR {
+			return nil, fmt.Errorf("%w: variante %q: mmrLambda debe estar entre 0 y 1", ErrInvalidExperiment, v.Name)
+		}
+		if v.Weights != "" {
+			if v.Algo != ml.AlgoHybrid {
+				return nil, fmt.Errorf("%w: variante %q: weights solo aplica a algo=hybrid", ErrInvalidExperiment, v.Name)
+			}
+			if _, err := ml.ParseWeights(v.Weights); err != nil {
+				return nil, fmt.Errorf("%w: variante %q: %v", ErrInvalidExperiment, v.Name, err)
+			}
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (s *ExperimentService) List(ctx context.Context) ([]models.Experiment, error) {
+	return s.repo.List(ctx)
+}
+
+func (s *ExperimentService) Get(ctx context.Context, id string) (*models.Experiment, error) {
+	e, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, ErrExperimentNotFound
+	}
+	return e, nil
+}
+
+// Start pone en curso un borrador (si no hay otro en curso).
+func (s *ExperimentService) Start(ctx context.Context, id string) (*models.Experiment, error) {
+	e, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// una sola operación condicional: el índice único sobre los running
+	// rechaza el cambio si otro arrancó antes
+	ok, err := s.repo.Start(ctx, id, time.Now())
+	if mongo.IsDuplicateKeyError(err) {
+		if running, _ := s.repo.Running(ctx); running != nil {
+			return nil, fmt.Errorf("%w: %s", ErrExperimentRunning, running.ID)
+		}
+		return nil, ErrExperimentRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s está %s", ErrExperimentState, id, e.Status)
+	}
+	s.invalidate()
+	return s.Get(ctx, id)
+}
+
+// Stop detiene el experimento en curso; las ramas dejan de aplicarse.
+func (s *ExperimentService) Stop(ctx context.Context, id string) (*models.Experiment, error) {
+	e, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.repo.Stop(ctx, id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s está %s", ErrExperimentState, id, e.Status)
+	}
+	s.invalidate()
+	return s.Get(ctx, id)
+}
+
+func (s *ExperimentService) invalidate() {
+	s.mu.Lock()
+	s.running = nil
+	s.runningAt = time.Time{}
+	s.mu.Unlock()
+}
+
+// activeExperiment experimento en curso (cacheado 30s; nil si no hay).
+func (s *ExperimentService) activeExperiment(ctx context.Context) (*models.Experiment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.runningAt.IsZero() && time.Since(s.runningAt) < 30*time.Second {
+		return s.running, nil
+	}
+	e, err := s.repo.Running(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.running, s.runningAt = e, time.Now()
+	return e, nil
+}
+
+// Assign rama del experimento en curso que le toca al usuario (nil si no
+// hay experimento o el usuario queda fuera del tráfico).
+func (s *ExperimentService) Assign(ctx context.Context, userID int) (*models.ExperimentAssignment, *models.ExperimentVariant, error) {
+	e, err := s.activeExperiment(ctx)
+	if err != nil || e == nil {
+		return nil, nil, err
+	}
+	v := assignVariant(e, userID)
+	if v == nil {
+		return nil, nil, nil
+	}
+	return &models.ExperimentAssignment{ExperimentID: e.ID, Variant: v.Name}, v, nil
+}
+
+// RecordExposure cuenta una respuesta servida al usuario en su rama.
+func (s *ExperimentService) RecordExposure(ctx context.Context, a models.ExperimentAssignment, userID int) error {
+	return s.repo.RecordExposure(ctx, a, userID)
+}
+
+// assignVariant reparto determinístico: un hash de id + userId decide si
+// el usuario entra al experimento (traffic %) y otro, independiente, la
+// rama según los pesos. El mismo usuario cae siempre en la misma rama
+// mientras el experimento no cambie.
+func assignVariant(e *models.Experiment, userID int) *models.ExperimentVariant {
+	uid := strconv.Itoa(userID)
+	if e.Traffic < 100 && bucketHash(e.ID+":traffic:"+uid)%100 >= uint32(e.Traffic) {
+		return nil
+	}
+	var total int
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+	b := int(bucketHash(e.ID+":variant:"+uid) % uint32(total))
+	for i := range e.Variants {
+		b -= e.Variants[i].Weight
+		if b < 0 {
+			return &e.Variants[i]
+		}
+	}
+	return nil
+}
+
+func bucketHash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// Report exposiciones y clics por rama. Los clics son los eventos click de
+// los usuarios expuestos mientras el experimento estuvo en curso.
+func (s *ExperimentService) Report(ctx context.Context, id string) (*models.ExperimentReport, error) {
+	e, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	byVariant := make(map[string]*models.VariantReport, len(e.Variants))
+	out := &models.ExperimentReport{Experiment: *e, GeneratedAt: time.Now()}
+	for _, v := range e.Variants {
+		out.Variants = append(out.Variants, models.VariantReport{Variant: v.Name})
+	}
+	for i := range out.Variants {
+		byVariant[out.Variants[i].Variant] = &out.Variants[i]
+	}
+	if e.StartedAt == nil {
+		return out, nil // borrador: todavía no hay datos
+	}
+
+	// rama y primera exposición de cada usuario
+	exposed := make(map[int]*models.ExperimentExposure)
+	err = s.repo.Exposures(ctx, e.ID, func(x *models.ExperimentExposure) {
+		vr := byVariant[x.Variant]
+		if vr == nil {
+			return
+		}
+		vr.Users++
+		vr.Exposures += x.Count
+		exposed[x.UserID] = x
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// solo cuentan los clics en recomendaciones desde la primera exposición
+	// del usuario: los anteriores o de otras pantallas no son de la rama
+	var until time.Time
+	if e.StoppedAt != nil {
+		until = *e.StoppedAt
+	}
+	err = s.events.ForEachRecClick(ctx, *e.StartedAt, until, func(userID int, at time.Time) {
+		if x, ok := exposed[userID]; ok && !at.Before(x.FirstAt) {
+			byVariant[x.Variant].Clicks++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range out.Variants {
+		if vr := &out.Variants[i]; vr.Exposures > 0 {
+			vr.CTR = float64(vr.Clicks) / float64(vr.Exposures)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+)
+
+// eventos implícitos que se leen por usuario (los más recientes)
+const implicitEventLimit = 1000
+
+// feedback eventos del usuario: películas descartadas y pseudo-ratings por
+// feedback implícito (vistas, clics, watchlist) para las que no valoró.
+func (s *RecommendService) feedback(ctx context.Context, userID int, ratings []models.RatingDoc) (map[int]bool, []models.RatingDoc, error) {
+	if s.events == nil {
+		return nil, nil, nil
+	}
+	dismissed, err := s.events.DismissedByUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var types []string
+	for t, w := range s.implicit {
+		if w > 0 {
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		return dismissed, nil, nil
+	}
+	events, err := s.events.RecentByUser(ctx, userID, types, implicitEventLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dismissed, ml.ImplicitRatings(events, ratings, dismissed, s.implicit), nil
+}
+
+// withoutDismissed quita de la lista las películas descartadas.
+func withoutDismissed(items []models.RecItem, dismissed map[int]bool) []models.RecItem {
+	if len(dismissed) == 0 {
+		return items
+	}
+	out := make([]models.RecItem, 0, len(items))
+	for _, it := range items {
+		if !dismissed[it.MovieID] {
+			out = append(out, it)
+		}
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+)
+
+// hybrid pide candidatas a cada fuente con peso > 0 y mezcla sus scores
+// normalizados (ml.Blend). Las fuentes del cluster corren en esta
+// goroutine, una tras otra, para que OnProgress se siga llamando desde un
+// solo lugar; el contenido (local) corre en paralelo. Si una fuente falla
+// se sigue con las demás y el resultado queda como parcial.
+func (s *RecommendService) hybrid(ctx context.Context, req RecRequest, ratings []models.RatingDoc) (*collabResult, error) {
+	type contentOut struct {
+		res *collabResult
+		err error
+	}
+	var contentCh chan contentOut
+	if req.Weights[ml.AlgoContent] > 0 {
+		contentCh = make(chan contentOut, 1)
+		go func() {
+			res, err := s.contentRecommend(ctx, ratings, candidatePool(req))
+			contentCh <- contentOut{res, err}
+		}()
+	}
+
+	out := &collabResult{norm: func(x float64) float64 { return x }}
+	sources := make(map[string]map[int]float64)
+	var lastErr error
+
+	for _, src := range []string{ml.AlgoItemKNN, ml.AlgoMF} {
+		if req.Weights[src] <= 0 {
+			continue
+		}
+		sub := req
+		sub.Algo = src
+		res, err := s.collaborative(ctx, sub, ratings)
+		if err != nil {
+			log.Printf("[recommend] híbrido: fuente %s falló: %v", src, err)
+			out.partial, lastErr = true, err
+			continue
+		}
+		sources[src] = scoresOf(res.items)
+		out.shards += res.shards
+		out.partial = out.partial || res.partial
+		out.failedShards = append(out.failedShards, res.failedShards...)
+		out.contentCovered += res.contentCovered
+		if res.mfModel != nil {
+			out.mfModel = res.mfModel
+		}
+	}
+
+	if contentCh != nil {
+		c := <-contentCh
+		if c.err != nil {
+			log.Printf("[recommend] híbrido: fuente content falló: %v", c.err)
+			out.partial, lastErr = true, c.err
+		} else {
+			sources[ml.AlgoContent] = scoresOf(c.res.items)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, lastErr
+	}
+	sort.Ints(out.failedShards)
+
+	for _, b := range ml.Blend(sources, req.Weights) {
+		out.items = append(out.items, models.RecItem{MovieID: b.MovieID, Score: b.Score, Sources: b.Sources})
+	}
+	return out, nil
+}
+
+func scoresOf(items []models.RecItem) map[int]float64 {
+	out := make(map[int]float64, len(items))
+	for _, it := range items {
+		out[it.MovieID] = it.Score
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/cluster"
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNoMFModel       = errors.New("no hay un modelo de factorización entrenado")
+	ErrMFModelNotFound = errors.New("modelo no encontrado")
+	ErrMFModelNotReady = errors.New("modelo no terminado")
+)
+
+// tiempo máximo de un entrenamiento completo en el nodo
+const mfTrainTimeout = 6 * time.Hour
+
+// MFService entrena modelos de factorización en los nodos ML y resuelve
+// cuál es el modelo activo (el último terminado).
+type MFService struct {
+	repo  *repository.MFRepository
+	nodes *cluster.Registry
+
+	mu       sync.Mutex
+	active   *models.MFModel
+	activeAt time.Time
+}
+
+func NewMFService(repo *repository.MFRepository, nodes *cluster.Registry) *MFService {
+	return &MFService{repo: repo, nodes: nodes}
+}
+
+// Train registra el modelo y lanza el entrenamiento en un nodo sano. No
+// espera a que termine: el estado se consulta con Get.
+func (s *MFService) Train(ctx context.Context, req models.MFTrainRequest) (*models.MFModel, error) {
+	healthy := s.nodes.Healthy()
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyNodes
+	}
+
+	// sufijo del ObjectID: dos entrenamientos en el mismo segundo no
+	// comparten ID (ni los factores que se guardan con él)
+	now := time.Now()
+	id := "mf-" + now.UTC().Format("20060102-150405") + "-" + primitive.NewObjectID().Hex()[16:]
+	// el nodo que entrena sale del anillo, como el resto del trabajo
+	node := cluster.NewRing(healthy, cluster.DefaultVNodes).OwnerOf(id)
+
+	p := ml.MFParams{
+		Factors:      req.Factors,
+		Epochs:       req.Epochs,
+		LearningRate: req.LearningRate,
+		Reg:          req.Reg,
+	}.WithDefaults()
+
+	m := &models.MFModel{
+		ID:           id,
+		Status:       models.MFStatusTraining,
+		Node:         node,
+		Factors:      p.Factors,
+		Epochs:       p.Epochs,
+		LearningRate: p.LearningRate,
+		Reg:          p.Reg,
+		StartedAt:    now,
+	}
+	if err := s.repo.CreateModel(ctx, m); err != nil {
+		return nil, err
+	}
+
+	go s.train(node, m.ID, p)
+	return m, nil
+}
+
+func (s *MFService) train(node, modelID string, p ml.MFParams) {
+	ctx, cancel := context.WithTimeout(context.Background(), mfTrainTimeout)
+	defer cancel()
+
+	resp, err := cluster.TrainMF(ctx, node, &cluster.MFTrainTask{
+		ModelID:      modelID,
+		Factors:      p.Factors,
+		Epochs:       p.Epochs,
+		LearningRate: p.LearningRate,
+		Reg:          p.Reg,
+		Seed:         p.Seed,
+	})
+	if err != nil {
+		log.Printf("[mf] entrenamiento %s en %s falló: %v", modelID, node, err)
+		if err := s.repo.MarkFailed(context.Background(), modelID, err.Error()); err != nil {
+			log.Printf("[mf] error marcando %s como fallido: %v", modelID, err)
+		}
+		return
+	}
+	log.Printf("[mf] modelo %s listo: ratings=%d rmse=%.4f (%.0fs)",
+		modelID, resp.Ratings, resp.TrainRMSE, resp.Seconds)
+
+	// pasa a ser el activo y los nodos lo cargan antes de la primera petición
+	s.mu.Lock()
+	s.active = nil
+	s.mu.Unlock()
+
+	for _, addr := range s.nodes.Healthy() {
+		go func(addr string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			defer cancel()
+			if _, err := cluster.LoadMF(ctx, addr, modelID); err != nil {
+				log.Printf("[mf] %s no pudo cargar %s: %v", addr, modelID, err)
+			}
+		}(addr)
+	}
+}
+
+// Active devuelve el modelo activo (cacheado 30s).
+func (s *MFService) Active(ctx context.Context) (*models.MFModel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil && time.Since(s.activeAt) < 30*time.Second {
+		return s.active, nil
+	}
+	m, err := s.repo.LatestReady(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrNoMFModel
+	}
+	s.active, s.activeAt = m, time.Now()
+	return m, nil
+}
+
+// List últimos modelos entrenados (o en entrenamiento).
+func (s *MFService) List(ctx context.Context) ([]models.MFModel, error) {
+	return s.repo.ListModels(ctx, 20)
+}
+
+func (s *MFService) Get(ctx context.Context, id string) (*models.MFModel, error) {
+	m, err := s.repo.GetModel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrMFModelNotFound
+	}
+	return m, nil
+}
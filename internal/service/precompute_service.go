@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+)
+
+var (
+	ErrPrecomputeRunning = errors.New("ya hay un precálculo en curso")
+	ErrNoPrecomputeJob   = errors.New("no hay un precálculo en curso")
+)
+
+const (
+	precomputeDefaultConcurrency = 4
+	precomputeMaxConcurrency     = 16
+	precomputeDefaultTTLHours    = 12
+	// tiempo máximo por usuario (el cluster ya reintenta shards)
+	precomputeUserTimeout = 2 * time.Minute
+)
+
+// PrecomputeService precalcula las recomendaciones de todos los usuarios
+// (o de los activos) antes del pico de tráfico: cada una pasa por
+// Recommend con refresh, así queda en Redis y en el historial igual que
+// una pedida por el cliente. Hay como mucho un job a la vez.
+type PrecomputeService struct {
+	rec     *RecommendService
+	users   *repository.UserRepository
+	ratings *repository.RatingRepository
+
+	mu   sync.Mutex
+	cond *sync.Cond // despierta a los workers en pausa
+	job  *models.PrecomputeJob
+	// cancela el job en curso
+	cancel context.CancelFunc
+	// tiempo corriendo (sin pausas) para la tasa usuarios/s
+	runningFor time.Duration
+	resumedAt  time.Time
+}
+
+func NewPrecomputeService(
+	rec *RecommendService,
+	users *repository.UserRepository,
+	ratings *repository.RatingRepository,
+) *PrecomputeService {
+	s := &PrecomputeService{rec: rec, users: users, ratings: ratings}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Start arma la lista de usuarios y lanza el job en background.
+func (s *PrecomputeService) Start(ctx context.Context, req models.PrecomputeRequest) (*models.PrecomputeJob, error) {
+	// sin algo se deja vacío: así a cada usuario le toca su variante del
+	// experimento en curso, igual que en sus peticiones, y la clave de caché
+	// coincide
+	algo, err := ml.ParseAlgo(req.Algo)
+	if err != nil {
+		return nil, err
+	}
+	if req.Algo != "" {
+		req.Algo = algo
+	}
+	if req.K <= 0 {
+		req.K = DefaultK
+	} else if req.K > MaxK {
+		req.K = MaxK
+	}
+	if req.Concurrency <= 0 {
+		req.Concurrency = precomputeDefaultConcurrency
+	} else if req.Concurrency > precomputeMaxConcurrency {
+		req.Concurrency = precomputeMaxConcurrency
+	}
+	if req.CacheTTLHours <= 0 {
+		req.CacheTTLHours = precomputeDefaultTTLHours
+	}
+
+	s.mu.Lock()
+	if s.active() {
+		s.mu.Unlock()
+		return nil, ErrPrecomputeRunning
+	}
+	s.mu.Unlock()
+
+	var userIDs []int
+	if req.ActiveWithinHours > 0 {
+		since := time.Now().Add(-time.Duration(req.ActiveWithinHours) * time.Hour)
+		userIDs, err = s.ratings.ActiveUsers(ctx, since)
+	} else {
+		userIDs, err = s.users.AllIDs(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active() { // otro Start ganó mientras leíamos los usuarios
+		return nil, ErrPrecomputeRunning
+	}
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	s.job = &models.PrecomputeJob{
+		ID:        "precompute-" + now.UTC().Format("20060102-150405"),
+		Status:    models.PrecomputeStatusRunning,
+		Request:   req,
+		Total:     len(userIDs),
+		StartedAt: now,
+	}
+	s.cancel = cancel
+	s.runningFor, s.resumedAt = 0, now
+
+	go s.run(jobCtx, s.job, userIDs)
+	return s.snapshot(), nil
+}
+
+// active hay un job corriendo o en pausa. Requiere s.mu.
+func (s *PrecomputeService) active() bool {
+	return s.job != nil &&
+		(s.job.Status == models.PrecomputeStatusRunning || s.job.Status == models.PrecomputeStatusPaused)
+}
+
+func (s *PrecomputeService) run(ctx context.Context, job *models.PrecomputeJob, userIDs []int) {
+	req := job.Request
+	log.Printf("[precompute] %s: usuarios=%d k=%d algo=%s concurrencia=%d",
+		job.ID, len(userIDs), req.K, req.Algo, req.Concurrency)
+
+	ids := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < req.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userID := range ids {
+				s.recommendOne(ctx, job, userID)
+			}
+		}()
+	}
+
+feed:
+	for _, userID := range userIDs {
+		if !s.waitWhilePaused(ctx) {
+			break
+		}
+		select {
+		case ids <- userID:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(ids)
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pauseClock()
+	now := time.Now()
+	job.FinishedAt = &now
+	switch {
+	case ctx.Err() != nil:
+		job.Status = models.PrecomputeStatusCancelled
+	case job.Total > 0 && job.Failed == job.Total:
+		job.Status = models.PrecomputeStatusFailed
+	default:
+		job.Status = models.PrecomputeStatusDone
+	}
+	s.cancel()
+	log.Printf("[precompute] %s: %s ok=%d fallidos=%d parciales=%d en %s",
+		job.ID, job.Status, job.Done-job.Failed, job.Failed, job.Partial, s.runningFor)
+}
+
+func (s *PrecomputeService) recommendOne(ctx context.Context, job *models.PrecomputeJob, userID int) {
+	if ctx.Err() != nil {
+		return
+	}
+	uctx, cancel := context.WithTimeout(ctx, precomputeUserTimeout)
+	defer cancel()
+
+	res, err := s.rec.Recommend(uctx, RecRequest{
+		UserID:   userID,
+		K:        job.Request.K,
+		Refresh:  true,
+		Algo:     job.Request.Algo,
+		CacheTTL: time.Duration(job.Request.CacheTTLHours) * time.Hour,
+
+		background: true,
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil {
+		return // cancelado: no cuenta como fallo
+	}
+	job.Done++
+	switch {
+	case err != nil:
+		job.Failed++
+		job.LastError = err.Error()
+	case res.Partial:
+		job.Partial++
+	}
+}
+
+// waitWhilePaused bloquea mientras el job esté en pausa. Devuelve false si
+// se canceló.
+func (s *PrecomputeService) waitWhilePaused(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.job.Status == models.PrecomputeStatusPaused && ctx.Err() == nil {
+		s.cond.Wait()
+	}
+	return ctx.Err() == nil
+}
+
+// Pause deja de despachar usuarios (los que están en curso terminan).
+func (s *PrecomputeService) Pause() (*models.PrecomputeJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.active() {
+		return nil, ErrNoPrecomputeJob
+	}
+	if s.job.Status == models.PrecomputeStatusRunning {
+		s.pauseClock()
+		s.job.Status = models.PrecomputeStatusPaused
+	}
+	return s.snapshot(), nil
+}
+
+// Resume retoma un job en pausa.
+func (s *PrecomputeService) Resume() (*models.PrecomputeJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.active() {
+		return nil, ErrNoPrecomputeJob
+	}
+	if s.job.Status == models.PrecomputeStatusPaused {
+		s.job.Status = models.PrecomputeStatusRunning
+		s.resumedAt = time.Now()
+		s.cond.Broadcast()
+	}
+	return s.snapshot(), nil
+}
+
+// Cancel corta el job; los usuarios en curso se abandonan.
+func (s *PrecomputeService) Cancel() (*models.PrecomputeJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.active() {
+		return nil, ErrNoPrecomputeJob
+	}
+	s.cancel()
+	s.cond.Broadcast()
+	return s.snapshot(), nil
+}
+
+// Status último job (en curso o terminado); nil si nunca se lanzó uno.
+func (s *PrecomputeService) Status() *models.PrecomputeJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.job == nil {
+		return nil
+	}
+	return s.snapshot()
+}
+
+// pauseClock acumula el tiempo corriendo desde el último resume. Requiere s.mu.
+func (s *PrecomputeService) pauseClock() {
+	if !s.resumedAt.IsZero() {
+		s.runningFor += time.Since(s.resumedAt)
+		s.resumedAt = time.Time{}
+	}
+}
+
+// snapshot copia del job con la tasa actualizada. Requiere s.mu.
+func (s *PrecomputeService) snapshot() *models.PrecomputeJob {
+	j := *s.job
+	elapsed := s.runningFor
+	if !s.resumedAt.IsZero() {
+		elapsed += time.Since(s.resumedAt)
+	}
+	if elapsed > 0 {
+		j.Rate = float64(j.Done) / elapsed.Seconds()
+	}
+	return &j
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"log"
 	"time"
 
 	"nodosml-pc4/internal/models"
@@ -12,12 +13,15 @@ import (
 type RatingService struct {
 	ratings *repository.RatingRepository
 	movies  *repository.MovieRepository
+	// cola de la actualización incremental de similitudes (nil = solo rebuild)
+	simUpdates *SimilarityUpdater
 }
 
-func NewRatingService(r *repository.RatingRepository, m *repository.MovieRepository) *RatingService {
+func NewRatingService(r *repository.RatingRepository, m *repository.MovieRepository, simUpdates *SimilarityUpdater) *RatingService {
 	return &RatingService{
-		ratings: r,
-		movies:  m,
+		ratings:    r,
+		movies:     m,
+		simUpdates: simUpdates,
 	}
 }
 
@@ -28,6 +32,8 @@ func (s *RatingService) AddOrUpdate(ctx context.Context, userID, movieID int, ra
 		return err
 	}
 	existedBefore := prev != nil
+	// antes de escribir: lo que se siembre después ya incluye este rating
+	changedAt := time.Now()
 
 	// 2) Upsert del rating (guarda timestamp como epoch)
 	if err := s.ratings.UpsertRating(ctx, userID, movieID, rating); err != nil {
@@ -74,7 +80,28 @@ func (s *RatingService) AddOrUpdate(ctx context.Context, userID, movieID int, ra
 	rs.LastRatedAt = nowStr
 	movie.UpdatedAt = nowStr
 
-	return s.movies.Update(ctx, movie)
+	if err := s.movies.Update(ctx, movie); err != nil {
+		return err
+	}
+
+	// 4) Encolar el cambio para las similitudes (solo películas con iIdx,
+	// las demás no tienen vecinos). No rompemos la escritura si falla.
+	if s.simUpdates != nil && movie.IIdx != nil {
+		change := &models.RatingChange{
+			UserID:  userID,
+			MovieID: movieID,
+			HadOld:  existedBefore,
+			New:     rating,
+			At:      changedAt,
+		}
+		if existedBefore {
+			change.Old = prev.Rating
+		}
+		if err := s.simUpdates.Enqueue(ctx, change); err != nil {
+			log.Printf("[sim-updates] error encolando user=%d movie=%d: %v", userID, movieID, err)
+		}
+	}
+	return nil
 }
 
 func (s *RatingService) GetByUser(ctx context.Context, userID, limit, offset int) ([]models.RatingDoc, error) {
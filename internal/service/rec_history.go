@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"nodosml-pc4/internal/models"
+)
+
+const (
+	DefaultHistoryLimit = 20
+	MaxHistoryLimit     = 100
+)
+
+var (
+	ErrRecRunNotFound = errors.New("ejecución de recomendación no encontrada")
+	// ErrNotEnoughRuns el diff sin ids necesita al menos dos ejecuciones.
+	ErrNotEnoughRuns = errors.New("el usuario tiene menos de dos ejecuciones en el historial")
+)
+
+// History historial de recomendaciones del usuario (más reciente primero),
+// paginado por createdAt.
+func (s *RecommendService) History(ctx context.Context, userID int, before time.Time, limit int) (*models.RecHistoryPage, error) {
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	} else if limit > MaxHistoryLimit {
+		limit = MaxHistoryLimit
+	}
+
+	items, err := s.recRepo.FindByUser(ctx, userID, before, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+	page := &models.RecHistoryPage{Items: items}
+	if len(items) == limit {
+		next := items[len(items)-1].CreatedAt
+		page.NextBefore = &next
+	}
+	return page, nil
+}
+
+// Diff compara dos ejecuciones del historial del usuario. Sin ids compara
+// las dos más recientes; con solo toID, toID contra la anterior a ella.
+func (s *RecommendService) Diff(ctx context.Context, userID int, fromID, toID string) (*models.RecDiff, error) {
+	var from, to *models.Recommendation
+
+	if toID != "" {
+		rec, err := s.recRepo.FindOne(ctx, userID, toID)
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			return nil, ErrRecRunNotFound
+		}
+		to = rec
+	}
+	if fromID != "" {
+		rec, err := s.recRepo.FindOne(ctx, userID, fromID)
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			return nil, ErrRecRunNotFound
+		}
+		from = rec
+	}
+
+	// completar con las más recientes (antes de to, si se indicó)
+	if from == nil || to == nil {
+		var before time.Time
+		if to != nil {
+			before = to.CreatedAt
+		}
+		recent, err := s.recRepo.FindByUser(ctx, userID, before, 2)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case to == nil && from == nil && len(recent) == 2:
+			to, from = &recent[0], &recent[1]
+		case to == nil && from != nil && len(recent) >= 1:
+			to = &recent[0]
+		case to != nil && from == nil && len(recent) >= 1:
+			from = &recent[0]
+		}
+		if from == nil || to == nil {
+			return nil, ErrNotEnoughRuns
+		}
+	}
+
+	// que from sea siempre la más vieja
+	if from.CreatedAt.After(to.CreatedAt) {
+		from, to = to, from
+	}
+	return diffRuns(from, to), nil
+}
+
+func diffRuns(from, to *models.Recommendation) *models.RecDiff {
+	d := &models.RecDiff{
+		From:    runSummary(from),
+		To:      runSummary(to),
+		Entered: []models.RecDiffItem{},
+		Left:    []models.RecDiffItem{},
+		Moved:   []models.RecDiffItem{},
+	}
+
+	fromRank := make(map[int]int, len(from.Items))
+	for i, it := range from.Items {
+		fromRank[it.MovieID] = i + 1
+	}
+	toRank := make(map[int]int, len(to.Items))
+	for i, it := range to.Items {
+		toRank[it.MovieID] = i + 1
+	}
+
+	for i, it := range to.Items {
+		fr, ok := fromRank[it.MovieID]
+		if !ok {
+			d.Entered = append(d.Entered, models.RecDiffItem{MovieID: it.MovieID, ToRank: i + 1, ToScore: it.Score})
+			continue
+		}
+		d.Kept++
+		if fr != i+1 {
+			d.Moved = append(d.Moved, models.RecDiffItem{
+				MovieID:  it.MovieID,
+				FromRank: fr, ToRank: i + 1,
+				FromScore: from.Items[fr-1].Score, ToScore: it.Score,
+			})
+		}
+	}
+	for i, it := range from.Items {
+		if _, ok := toRank[it.MovieID]; !ok {
+			d.Left = append(d.Left, models.RecDiffItem{MovieID: it.MovieID, FromRank: i + 1, FromScore: it.Score})
+		}
+	}
+	return d
+}
+
+func runSummary(r *models.Recommendation) models.RecRunSummary {
+	return models.RecRunSummary{
+		ID:        r.ID,
+		Algo:      r.Algo,
+		Params:    r.Params,
+		CreatedAt: r.CreatedAt,
+		Count:     len(r.Items),
+	}
+}
@@ -2,43 +2,84 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"nodosml-pc4/internal/cache"
 	"nodosml-pc4/internal/cluster"
+	"nodosml-pc4/internal/ml"
 	"nodosml-pc4/internal/models"
 	"nodosml-pc4/internal/repository"
 )
 
+// ErrNoHealthyNodes no hay ningún nodo ML respondiendo.
+var ErrNoHealthyNodes = errors.New("no hay nodos ML disponibles")
+
 const (
 	DefaultK = 20
 	MaxK     = 50 // por seguridad, no deja pedir 1000 ítems
 )
 
+// defaultCacheTTL duración en Redis de una recomendación calculada a pedido.
+const defaultCacheTTL = time.Hour
+
 type RecommendService struct {
 	ratings *repository.RatingRepository
+	movies  *repository.MovieRepository
 	recRepo *repository.RecommendationRepository
 	sims    *repository.SimilarityRepository
-	// direcciones TCP de los nodos ML
-	nodeAddrs []string
+	// registro de nodos ML (solo se despacha a los sanos)
+	nodes *cluster.Registry
+	// media global y sesgos de ítem (modo baseline)
+	baselines *ml.BaselinesCache
+	// modelo de factorización activo (algo=mf)
+	mf *MFService
+	// arranque en frío para usuarios con pocos ratings
+	cold *coldStart
+	// índice de contenido (algo=content y fallback del kNN)
+	content *contentIndexCache
+	// eventos de interacción: descartes y feedback implícito
+	events *repository.EventRepository
+	// rating equivalente de cada tipo de evento implícito (0 = no se usa)
+	implicit map[string]float64
+	// experimentos A/B: rama del usuario cuando la petición no fija parámetros
+	experiments *ExperimentService
 }
 
 func NewRecommendService(
 	r *repository.RatingRepository,
+	movies *repository.MovieRepository,
+	users *repository.UserRepository,
 	recRepo *repository.RecommendationRepository,
 	sims *repository.SimilarityRepository,
-	nodeAddrs []string,
+	nodes *cluster.Registry,
+	mf *MFService,
+	events *repository.EventRepository,
+	implicit map[string]float64,
+	experiments *ExperimentService,
 ) *RecommendService {
 	return &RecommendService{
-		ratings:   r,
-		recRepo:   recRepo,
-		sims:      sims,
-		nodeAddrs: nodeAddrs,
+		ratings: r,
+		movies:  movies,
+		recRepo: recRepo,
+		sims:    sims,
+		nodes:   nodes,
+		mf:      mf,
+
+		events:      events,
+		implicit:    implicit,
+		experiments: experiments,
+
+		baselines: ml.NewBaselinesCache(r.ItemStats, 10*time.Minute),
+		cold:      newColdStart(movies, users, 10*time.Minute),
+		content:   newContentIndexCache(movies, 30*time.Minute),
 	}
 }
 
@@ -48,27 +89,110 @@ type RecRequest struct {
 	UserID  int
 	K       int
 	Refresh bool
+	Algo    string // algoritmo (ml.Algo*); "" = ml.DefaultAlgo
+	Mode    string // modo de predicción del item-kNN (ml.Mode*); "" = ml.DefaultMode
+	// pesos por fuente del modo híbrido (nil = ml.DefaultHybridWeights)
+	Weights map[string]float64
+	// restricciones sobre las películas (género, año, popularidad, duración)
+	Filter models.RecFilter
+	// peso de la relevancia en el re-ranking MMR (0..1); 0 o ml.NoMMR = sin
+	// re-ranking
+	MMRLambda float64
+	// vecinos por ítem valorado en el item-kNN (0 = cluster.DefaultRecNeighbors)
+	Neighbors int
+	// métrica de similitud de esos vecinos (ml.Metric*); "" = ml.DefaultMetric
+	Metric string
+	// duración en Redis del resultado (0 = defaultCacheTTL)
+	CacheTTL time.Duration
+
+	// películas descartadas por el usuario (las completa Recommend)
+	dismissed map[int]bool
+	// cálculo en segundo plano (precompute): no cuenta como exposición
+	background bool
+
+	// OnProgress (opcional) recibe un evento por cada intento de shard a
+	// medida que ocurren. Se llama siempre desde la goroutine de Recommend.
+	OnProgress func(ShardProgress)
 }
 
 func cacheKey(req RecRequest) string {
-	// Cachea por usuario + k (no incluye refresh, refresh solo decide si usar cache)
-	return fmt.Sprintf("rec:user:%d:k:%d", req.UserID, req.K)
+	// Cachea por usuario + k + algoritmo/modo/pesos (no incluye refresh, refresh solo decide si usar cache)
+	key := fmt.Sprintf("rec:user:%d:k:%d:algo:%s:mode:%s", req.UserID, req.K, req.Algo, req.Mode)
+	if req.Algo == ml.AlgoHybrid {
+		key += ":w:" + ml.FormatWeights(req.Weights)
+	}
+	if !req.Filter.IsZero() {
+		key += ":f:" + req.Filter.Key()
+	}
+	if req.MMRLambda < ml.NoMMR {
+		key += fmt.Sprintf(":mmr:%.2f", req.MMRLambda)
+	}
+	if req.Neighbors > 0 {
+		key += fmt.Sprintf(":n:%d", req.Neighbors)
+	}
+	if req.Metric != "" && req.Metric != ml.DefaultMetric {
+		key += ":sim:" + req.Metric
+	}
+	return key
 }
 
 // Recommend: coordina el cluster de nodos ML
-func (s *RecommendService) Recommend(ctx context.Context, req RecRequest) ([]models.RecItem, error) {
+func (s *RecommendService) Recommend(ctx context.Context, req RecRequest) (*models.RecResult, error) {
+	// 0) Experimento A/B: si la petición no fija algoritmo, modo, diversidad,
+	// vecinos ni métrica, se usan los de la rama del usuario
+	assignment, err := s.experimentVariant(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+
 	// defaults y límites para K
 	if req.K <= 0 {
 		req.K = DefaultK
 	} else if req.K > MaxK {
 		req.K = MaxK
 	}
+	algo, err := ml.ParseAlgo(req.Algo)
+	if err != nil {
+		return nil, err
+	}
+	req.Algo = algo
+	mode, err := ml.ParseMode(req.Mode)
+	if err != nil {
+		return nil, err
+	}
+	req.Mode = mode
+	metric, err := ml.ParseMetric(req.Metric)
+	if err != nil {
+		return nil, err
+	}
+	req.Metric = metric
+	if req.Algo != ml.AlgoItemKNN && req.Algo != ml.AlgoHybrid {
+		// el modo y la métrica solo aplican al item-kNN
+		req.Mode, req.Metric = "", ""
+	}
+	if req.Algo == ml.AlgoHybrid && req.Weights == nil {
+		req.Weights, _ = ml.ParseWeights("")
+	}
+	if req.MMRLambda <= 0 || req.MMRLambda > ml.NoMMR {
+		req.MMRLambda = ml.NoMMR
+	}
 
 	// 1) Cache Redis (solo si refresh = false)
 	var cached []models.RecItem
 	if !req.Refresh {
 		if ok, err := cache.GetJSON(ctx, cacheKey(req), &cached); err == nil && ok {
-			return cached, nil
+			// lo descartado después de cachear no debe volver a aparecer
+			if s.events != nil {
+				if dismissed, err := s.events.DismissedByUser(ctx, req.UserID); err == nil {
+					cached = withoutDismissed(cached, dismissed)
+				}
+			}
+			res := &models.RecResult{Items: cached, Cached: true}
+			if sim, err := s.itemSim(ctx); err == nil {
+				res.Diversity = listDiversity(cached, sim)
+			}
+			s.expose(ctx, req, assignment, res)
+			return res, nil
 		}
 	}
 
@@ -77,162 +201,698 @@ func (s *RecommendService) Recommend(ctx context.Context, req RecRequest) ([]mod
 	if err != nil {
 		return nil, err
 	}
-	if len(ratings) == 0 {
-		return []models.RecItem{}, nil
+	// 2.1) Eventos: lo descartado no se recomienda nunca; vistas, clics y
+	// watchlist entran como ratings implícitos
+	dismissed, implicit, err := s.feedback(ctx, req.UserID, ratings)
+	if err != nil {
+		return nil, err
+	}
+	// el arranque en frío se mide solo con ratings explícitos: ver películas
+	// no dice cuánto gustaron
+	explicit := len(ratings)
+	ratings = append(ratings, implicit...)
+	req.dismissed = dismissed
+	// 3-5) Parte colaborativa en el cluster (item-kNN o MF)
+	collab := &collabResult{}
+	if len(ratings) > 0 {
+		collab, err = s.collaborative(ctx, req, ratings)
+		if err != nil {
+			return nil, err
+		}
+	}
+	items := collab.items
+
+	// 5.2) Arranque en frío: con pocos ratings se mezcla con popularidad +
+	// géneros preferidos + genome tags; el peso del colaborativo crece con
+	// la cantidad de ratings explícitos hasta ColdStartThreshold.
+	coldAlpha := 1.0
+	if n := explicit; n < ColdStartThreshold {
+		coldAlpha = float64(n) / ColdStartThreshold
+		items, err = s.cold.blend(ctx, req.UserID, ratings, items, coldAlpha, collab.norm)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if len(s.nodeAddrs) == 0 {
-		return nil, fmt.Errorf("no ML nodes configured (ML_NODE_ADDRS vacío)")
+	items = withoutDismissed(items, dismissed)
+
+	// 5.3) Filtros (después de combinar, antes de cortar a K)
+	if !req.Filter.IsZero() {
+		items, err = s.applyFilter(ctx, items, req.Filter, req.K)
+		if err != nil {
+			return nil, err
+		}
 	}
-	shards := len(s.nodeAddrs)
 
-	// 3) Preparar tareas para cada nodo
-	tasks := make([]*cluster.RecTask, shards)
-	for shardID := 0; shardID < shards; shardID++ {
-		tasks[shardID] = &cluster.RecTask{
-			UserID:  req.UserID,
-			K:       req.K,
-			ShardID: shardID,
-			Shards:  shards,
-			Ratings: ratings,
+	// 5.4) Diversidad: re-ranking MMR opcional y métrica intra-lista. Sin
+	// índice de contenido la lista sale tal cual (no rompemos la respuesta).
+	var diversity float64
+	sim, simErr := s.itemSim(ctx)
+	if simErr != nil {
+		log.Printf("[diversity] sin índice de contenido: %v", simErr)
+	}
+	if req.MMRLambda < ml.NoMMR && simErr == nil {
+		items = rerankMMR(items, req.K, req.MMRLambda, sim)
+	}
+	if len(items) > req.K {
+		items = items[:req.K]
+	}
+	if simErr == nil {
+		diversity = listDiversity(items, sim)
+	}
+
+	// 5.5) Guardar historial en Mongo (no rompemos la respuesta si falla)
+	if s.recRepo != nil {
+		params := map[string]any{
+			"k":      req.K,
+			"shards": collab.shards,
+			// aquí podrías agregar más cosas si luego cambias la lógica
+			"refresh":      req.Refresh,
+			"partial":      collab.partial,
+			"failedShards": collab.failedShards,
+		}
+		if !req.Filter.IsZero() {
+			params["filters"] = req.Filter
+		}
+		if req.MMRLambda < ml.NoMMR {
+			params["mmrLambda"] = req.MMRLambda
+		}
+		params["diversity"] = diversity
+		if len(implicit) > 0 {
+			params["implicitRatings"] = len(implicit)
+		}
+		if len(dismissed) > 0 {
+			params["dismissed"] = len(dismissed)
+		}
+		if req.Neighbors > 0 {
+			params["neighbors"] = req.Neighbors
+		}
+		if assignment != nil {
+			params["experiment"] = assignment
+		}
+		algo, metric := req.Algo, ""
+		switch {
+		case explicit == 0:
+			algo = AlgoColdStart
+		case req.Algo == ml.AlgoHybrid:
+			params["weights"] = req.Weights
+			params["mode"] = req.Mode
+			metric = req.Metric
+			params["contentFallback"] = collab.contentCovered
+			if collab.mfModel != nil {
+				params["modelId"] = collab.mfModel.ID
+			}
+		case collab.mfModel != nil:
+			params["modelId"] = collab.mfModel.ID
+			params["factors"] = collab.mfModel.Factors
+		case req.Algo == ml.AlgoContent:
+		default:
+			params["mode"] = req.Mode
+			params["contentFallback"] = collab.contentCovered
+			metric = req.Metric
+		}
+		if coldAlpha < 1 {
+			params["coldStart"] = map[string]any{
+				"alpha":   coldAlpha,
+				"ratings": explicit,
+			}
+		}
+
+		hist := &models.Recommendation{
+			UserID:           req.UserID,
+			Algo:             algo,
+			SimilarityMetric: metric,
+			Params:           params,
+			Items:            items,
+			CreatedAt:        time.Now(),
+		}
+
+		if err := s.recRepo.Insert(ctx, hist); err != nil {
+			log.Printf("error guardando recomendación en Mongo: %v", err)
+		}
+	}
+
+	// 6) Cachear en Redis (1 hora salvo que se pida otra cosa). Un resultado
+	// parcial no se cachea para que la próxima petición lo recalcule completo.
+	if !collab.partial {
+		ttl := req.CacheTTL
+		if ttl <= 0 {
+			ttl = defaultCacheTTL
+		}
+		if err := cache.SetJSON(ctx, cacheKey(req), items, int(ttl.Seconds())); err != nil {
+			log.Printf("error cacheando recomendación en Redis: %v", err)
+		}
+	}
+
+	res := &models.RecResult{
+		Items:        items,
+		Partial:      collab.partial,
+		FailedShards: collab.failedShards,
+		Diversity:    diversity,
+	}
+	s.expose(ctx, req, assignment, res)
+	return res, nil
+}
+
+// collabResult resultado de la parte colaborativa (ordenado, sin cortar a K).
+type collabResult struct {
+	items        []models.RecItem
+	shards       int
+	partial      bool
+	failedShards []int
+	mfModel      *models.MFModel
+	// ítems valorados sin similitudes que se cubrieron por contenido
+	contentCovered int
+	// lleva el score a [0,1] para mezclar; nil = escala de rating
+	norm func(float64) float64
+}
+
+// collaborative reparte el cálculo entre los nodos ML y combina los
+// parciales de cada shard.
+func (s *RecommendService) collaborative(ctx context.Context, req RecRequest, ratings []models.RatingDoc) (*collabResult, error) {
+	switch req.Algo {
+	case ml.AlgoContent:
+		// no necesita el cluster
+		return s.contentRecommend(ctx, ratings, candidatePool(req))
+	case ml.AlgoHybrid:
+		return s.hybrid(ctx, req, ratings)
+	}
+
+	healthy := s.nodes.Healthy()
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyNodes
+	}
+
+	ring := cluster.NewRing(s.nodes.Members(), cluster.DefaultVNodes)
+
+	// 3) Una tarea por shard del anillo (hashing consistente sobre todos
+	// los nodos registrados), según el algoritmo.
+	var (
+		tasks     []*cluster.RecTask
+		primaries []string
+		mfModel   *models.MFModel
+		params    ml.PredictParams
+		err       error
+	)
+	switch req.Algo {
+	case ml.AlgoMF:
+		mfModel, err = s.mf.Active(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tasks, primaries = mfTasks(req, ratings, ring, mfModel.ID)
+	default:
+		params, err = s.predictParams(ctx, req.Mode, ratings)
+		if err != nil {
+			return nil, err
+		}
+		tasks, primaries, err = s.knnTasks(ctx, req, params, ratings, ring)
+		if err != nil {
+			return nil, err
 		}
 	}
+	// sin tareas (p.e. nada de lo valorado tiene iIdx) el kNN igual puede
+	// salir del fallback por contenido
+	shards := len(tasks)
+	if shards == 0 && req.Algo != ml.AlgoItemKNN {
+		return &collabResult{}, nil
+	}
 
-	// 4) Enviar en paralelo usando goroutines + channels
+	// 4) Enviar en paralelo usando goroutines + channels; cada shard se
+	// reintenta en otros nodos si su nodo principal falla. Cada intento
+	// llega por attemptCh apenas termina, así el progreso es el real.
 	ctxTimeout, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	resCh := make(chan *cluster.RecResponse, shards)
-	errCh := make(chan error, shards)
+	attemptCh := make(chan shardAttempt, shards*(len(healthy)+1))
+	failed := &failedNodes{set: make(map[string]bool)}
+	start := time.Now()
 
 	var wg sync.WaitGroup
-	for i, addr := range s.nodeAddrs {
+	for i := range tasks {
 		wg.Add(1)
-		go func(addr string, t *cluster.RecTask) {
+		go func(t *cluster.RecTask, candidates []string) {
 			defer wg.Done()
-			resp, err := cluster.SendTask(ctxTimeout, addr, t)
-			if err != nil {
-				errCh <- err
-				return
-			}
-			resCh <- resp
-		}(addr, tasks[i])
+			s.runShard(ctxTimeout, candidates, t, failed, start, attemptCh)
+		}(tasks[i], shardCandidates(primaries[i], tasks[i].ShardID, healthy))
 	}
+	go func() {
+		wg.Wait()
+		close(attemptCh)
+	}()
+
+	var (
+		responses    []*cluster.RecResponse
+		failedShards []int
+		lastErr      error
+	)
+	for a := range attemptCh {
+		if req.OnProgress != nil {
+			ev := ShardProgress{
+				ShardID: a.shardID,
+				Node:    a.node,
+				Attempt: a.attempt,
+				Elapsed: a.elapsed,
+				Err:     a.err,
+				Final:   a.final,
+			}
+			if a.resp != nil {
+				ev.Partials = len(a.resp.Partials)
+			}
+			req.OnProgress(ev)
+		}
 
-	wg.Wait()
-	close(resCh)
-	close(errCh)
+		switch {
+		case a.err == nil:
+			responses = append(responses, a.resp)
+		case a.final:
+			failedShards = append(failedShards, a.shardID)
+			lastErr = a.err
+		}
+	}
 
-	if len(resCh) == 0 && len(errCh) > 0 {
+	if len(responses) == 0 && shards > 0 {
 		// si todos fallaron
-		return nil, <-errCh
+		return nil, lastErr
 	}
 
-	// 5) Combinar parciales: score = sum(num) / sum(den)
-	scores := make(map[int]float64)
-	weights := make(map[int]float64)
+	sort.Ints(failedShards)
+	partial := len(failedShards) > 0
+	if partial {
+		log.Printf("[recommend] resultado PARCIAL user=%d: shards fallidos %v de %d",
+			req.UserID, failedShards, shards)
+	}
 
-	for resp := range resCh {
+	// 5) Combinar parciales: score = base + sum(num) / sum(den). La base
+	// depende solo del usuario y la película, es la misma en todo shard.
+	merged := make(map[int]*ml.Partial)
+	addPartial := func(movieID int, p ml.Partial) {
+		m := merged[movieID]
+		if m == nil {
+			m = &ml.Partial{Base: p.Base}
+			merged[movieID] = m
+		}
+		m.Num += p.Num
+		m.Den += p.Den
+	}
+
+	misses := make(map[int]bool)
+	for _, resp := range responses {
 		for _, p := range resp.Partials {
-			scores[p.MovieID] += p.Num
-			weights[p.MovieID] += p.Den
+			addPartial(p.MovieID, ml.Partial{Num: p.Num, Den: p.Den, Base: p.Base})
+		}
+		for _, id := range resp.Misses {
+			misses[id] = true
 		}
 	}
 
+	// 5.1) Lo que no tiene similitudes precalculadas se cubre por contenido
+	// (si el índice de contenido no carga seguimos solo con el kNN)
+	contentCovered := 0
+	if req.Algo == ml.AlgoItemKNN {
+		extra, covered, err := s.contentFallback(ctx, params, ratings, misses)
+		if err != nil {
+			log.Printf("[recommend] fallback por contenido no disponible: %v", err)
+		}
+		for id, p := range extra {
+			addPartial(id, *p)
+		}
+		contentCovered = covered
+	}
+
+	// cada nodo solo ve parte de los ratings: lo ya valorado se filtra aquí
+	rated := make(map[int]bool, len(ratings))
+	for _, r := range ratings {
+		rated[r.MovieID] = true
+	}
+
 	var items []models.RecItem
-	for mID, num := range scores {
-		den := weights[mID]
-		if den <= 0 {
+	for mID, p := range merged {
+		if p.Den <= 0 || rated[mID] {
 			continue
 		}
 		items = append(items, models.RecItem{
 			MovieID: mID,
-			Score:   num / den,
+			Score:   p.Score(),
 		})
 	}
 
 	sort.Slice(items, func(i, j int) bool { return items[i].Score > items[j].Score })
-	if len(items) > req.K {
-		items = items[:req.K]
+
+	return &collabResult{
+		items:          items,
+		shards:         shards,
+		partial:        partial,
+		failedShards:   failedShards,
+		mfModel:        mfModel,
+		contentCovered: contentCovered,
+	}, nil
+}
+
+// knnTasks reparte los ratings por dueño del iIdx en el anillo: cada nodo
+// suma los vecinos de los ítems que tiene en memoria. Sale una tarea por
+// nodo que tenga algo que calcular.
+func (s *RecommendService) knnTasks(
+	ctx context.Context,
+	req RecRequest,
+	params ml.PredictParams,
+	ratings []models.RatingDoc,
+	ring *cluster.Ring,
+) ([]*cluster.RecTask, []string, error) {
+
+	var globalMean float64
+	if params.Baselines != nil {
+		globalMean = params.Baselines.GlobalMean
 	}
 
-	// 5.5) Guardar historial en Mongo (no rompemos la respuesta si falla)
-	if s.recRepo != nil {
-		hist := &models.Recommendation{
-			UserID:           req.UserID,
-			Algo:             "item-knn",
-			SimilarityMetric: "cosine", // en esta PC4 usamos cosine fijo
-			Params: map[string]any{
-				"k":      req.K,
-				"shards": shards,
-				// aquí podrías agregar más cosas si luego cambias la lógica
-				"refresh": req.Refresh,
-			},
-			Items:     items,
-			CreatedAt: time.Now(),
+	ratedIDs := make([]int, len(ratings))
+	for i, r := range ratings {
+		ratedIDs[i] = r.MovieID
+	}
+	iIdxOf, err := s.movies.IIdxByMovieIDs(ctx, ratedIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	members := ring.Members()
+	byOwner := make(map[string][]models.RatingDoc, len(members))
+	for _, r := range ratings {
+		// sin iIdx no hay similitudes precalculadas: no aporta al score
+		idx, ok := iIdxOf[r.MovieID]
+		if !ok {
+			continue
 		}
+		owner := ring.Owner(idx)
+		byOwner[owner] = append(byOwner[owner], r)
+	}
 
-		if err := s.recRepo.Insert(ctx, hist); err != nil {
-			log.Printf("error guardando recomendación en Mongo: %v", err)
+	var tasks []*cluster.RecTask
+	var primaries []string
+	for shardID, m := range members {
+		if len(byOwner[m]) == 0 {
+			continue
+		}
+		tasks = append(tasks, &cluster.RecTask{
+			UserID:  req.UserID,
+			K:       req.K,
+			ShardID: shardID,
+			Shards:  len(members),
+			Ratings: byOwner[m],
+			Ring:    members,
+
+			Algo:       ml.AlgoItemKNN,
+			Mode:       params.Mode,
+			UserMean:   params.UserMean,
+			UserBias:   params.UserBias,
+			GlobalMean: globalMean,
+			Neighbors:  req.Neighbors,
+			Metric:     req.Metric,
+		})
+		primaries = append(primaries, m)
+	}
+	return tasks, primaries, nil
+}
+
+// predictParams datos del usuario para el modo de predicción: se calculan
+// con todos sus ratings porque cada nodo solo recibe los de su shard.
+func (s *RecommendService) predictParams(ctx context.Context, mode string, ratings []models.RatingDoc) (ml.PredictParams, error) {
+	p := ml.PredictParams{Mode: mode}
+	switch mode {
+	case ml.ModeMeanCentered:
+		p.UserMean = ml.UserMean(ratings)
+	case ml.ModeBaseline:
+		b, err := s.baselines.Get(ctx)
+		if err != nil {
+			return p, err
+		}
+		p.Baselines = b
+		p.UserBias = b.UserBias(ratings)
+	}
+	return p, nil
+}
+
+// mfTasks con factorización cada nodo necesita todos los ratings (para
+// armar el vector del usuario) y puntúa los ítems que el anillo le asigna
+// a su shard: sale una tarea por miembro.
+func mfTasks(req RecRequest, ratings []models.RatingDoc, ring *cluster.Ring, modelID string) ([]*cluster.RecTask, []string) {
+	members := ring.Members()
+	tasks := make([]*cluster.RecTask, len(members))
+	for shardID := range members {
+		tasks[shardID] = &cluster.RecTask{
+			UserID:  req.UserID,
+			ShardID: shardID,
+			Shards:  len(members),
+			Ratings: ratings,
+			Ring:    members,
+			Algo:    ml.AlgoMF,
+			// cada nodo devuelve sus mejores candidatas: con filtros hace
+			// falta pedir más para que queden K después de filtrar
+			K:       candidatePool(req),
+			ModelID: modelID,
 		}
 	}
+	return tasks, members
+}
+
+// candidatePool cuántas candidatas pedir a las fuentes que devuelven una
+// lista corta (MF, contenido).
+func candidatePool(req RecRequest) int {
+	k := req.K + len(req.dismissed) // los descartados se quitan después
+	if req.Filter.IsZero() {
+		return k
+	}
+	return k * filterOverfetch
+}
 
-	// 6) Cachear en Redis (1 hora)
-	if err := cache.SetJSON(ctx, cacheKey(req), items, 60*60); err != nil {
-		log.Printf("error cacheando recomendación en Redis: %v", err)
+// sobre-pedido de candidatas cuando hay filtros
+const filterOverfetch = 20
+
+// applyFilter recorre la lista ordenada por tramos, consultando en Mongo
+// qué películas cumplen el filtro, hasta juntar k o agotar la lista.
+func (s *RecommendService) applyFilter(ctx context.Context, items []models.RecItem, f models.RecFilter, k int) ([]models.RecItem, error) {
+	chunk := k * 4
+	if chunk < 100 {
+		chunk = 100
 	}
 
-	return items, nil
+	out := make([]models.RecItem, 0, k)
+	for from := 0; from < len(items) && len(out) < k; from += chunk {
+		to := from + chunk
+		if to > len(items) {
+			to = len(items)
+		}
+		ids := make([]int, to-from)
+		for i, it := range items[from:to] {
+			ids[i] = it.MovieID
+		}
+		ok, err := s.movies.FilterIDs(ctx, ids, f)
+		if err != nil {
+			return nil, err
+		}
+		for _, it := range items[from:to] {
+			if ok[it.MovieID] {
+				out = append(out, it)
+			}
+		}
+	}
+	return out, nil
+}
+
+// ====== Reintentos por shard ======
+
+// ShardTimeout es el tiempo máximo por intento de un shard (el timeout
+// global de la petición sigue siendo 10s).
+const ShardTimeout = 4 * time.Second
+
+// ShardProgress evento de progreso de un shard durante Recommend: uno
+// por cada intento contra un nodo (exitoso o no).
+type ShardProgress struct {
+	ShardID  int
+	Node     string // nodo que atendió el intento ("" en el evento final de un shard sin nodos)
+	Attempt  int
+	Partials int           // parciales devueltos (solo si Err == nil)
+	Elapsed  time.Duration // desde que se despacharon los shards
+	Err      error
+	Final    bool // el shard terminó: con éxito o sin más nodos para reintentar
+}
+
+// shardAttempt resultado de un intento, lo produce runShard.
+type shardAttempt struct {
+	shardID int
+	node    string
+	attempt int
+	resp    *cluster.RecResponse
+	err     error
+	elapsed time.Duration
+	final   bool
+}
+
+// failedNodes nodos que ya fallaron en esta petición, compartido entre
+// shards para no reintentar contra un nodo caído.
+type failedNodes struct {
+	mu  sync.Mutex
+	set map[string]bool
+}
+
+func (f *failedNodes) mark(addr string) {
+	f.mu.Lock()
+	f.set[addr] = true
+	f.mu.Unlock()
+}
+
+func (f *failedNodes) has(addr string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.set[addr]
+}
+
+// shardCandidates orden de nodos para un shard: primero el dueño en el
+// anillo (si está sano) y luego el resto de nodos sanos rotando por shard
+// para repartir la carga de los reintentos. Un nodo que no es dueño
+// resuelve la tarea igual, leyendo de Mongo lo que no tiene en memoria.
+func shardCandidates(primary string, shardID int, healthy []string) []string {
+	out := make([]string, 0, len(healthy))
+	for _, h := range healthy {
+		if h == primary {
+			out = append(out, primary)
+			break
+		}
+	}
+	n := len(healthy)
+	for i := 0; i < n; i++ {
+		if h := healthy[(shardID+i)%n]; h != primary {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// runShard manda la tarea a los candidatos en orden hasta que uno
+// responda, saltando los que ya fallaron en esta petición. Publica cada
+// intento en out; el último lleva final=true.
+func (s *RecommendService) runShard(
+	ctx context.Context,
+	candidates []string,
+	t *cluster.RecTask,
+	failed *failedNodes,
+	start time.Time,
+	out chan<- shardAttempt,
+) {
+
+	var lastErr error
+	attempt := 0
+	for i, addr := range candidates {
+		if i > 0 && failed.has(addr) {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		attempt++
+
+		shardCtx, cancel := context.WithTimeout(ctx, ShardTimeout)
+		resp, err := cluster.SendTask(shardCtx, addr, t)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("[recommend] shard %d recuperado en %s (intento %d)", t.ShardID, addr, attempt)
+			}
+			out <- shardAttempt{
+				shardID: t.ShardID, node: addr, attempt: attempt,
+				resp: resp, elapsed: time.Since(start), final: true,
+			}
+			return
+		}
+
+		log.Printf("[recommend] shard %d falló en %s: %v", t.ShardID, addr, err)
+		failed.mark(addr)
+		// si el nodo contestó con un error no está caído
+		var remote *cluster.RemoteError
+		if ctx.Err() == nil && !errors.As(err, &remote) {
+			s.nodes.MarkDown(addr, err)
+		}
+		lastErr = err
+
+		out <- shardAttempt{
+			shardID: t.ShardID, node: addr, attempt: attempt,
+			err: err, elapsed: time.Since(start),
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = ctx.Err()
+	}
+	if lastErr == nil {
+		// todos los candidatos ya habían fallado en esta petición
+		lastErr = ErrNoHealthyNodes
+	}
+	out <- shardAttempt{
+		shardID: t.ShardID, attempt: attempt,
+		err: fmt.Errorf("shard %d: %w", t.ShardID, lastErr), elapsed: time.Since(start), final: true,
+	}
 }
 
 // ====== Explicación de una recomendación (item-based puro) ======
 
-// Aquí sí tiene sentido usar algo/min_common/shrink porque se trabaja
-// directamente sobre la colección de similitudes precalculadas.
+// Se trabaja directamente sobre la colección de similitudes precalculadas:
+// minCommon y shrink ya se aplicaron al calcularlas (los vecinos no guardan
+// cuántos usuarios comparten), así que solo se elige la métrica.
 type ExplainRequest struct {
-	UserID    int
-	MovieID   int
-	Algo      string
-	MinCommon int
-	Shrink    int
+	UserID  int
+	MovieID int
+	Metric  string // ml.Metric*; "" = ml.DefaultMetric
 }
 
 // Explain reconstruye el score de una película recomendada
 // en base a sus vecinos y los ratings del usuario.
 func (s *RecommendService) Explain(ctx context.Context, req ExplainRequest) (*models.Explanation, error) {
 	// defaults
-	if req.Algo == "" {
-		req.Algo = "cosine"
+	metric, err := ml.ParseMetric(req.Metric)
+	if err != nil {
+		return nil, err
 	}
-	if req.MinCommon <= 0 {
-		req.MinCommon = 5
+	req.Metric = metric
+
+	// ratings del usuario más los implícitos, los mismos que usó la
+	// recomendación
+	ratings, err := s.ratings.GetAllByUser(ctx, req.UserID)
+	if err != nil {
+		return nil, err
 	}
-	if req.Shrink < 0 {
-		req.Shrink = 0
+	_, implicit, err := s.feedback(ctx, req.UserID, ratings)
+	if err != nil {
+		return nil, err
+	}
+	if len(ratings)+len(implicit) == 0 {
+		return nil, fmt.Errorf("%w: el usuario %d no tiene ratings", ErrCannotExplain, req.UserID)
 	}
 
-	// ratings del usuario
-	ratings, err := s.ratings.GetAllByUser(ctx, req.UserID)
+	movie, err := s.movies.GetByID(ctx, req.MovieID)
 	if err != nil {
 		return nil, err
 	}
-	if len(ratings) == 0 {
-		return nil, fmt.Errorf("el usuario %d no tiene ratings", req.UserID)
+	if movie == nil {
+		return nil, ErrMovieNotFound
 	}
 
 	// mapa movieId -> rating del usuario
-	ratingMap := make(map[int]float64, len(ratings))
+	ratingMap := make(map[int]float64, len(ratings)+len(implicit))
 	for _, r := range ratings {
 		ratingMap[r.MovieID] = r.Rating
 	}
+	implicitIDs := make(map[int]bool, len(implicit))
+	for _, r := range implicit {
+		ratingMap[r.MovieID] = r.Rating
+		implicitIDs[r.MovieID] = true
+	}
 
 	// vecinos de la película objetivo
-	neighbors, err := s.sims.GetNeighbors(ctx, req.MovieID, 100)
+	neighbors, err := s.sims.GetNeighbors(ctx, req.MovieID, req.Metric, 100)
 	if err != nil {
 		return nil, err
 	}
 	if len(neighbors) == 0 {
-		return nil, fmt.Errorf("no hay vecinos precalculados para movieId=%d", req.MovieID)
+		return nil, fmt.Errorf("%w: no hay vecinos precalculados (%s) para movieId=%d", ErrCannotExplain, req.Metric, req.MovieID)
 	}
 
 	var num, den float64
@@ -255,12 +915,13 @@ func (s *RecommendService) Explain(ctx context.Context, req ExplainRequest) (*mo
 			NeighborMovieID: n.MovieID,
 			Sim:             n.Sim,
 			UserRating:      userRating,
+			Implicit:        implicitIDs[n.MovieID],
 			Contribution:    partial, // luego normalizamos si quieres
 		})
 	}
 
 	if den == 0 {
-		return nil, fmt.Errorf("no se pudo explicar la recomendación (sin vecinos válidos)")
+		return nil, fmt.Errorf("%w: el usuario no valoró ninguno de sus vecinos", ErrCannotExplain)
 	}
 
 	score := num / den
@@ -272,11 +933,76 @@ func (s *RecommendService) Explain(ctx context.Context, req ExplainRequest) (*mo
 		}
 	}
 
+	sort.Slice(contribs, func(i, j int) bool { return contribs[i].Contribution > contribs[j].Contribution })
+
+	// títulos y pósters de la película y de los vecinos
+	ids := make([]int, len(contribs))
+	for i, c := range contribs {
+		ids[i] = c.NeighborMovieID
+	}
+	cards, err := s.movies.GetCardsByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range contribs {
+		m := cards[contribs[i].NeighborMovieID]
+		contribs[i].Title = m.Title
+		if m.ExternalData != nil {
+			contribs[i].PosterURL = m.ExternalData.PosterURL
+		}
+	}
+
 	exp := &models.Explanation{
 		MovieID:   req.MovieID,
+		Title:     movie.Title,
 		Score:     score,
+		Reason:    explainReason(contribs),
+		Metric:    req.Metric,
 		Neighbors: contribs,
 	}
+	if movie.ExternalData != nil {
+		exp.PosterURL = movie.ExternalData.PosterURL
+	}
 
 	return exp, nil
 }
+
+// ErrCannotExplain no hay datos para explicar la recomendación (usuario
+// sin ratings, película sin vecinos o ningún vecino valorado).
+var ErrCannotExplain = errors.New("no se puede explicar la recomendación")
+
+// explainReasonMax vecinos que se nombran en el texto de la explicación.
+const explainReasonMax = 2
+
+// explainReason texto legible con los vecinos que más aportan:
+// "Porque valoraste The Matrix con 5★ y Alien con 4.5★". Los vecinos que
+// entran por feedback implícito se nombran sin rating ("te interesó Alien").
+func explainReason(contribs []models.NeighborContribution) string {
+	var rated, viewed []string
+	for i, c := range contribs {
+		if i == explainReasonMax {
+			break
+		}
+		title := c.Title
+		if title == "" {
+			title = fmt.Sprintf("la película %d", c.NeighborMovieID)
+		}
+		if c.Implicit {
+			viewed = append(viewed, title)
+		} else {
+			rated = append(rated, fmt.Sprintf("%s con %s★", title, strconv.FormatFloat(c.UserRating, 'f', -1, 64)))
+		}
+	}
+
+	var parts []string
+	if len(rated) > 0 {
+		parts = append(parts, "valoraste "+strings.Join(rated, " y "))
+	}
+	if len(viewed) > 0 {
+		parts = append(parts, "te interesó "+strings.Join(viewed, " y "))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Porque " + strings.Join(parts, " y ")
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"nodosml-pc4/internal/cache"
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+)
+
+// Fuentes de /movies/{id}/similar.
+const (
+	SimilarSourceSimilarities = "similarities"
+	SimilarSourceContent      = "content"
+)
+
+// ErrMovieNotFound la película pedida no existe.
+var ErrMovieNotFound = errors.New("película no encontrada")
+
+// SimilarMovies vecinos de una película ("porque viste X"): primero los
+// precalculados en similarities (en la métrica pedida, "" = ml.DefaultMetric);
+// si la película no tiene documento (recién aprobada, sin ratings) se usa el
+// índice de contenido.
+func (s *RecommendService) SimilarMovies(ctx context.Context, movieID, k int, metric string, filter models.RecFilter) ([]models.SimilarMovie, error) {
+	metric, err := ml.ParseMetric(metric)
+	if err != nil {
+		return nil, err
+	}
+	if k <= 0 {
+		k = DefaultK
+	} else if k > MaxK {
+		k = MaxK
+	}
+
+	key := fmt.Sprintf("similar:movie:%d:k:%d", movieID, k)
+	if metric != ml.DefaultMetric {
+		key += ":sim:" + metric
+	}
+	if !filter.IsZero() {
+		key += ":f:" + filter.Key()
+	}
+	var cached []models.SimilarMovie
+	if ok, err := cache.GetJSON(ctx, key, &cached); err == nil && ok {
+		return cached, nil
+	}
+
+	movie, err := s.movies.GetByID(ctx, movieID)
+	if err != nil {
+		return nil, err
+	}
+	if movie == nil {
+		return nil, ErrMovieNotFound
+	}
+
+	// con filtros se piden más vecinos para que queden k
+	pool := k
+	if !filter.IsZero() {
+		pool = k * filterOverfetch
+	}
+
+	source := SimilarSourceSimilarities
+	neighbors, err := s.sims.GetNeighbors(ctx, movieID, metric, pool)
+	if err != nil {
+		return nil, err
+	}
+	if len(neighbors) == 0 {
+		source = SimilarSourceContent
+		ix, err := s.content.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		neighbors = ix.Similar(movieID, pool)
+	}
+
+	items := make([]models.RecItem, len(neighbors))
+	for i, n := range neighbors {
+		items[i] = models.RecItem{MovieID: n.MovieID, Score: n.Sim}
+	}
+	if !filter.IsZero() {
+		if items, err = s.applyFilter(ctx, items, filter, k); err != nil {
+			return nil, err
+		}
+	}
+	if len(items) > k {
+		items = items[:k]
+	}
+
+	ids := make([]int, len(items))
+	for i, it := range items {
+		ids[i] = it.MovieID
+	}
+	cards, err := s.movies.GetCardsByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]models.SimilarMovie, 0, len(items))
+	for _, it := range items {
+		m, ok := cards[it.MovieID]
+		if !ok {
+			continue // vecino borrado del catálogo
+		}
+		sm := models.SimilarMovie{
+			MovieID: m.MovieID,
+			Title:   m.Title,
+			Year:    m.Year,
+			Genres:  m.Genres,
+			Score:   it.Score,
+			Source:  source,
+		}
+		if m.ExternalData != nil {
+			sm.PosterURL = m.ExternalData.PosterURL
+		}
+		out = append(out, sm)
+	}
+
+	if err := cache.SetJSON(ctx, key, out, 60*60); err != nil {
+		log.Printf("error cacheando similares en Redis: %v", err)
+	}
+	return out, nil
+}
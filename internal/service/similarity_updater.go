@@ -0,0 +1,392 @@
+package service
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"nodosml-pc4/internal/cluster"
+	"nodosml-pc4/internal/ml"
+	"nodosml-pc4/internal/models"
+	"nodosml-pc4/internal/repository"
+)
+
+// Parámetros de las listas recalculadas: los mismos defaults que el
+// rebuild completo (POST /admin/maintenance/similarities/rebuild).
+const (
+	incrementalK         = 20
+	incrementalMinCommon = 3
+	incrementalShrink    = 20
+)
+
+const (
+	simUpdateInterval = 30 * time.Second
+	simUpdateBatch    = 200
+	// estadísticas más viejas que esto se vuelven a sembrar desde ratings
+	// la próxima vez que la película recibe un rating (acota la deriva)
+	simStatsMaxAge = 24 * time.Hour
+	simUniverseTTL = 10 * time.Minute
+	simPushTimeout = 10 * time.Second
+)
+
+// SimilarityUpdater mantiene las similitudes al día entre rebuilds. Cada
+// escritura de rating encola el cambio; un worker lo aplica como delta
+// sobre las estadísticas suficientes del coseno (productos punto, normas
+// y usuarios en común) y reescribe las listas de vecinos afectadas: la de
+// la película valorada y las de las películas que el usuario ya había
+// valorado. Las demás listas que contienen a la película solo notan el
+// cambio de su norma en el próximo rebuild.
+type SimilarityUpdater struct {
+	repo    *repository.SimilarityStatsRepository
+	ratings *repository.RatingRepository
+	movies  *repository.MovieRepository
+	sims    *repository.SimilarityRepository
+	nodes   *cluster.Registry
+	params  ml.SimilarityParams
+
+	// universo de vecinos (películas con iIdx) y sus normas L2; entre
+	// recargas las normas se mantienen con los deltas. Solo lo usa el worker.
+	norms    map[int]float64
+	iIdxOf   map[int]int
+	loadedAt time.Time
+
+	mu     sync.Mutex
+	status models.IncrementalSimilarityStatus
+}
+
+func NewSimilarityUpdater(
+	repo *repository.SimilarityStatsRepository,
+	ratings *repository.RatingRepository,
+	movies *repository.MovieRepository,
+	sims *repository.SimilarityRepository,
+	nodes *cluster.Registry,
+) *SimilarityUpdater {
+	p := ml.SimilarityParams{
+		K:              incrementalK,
+		MinCommonUsers: incrementalMinCommon,
+		Shrink:         incrementalShrink,
+	}
+	return &SimilarityUpdater{
+		repo:    repo,
+		ratings: ratings,
+		movies:  movies,
+		sims:    sims,
+		nodes:   nodes,
+		params:  p,
+		status: models.IncrementalSimilarityStatus{
+			K:              p.K,
+			MinCommonUsers: p.MinCommonUsers,
+			Shrink:         p.Shrink,
+		},
+	}
+}
+
+// Enqueue encola el cambio de un rating para el worker.
+func (s *SimilarityUpdater) Enqueue(ctx context.Context, c *models.RatingChange) error {
+	return s.repo.Enqueue(ctx, c)
+}
+
+// Start lanza el worker: cada simUpdateInterval vacía la cola en batches.
+func (s *SimilarityUpdater) Start(ctx context.Context) {
+	go func() {
+		t := time.NewTicker(simUpdateInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			for {
+				n, err := s.runOnce(ctx)
+				if err != nil {
+					log.Printf("[sim-updates] %v", err)
+				}
+				if err != nil || n < simUpdateBatch {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// Status estado del worker y tamaño de la cola.
+func (s *SimilarityUpdater) Status(ctx context.Context) (*models.IncrementalSimilarityStatus, error) {
+	pending, err := s.repo.PendingCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	st := s.status
+	s.mu.Unlock()
+	st.Pending = pending
+	return &st, nil
+}
+
+// runOnce aplica un batch de la cola y devuelve cuántos cambios leyó.
+func (s *SimilarityUpdater) runOnce(ctx context.Context) (int, error) {
+	changes, err := s.repo.Pending(ctx, simUpdateBatch)
+	if err != nil || len(changes) == 0 {
+		return 0, err
+	}
+	if err := s.loadUniverse(ctx); err != nil {
+		return 0, err
+	}
+
+	dirty := make(map[int]bool)
+	var applied, seeded int64
+	var runErr error
+	for _, c := range changes {
+		n, err := s.apply(ctx, c, dirty)
+		if err == nil {
+			err = s.repo.Ack(ctx, c.ID)
+		}
+		if err != nil {
+			// el cambio queda en la cola y se reintenta en la próxima vuelta
+			runErr = err
+			break
+		}
+		applied++
+		seeded += n
+	}
+
+	// lo ya aplicado se publica aunque el batch se haya cortado
+	docs, err := s.refresh(ctx, dirty)
+	if err != nil && runErr == nil {
+		runErr = err
+	}
+	s.push(docs)
+
+	now := time.Now()
+	s.mu.Lock()
+	s.status.Applied += applied
+	s.status.Seeded += seeded
+	s.status.Refreshed += int64(len(docs))
+	s.status.LastRunAt = &now
+	s.status.LastError = ""
+	if runErr != nil {
+		s.status.LastError = runErr.Error()
+	}
+	s.mu.Unlock()
+
+	if applied > 0 {
+		log.Printf("[sim-updates] cambios=%d sembradas=%d listas=%d", applied, seeded, len(docs))
+	}
+	if runErr != nil {
+		return int(applied), runErr
+	}
+	return len(changes), nil
+}
+
+// loadUniverse recarga normas y mapeo de iIdx cada simUniverseTTL.
+func (s *SimilarityUpdater) loadUniverse(ctx context.Context) error {
+	if s.norms != nil && time.Since(s.loadedAt) < simUniverseTTL {
+		return nil
+	}
+	start := time.Now()
+	iIdxOf, err := s.movies.IIdxMapping(ctx)
+	if err != nil {
+		return err
+	}
+	allNorms, err := s.ratings.ItemNorms(ctx)
+	if err != nil {
+		return err
+	}
+	norms := make(map[int]float64, len(iIdxOf))
+	for movieID := range iIdxOf {
+		if n, ok := allNorms[movieID]; ok {
+			norms[movieID] = n
+		}
+	}
+	s.norms, s.iIdxOf, s.loadedAt = norms, iIdxOf, start
+	return nil
+}
+
+// apply suma un cambio de rating a las estadísticas y marca las listas a
+// reescribir. Devuelve 1 si tuvo que sembrar la película valorada.
+//
+// Para el usuario u que cambia r_ui en d (y suma 1 usuario en común si el
+// rating es nuevo), por cada película j que u ya valoró:
+//
+//	dot(i,j) += d * r_uj    (en el documento de i y en el de j)
+//	|i|²     += r_ui'² - r_ui²
+//
+// Lo sembrado después del cambio ya lo incluye, así que solo se aplica a
+// documentos sembrados antes de c.At.
+func (s *SimilarityUpdater) apply(ctx context.Context, c models.RatingChange, dirty map[int]bool) (int64, error) {
+	if _, ok := s.iIdxOf[c.MovieID]; !ok {
+		return 0, nil // sin iIdx no tiene lista de vecinos ni es vecino de nadie
+	}
+	var old float64
+	common := 1
+	if c.HadOld {
+		old, common = c.Old, 0
+	}
+	d := c.New - old
+	if d == 0 && common == 0 {
+		return 0, nil
+	}
+	if !c.At.Before(s.loadedAt) {
+		sq := s.norms[c.MovieID]*s.norms[c.MovieID] + c.New*c.New - old*old
+		s.norms[c.MovieID] = math.Sqrt(math.Max(sq, 0))
+	}
+
+	userRatings, err := s.ratings.GetAllByUser(ctx, c.UserID)
+	if err != nil {
+		return 0, err
+	}
+	others := make(map[int]float64, len(userRatings))
+	ids := []int{c.MovieID}
+	for _, r := range userRatings {
+		if _, ok := s.iIdxOf[r.MovieID]; ok && r.MovieID != c.MovieID {
+			others[r.MovieID] = r.Rating
+			ids = append(ids, r.MovieID)
+		}
+	}
+	seededAt, err := s.repo.SeededAt(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	var seeded int64
+	if t, ok := seededAt[c.MovieID]; !ok || time.Since(t) > simStatsMaxAge {
+		if seededAt[c.MovieID], err = s.seed(ctx, c.MovieID); err != nil {
+			return 0, err
+		}
+		seeded = 1
+	}
+	dirty[c.MovieID] = true
+
+	applyTo := func(movieID int) bool {
+		t, ok := seededAt[movieID]
+		return ok && !c.At.Before(t)
+	}
+	var deltas []models.PairDelta
+	for j, rj := range others {
+		if applyTo(c.MovieID) {
+			deltas = append(deltas, models.PairDelta{MovieID: c.MovieID, Other: j, Dot: d * rj, Common: common})
+		}
+		if applyTo(j) {
+			deltas = append(deltas, models.PairDelta{MovieID: j, Other: c.MovieID, Dot: d * rj, Common: common})
+			dirty[j] = true
+		}
+	}
+	return seeded, s.repo.ApplyDeltas(ctx, deltas)
+}
+
+// seed calcula desde ratings las estadísticas completas de una película
+// (como hace el rebuild en los nodos) y devuelve el momento de la siembra.
+func (s *SimilarityUpdater) seed(ctx context.Context, movieID int) (time.Time, error) {
+	start := time.Now()
+	targetRatings, err := s.ratings.GetByMovie(ctx, movieID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	raters := make(map[int]float64, len(targetRatings))
+	userIDs := make([]int, 0, len(targetRatings))
+	var sumSq float64
+	for _, r := range targetRatings {
+		raters[r.UserID] = r.Rating
+		userIDs = append(userIDs, r.UserID)
+		sumSq += r.Rating * r.Rating
+	}
+	coRatings, err := s.ratings.GetByUsers(ctx, userIDs)
+	if err != nil {
+		return time.Time{}, err
+	}
+	byUser := make(map[int][]models.RatingDoc, len(userIDs))
+	for _, r := range coRatings {
+		byUser[r.UserID] = append(byUser[r.UserID], r)
+	}
+
+	dots, common := ml.CoRatingStats(movieID, raters, byUser, s.norms)
+	pairs := make(map[int]models.PairStat, len(dots))
+	for j, dot := range dots {
+		pairs[j] = models.PairStat{Dot: dot, Common: common[j]}
+	}
+	// de paso la norma exacta
+	s.norms[movieID] = math.Sqrt(sumSq)
+
+	err = s.repo.Replace(ctx, &models.ItemPairStats{
+		MovieID:   movieID,
+		Pairs:     pairs,
+		SeededAt:  start,
+		UpdatedAt: time.Now(),
+	})
+	return start, err
+}
+
+// refresh reescribe las listas de vecinos de las películas marcadas a
+// partir de sus estadísticas.
+func (s *SimilarityUpdater) refresh(ctx context.Context, dirty map[int]bool) ([]models.SimilarityDoc, error) {
+	ids := make([]int, 0, len(dirty))
+	for id := range dirty {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var docs []models.SimilarityDoc
+	for _, movieID := range ids {
+		st, err := s.repo.Get(ctx, movieID)
+		if err != nil {
+			return docs, err
+		}
+		if st == nil {
+			continue
+		}
+		dots := make(map[int]float64, len(st.Pairs))
+		common := make(map[int]int, len(st.Pairs))
+		for j, p := range st.Pairs {
+			dots[j], common[j] = p.Dot, p.Common
+		}
+		scored := ml.CosineFromStats(movieID, dots, common, s.norms, s.params)
+
+		neighbors := make([]models.Neighbor, 0, len(scored))
+		for _, sc := range scored {
+			neighbors = append(neighbors, models.Neighbor{
+				MovieID: sc.MovieID,
+				IIdx:    s.iIdxOf[sc.MovieID],
+				Sim:     sc.Sim,
+			})
+		}
+		doc := models.SimilarityDoc{
+			MovieID:   movieID,
+			IIdx:      s.iIdxOf[movieID],
+			Metric:    ml.MetricCosine,
+			K:         s.params.K,
+			Neighbors: neighbors,
+			UpdatedAt: time.Now().Format(time.RFC3339),
+		}
+		if err := s.sims.Upsert(ctx, &doc); err != nil {
+			return docs, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// push manda las listas nuevas a los nodos para que reemplacen esas filas
+// de su índice en memoria (best effort: si falla, el nodo las ve en la
+// próxima recarga).
+func (s *SimilarityUpdater) push(docs []models.SimilarityDoc) {
+	if len(docs) == 0 {
+		return
+	}
+	upd := &cluster.NeighborsUpdate{Docs: docs}
+	var wg sync.WaitGroup
+	for _, addr := range s.nodes.Healthy() {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), simPushTimeout)
+			defer cancel()
+			if _, err := cluster.UpdateNeighbors(ctx, addr, upd); err != nil {
+				log.Printf("[sim-updates] %s no tomó los vecinos nuevos: %v", addr, err)
+			}
+		}(addr)
+	}
+	wg.Wait()
+}